@@ -0,0 +1,55 @@
+package aws
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"context"
+	"net/http"
+
+	awsStd "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// mfaTokenProvider prompts for MFA codes, replaced in tests.
+var mfaTokenProvider = stscreds.StdinTokenProvider
+
+// LoadConfig loads the SDK config for region with the credentials selected by opts.
+// The shared config profile is loaded first, an explicit role is then assumed with it.
+// MFA codes are read from stdin both for -mfaSerial and for profiles with mfa_serial.
+// Failed calls are retried as configured by retryOpts.
+func LoadConfig(ctx context.Context, region string, httpClient *http.Client, opts *cli.CredentialOptions, retryOpts *cli.RetryOptions) (awsStd.Config, error) {
+	loadOptions := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+		config.WithHTTPClient(httpClient),
+		config.WithRetryer(func() awsStd.Retryer {
+			return NewRetryer(retryOpts)
+		}),
+		config.WithAssumeRoleCredentialOptions(func(o *stscreds.AssumeRoleOptions) {
+			o.TokenProvider = mfaTokenProvider
+		}),
+	}
+	if opts.Profile != "" {
+		loadOptions = append(loadOptions, config.WithSharedConfigProfile(opts.Profile))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, loadOptions...)
+	if err != nil {
+		return cfg, err
+	}
+
+	if opts.AssumeRoleArn != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), opts.AssumeRoleArn, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = opts.RoleSessionName
+			if opts.ExternalId != "" {
+				o.ExternalID = &opts.ExternalId
+			}
+			if opts.MfaSerial != "" {
+				o.SerialNumber = &opts.MfaSerial
+				o.TokenProvider = mfaTokenProvider
+			}
+		})
+		cfg.Credentials = awsStd.NewCredentialsCache(provider)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,77 @@
+package aws
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	awsStd "github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func setEnv(t *testing.T, key string, value string) {
+	previous, set := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if set {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func writeSharedCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	contents := "[edge]\naws_access_key_id = AKIAEDGE\naws_secret_access_key = secret\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(t.TempDir(), "config")
+	if err := ioutil.WriteFile(configPath, []byte("[default]\nregion = us-west-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setEnv(t, "AWS_SHARED_CREDENTIALS_FILE", path)
+	setEnv(t, "AWS_CONFIG_FILE", configPath)
+	setEnv(t, "AWS_CA_BUNDLE", "")
+}
+
+func TestLoadConfigProfile(t *testing.T) {
+	writeSharedCredentials(t)
+
+	cfg, err := LoadConfig(context.Background(), "eu-west-1", http.DefaultClient, &cli.CredentialOptions{Profile: "edge"}, &cli.RetryOptions{MaxAttempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Region != "eu-west-1" {
+		t.Errorf("Expected region eu-west-1, got %s", cfg.Region)
+	}
+	credentials, err := cfg.Credentials.Retrieve(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.AccessKeyID != "AKIAEDGE" {
+		t.Errorf("Expected credentials of profile edge, got %s", credentials.AccessKeyID)
+	}
+}
+
+func TestLoadConfigAssumeRole(t *testing.T) {
+	writeSharedCredentials(t)
+
+	opts := &cli.CredentialOptions{
+		Profile:         "edge",
+		AssumeRoleArn:   "arn:aws:iam::012345678912:role/EdgeSetup",
+		ExternalId:      "external",
+		RoleSessionName: "test",
+	}
+	cfg, err := LoadConfig(context.Background(), "eu-west-1", http.DefaultClient, opts, &cli.RetryOptions{MaxAttempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.Credentials.(*awsStd.CredentialsCache); !ok {
+		t.Errorf("Expected cached assume role credentials, got %T", cfg.Credentials)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/service/iam"
@@ -22,7 +21,7 @@ type IamClient interface {
 	CreatePolicy(ctx context.Context, params *iam.CreatePolicyInput, optFns ...func(*iam.Options)) (*iam.CreatePolicyOutput, error)
 }
 
-func CreateDeviceFleetRole(client IamClient, fleetName *string, roleName *string) *types.Role {
+func CreateDeviceFleetRole(ctx context.Context, client IamClient, fleetName *string, roleName *string) (*types.Role, error) {
 	assumeRolePolicyDocument := `{
 		"Version": "2012-10-17",
 		"Statement": [
@@ -39,53 +38,54 @@ func CreateDeviceFleetRole(client IamClient, fleetName *string, roleName *string
 		]
 	}`
 
-	result, err := client.CreateRole(context.TODO(), &iam.CreateRoleInput{
+	result, err := client.CreateRole(ctx, &iam.CreateRoleInput{
 		AssumeRolePolicyDocument: &assumeRolePolicyDocument,
 		RoleName:                 roleName,
 	})
 
 	if err != nil {
-		log.Fatalf("Failed to create role with role name %s. Encountered Error %s\n", *roleName, err)
+		return nil, fmt.Errorf("failed to create role %s: %w", *roleName, err)
 	}
 
-	return result.Role
+	return result.Role, nil
 }
 
-func GetDeviceFleetRole(client IamClient, fleetName *string, roleName *string) *types.Role {
-	result, err := client.GetRole(context.TODO(), &iam.GetRoleInput{
+// GetDeviceFleetRole returns nil without error if the role does not exist.
+func GetDeviceFleetRole(ctx context.Context, client IamClient, fleetName *string, roleName *string) (*types.Role, error) {
+	result, err := client.GetRole(ctx, &iam.GetRoleInput{
 		RoleName: roleName,
 	})
 
 	if err != nil {
 		var nse *types.NoSuchEntityException
 		if errors.As(err, &nse) {
-			log.Println("Role doesn't exist.")
-			return nil
+			Logger(ctx).Println("Role doesn't exist.")
+			return nil, nil
 		}
-		log.Fatalf("Failed to get role with role name %s. Encountered error %s\n", *roleName, err)
+		return nil, fmt.Errorf("failed to get role %s: %w", *roleName, err)
 	}
 
-	return result.Role
+	return result.Role, nil
 }
 
-func CheckIfPolicyIsAlreadyAttachedToTheRole(client IamClient, roleName *string, policyName *string) *types.AttachedPolicy {
+func CheckIfPolicyIsAlreadyAttachedToTheRole(ctx context.Context, client IamClient, roleName *string, policyName *string) (*types.AttachedPolicy, error) {
 	maxItems := int32(100)
 	var marker *string
 
 	for {
-		ret, err := client.ListAttachedRolePolicies(context.TODO(), &iam.ListAttachedRolePoliciesInput{
+		ret, err := client.ListAttachedRolePolicies(ctx, &iam.ListAttachedRolePoliciesInput{
 			RoleName: roleName,
 			MaxItems: &maxItems,
 			Marker:   marker,
 		})
 
 		if err != nil {
-			log.Fatalf("Failed to list attached role policies for %s. Encountered Error %s\n", *roleName, err)
+			return nil, fmt.Errorf("failed to list attached role policies for %s: %w", *roleName, err)
 		}
 
 		for _, policy := range ret.AttachedPolicies {
 			if *policy.PolicyName == *policyName {
-				return &policy
+				return &policy, nil
 			}
 		}
 
@@ -96,18 +96,19 @@ func CheckIfPolicyIsAlreadyAttachedToTheRole(client IamClient, roleName *string,
 		}
 	}
 
-	return nil
+	return nil, nil
 }
 
-func AttachAmazonSageMakerEdgeDeviceFleetPolicy(client IamClient, role *types.Role, policyArn *string) {
-	_, err := client.AttachRolePolicy(context.TODO(), &iam.AttachRolePolicyInput{
+func AttachAmazonSageMakerEdgeDeviceFleetPolicy(ctx context.Context, client IamClient, role *types.Role, policyArn *string) error {
+	_, err := client.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
 		PolicyArn: policyArn,
 		RoleName:  role.RoleName,
 	})
 
 	if err != nil {
-		log.Fatalf("Failed to attach policy %s to role name %s. Encountered error %s\n", *policyArn, *role.RoleName, err)
+		return fmt.Errorf("failed to attach policy %s to role %s: %w", *policyArn, *role.RoleName, err)
 	}
+	return nil
 }
 
 type Principal struct {
@@ -128,7 +129,15 @@ type PolicyDocument struct {
 	Statement []StatementEntry
 }
 
-func CreateDeviceFleetBucketPolicy(client IamClient, cliArgs *cli.CliArgs) *types.Policy {
+func DeviceFleetPolicyName(cliArgs *cli.CliArgs) string {
+	return fmt.Sprintf("%s-policy", strings.ToLower(cliArgs.DeviceFleet))
+}
+
+func DeviceFleetBucketPolicyName(cliArgs *cli.CliArgs) string {
+	return fmt.Sprintf("%s-%s-policy", strings.ToLower(cliArgs.DeviceFleet), strings.ToLower(cliArgs.DeviceFleetBucket))
+}
+
+func CreateDeviceFleetBucketPolicy(ctx context.Context, client IamClient, cliArgs *cli.CliArgs) (*types.Policy, error) {
 	policyDocument := &PolicyDocument{
 		Version: "2012-10-17",
 		Statement: []StatementEntry{
@@ -152,17 +161,17 @@ func CreateDeviceFleetBucketPolicy(client IamClient, cliArgs *cli.CliArgs) *type
 
 	policyDescription := fmt.Sprintf("SageMaker device fleet bucket policy for %s", cliArgs.DeviceFleet)
 	policyPath := "/"
-	policyName := fmt.Sprintf("%s-%s-policy", strings.ToLower(cliArgs.DeviceFleet), strings.ToLower(cliArgs.DeviceFleetBucket))
+	policyName := DeviceFleetBucketPolicyName(cliArgs)
 	policyArn := fmt.Sprintf("arn:aws:iam::%s:policy/%s", cliArgs.Account, policyName)
 
-	getPolicyOutput, err := client.GetPolicy(context.TODO(), &iam.GetPolicyInput{
+	getPolicyOutput, err := client.GetPolicy(ctx, &iam.GetPolicyInput{
 		PolicyArn: &policyArn,
 	})
 
 	if err != nil {
 		var nse *types.NoSuchEntityException
 		if errors.As(err, &nse) {
-			ret, err := client.CreatePolicy(context.TODO(), &iam.CreatePolicyInput{
+			ret, err := client.CreatePolicy(ctx, &iam.CreatePolicyInput{
 				Description:    &policyDescription,
 				Path:           &policyPath,
 				PolicyDocument: &policyDoc,
@@ -170,19 +179,19 @@ func CreateDeviceFleetBucketPolicy(client IamClient, cliArgs *cli.CliArgs) *type
 			})
 
 			if err != nil {
-				log.Fatalf("Failed to create policy with policy name %s. Encountered error %s\n", policyName, err)
+				return nil, fmt.Errorf("failed to create policy %s: %w", policyName, err)
 			}
 
-			return ret.Policy
+			return ret.Policy, nil
 		}
 
-		log.Fatalf("Failed to get policy with name %s. Encountered error %s\n", policyName, err)
+		return nil, fmt.Errorf("failed to get policy %s: %w", policyName, err)
 	}
 
-	return getPolicyOutput.Policy
+	return getPolicyOutput.Policy, nil
 }
 
-func CreateDeviceFleetPolicy(client IamClient, cliArgs *cli.CliArgs) *types.Policy {
+func CreateDeviceFleetPolicy(ctx context.Context, client IamClient, cliArgs *cli.CliArgs) (*types.Policy, error) {
 	var condition map[string]interface{}
 	conditionByt := []byte(` {
 		"StringEqualsIfExists": {
@@ -194,7 +203,7 @@ func CreateDeviceFleetPolicy(client IamClient, cliArgs *cli.CliArgs) *types.Poli
 	}`)
 
 	if err := json.Unmarshal(conditionByt, &condition); err != nil {
-		log.Fatal("Invaild json doc. Encountered err ", err)
+		return nil, err
 	}
 
 	policyDocument := &PolicyDocument{
@@ -255,17 +264,17 @@ func CreateDeviceFleetPolicy(client IamClient, cliArgs *cli.CliArgs) *types.Poli
 
 	policyDescription := fmt.Sprintf("SageMaker device fleet policy for %s", cliArgs.DeviceFleet)
 	policyPath := "/"
-	policyName := fmt.Sprintf("%s-policy", strings.ToLower(cliArgs.DeviceFleet))
+	policyName := DeviceFleetPolicyName(cliArgs)
 	policyArn := fmt.Sprintf("arn:aws:iam::%s:policy/%s", cliArgs.Account, policyName)
 
-	getPolicyOutput, err := client.GetPolicy(context.TODO(), &iam.GetPolicyInput{
+	getPolicyOutput, err := client.GetPolicy(ctx, &iam.GetPolicyInput{
 		PolicyArn: &policyArn,
 	})
 
 	if err != nil {
 		var nse *types.NoSuchEntityException
 		if errors.As(err, &nse) {
-			ret, err := client.CreatePolicy(context.TODO(), &iam.CreatePolicyInput{
+			ret, err := client.CreatePolicy(ctx, &iam.CreatePolicyInput{
 				Description:    &policyDescription,
 				Path:           &policyPath,
 				PolicyDocument: &policyDoc,
@@ -273,35 +282,41 @@ func CreateDeviceFleetPolicy(client IamClient, cliArgs *cli.CliArgs) *types.Poli
 			})
 
 			if err != nil {
-				log.Fatalf("Failed to create policy with name %s. Encountered error %s\n", policyName, err)
+				return nil, fmt.Errorf("failed to create policy %s: %w", policyName, err)
 			}
 
-			return ret.Policy
+			return ret.Policy, nil
 		}
 
-		log.Fatalf("Failed to get policy with name %s. Encountered error %s\n", policyName, err)
+		return nil, fmt.Errorf("failed to get policy %s: %w", policyName, err)
 	}
 
-	return getPolicyOutput.Policy
+	return getPolicyOutput.Policy, nil
 }
 
-func CreateDeviceFleetRoleIfNotExists(client IamClient, fleetName *string, roleName *string, fleetPolicy *types.Policy, bucketPolicy *types.Policy) *types.Role {
-	role := GetDeviceFleetRole(client, fleetName, roleName)
-	if role == nil {
-		role = CreateDeviceFleetRole(client, fleetName, roleName)
+func CreateDeviceFleetRoleIfNotExists(ctx context.Context, client IamClient, fleetName *string, roleName *string, fleetPolicy *types.Policy, bucketPolicy *types.Policy) (*types.Role, error) {
+	role, err := GetDeviceFleetRole(ctx, client, fleetName, roleName)
+	if err != nil {
+		return nil, err
 	}
-	attachedFleetPolicy := CheckIfPolicyIsAlreadyAttachedToTheRole(client, role.RoleName, fleetPolicy.PolicyName)
-
-	if attachedFleetPolicy == nil {
-		log.Println("Attaching device fleet policy")
-		AttachAmazonSageMakerEdgeDeviceFleetPolicy(client, role, fleetPolicy.Arn)
+	if role == nil {
+		if role, err = CreateDeviceFleetRole(ctx, client, fleetName, roleName); err != nil {
+			return nil, err
+		}
 	}
 
-	attachedBucketPolicy := CheckIfPolicyIsAlreadyAttachedToTheRole(client, role.RoleName, bucketPolicy.PolicyName)
+	for _, policy := range []*types.Policy{fleetPolicy, bucketPolicy} {
+		attachedPolicy, err := CheckIfPolicyIsAlreadyAttachedToTheRole(ctx, client, role.RoleName, policy.PolicyName)
+		if err != nil {
+			return nil, err
+		}
 
-	if attachedBucketPolicy == nil {
-		log.Println("Attaching device fleet bucket policy")
-		AttachAmazonSageMakerEdgeDeviceFleetPolicy(client, role, bucketPolicy.Arn)
+		if attachedPolicy == nil {
+			Logger(ctx).Printf("Attaching policy %s\n", *policy.PolicyName)
+			if err := AttachAmazonSageMakerEdgeDeviceFleetPolicy(ctx, client, role, policy.Arn); err != nil {
+				return nil, err
+			}
+		}
 	}
-	return role
+	return role, nil
 }
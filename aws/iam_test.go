@@ -79,7 +79,10 @@ func TestCreateDeviceFleetRole(t *testing.T) {
 
 		return &createRoleOutput, nil
 	}
-	deviceFleetRole := CreateDeviceFleetRole(client, &testFleetName, &roleName)
+	deviceFleetRole, err := CreateDeviceFleetRole(context.Background(), client, &testFleetName, &roleName)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if deviceFleetRole.RoleName != &roleName {
 		t.Fatalf("Invalid Role Name")
@@ -107,11 +110,17 @@ func TestGetDeviceFleetRole(t *testing.T) {
 		return &getRoleOutput, nil
 	}
 
-	role := GetDeviceFleetRole(client, &dummyFleet, &dummyRoleName)
+	role, err := GetDeviceFleetRole(context.Background(), client, &dummyFleet, &dummyRoleName)
 
-	if role.RoleName != &dummyRoleName {
+	if err != nil || role.RoleName != &dummyRoleName {
 		t.Fatalf("Invalid Role Name")
 	}
+
+	role, err = GetDeviceFleetRole(context.Background(), client, &dummyFleet, &nonExistentRoleName)
+
+	if err != nil || role != nil {
+		t.Fatalf("Should return nil for non existent role")
+	}
 }
 
 func TestCheckIfPolicyIsAlreadyAttachedToTheRole(t *testing.T) {
@@ -130,13 +139,13 @@ func TestCheckIfPolicyIsAlreadyAttachedToTheRole(t *testing.T) {
 		}, nil
 	}
 
-	policy := CheckIfPolicyIsAlreadyAttachedToTheRole(client, &dummyRoleName, &unAttachedPolicy)
+	policy, err := CheckIfPolicyIsAlreadyAttachedToTheRole(context.Background(), client, &dummyRoleName, &unAttachedPolicy)
 
-	if policy != nil {
+	if err != nil || policy != nil {
 		t.Fatalf("Policy should return nil!")
 	}
 
-	policy = CheckIfPolicyIsAlreadyAttachedToTheRole(client, &dummyRoleName, &attachedPolicy)
+	policy, _ = CheckIfPolicyIsAlreadyAttachedToTheRole(context.Background(), client, &dummyRoleName, &attachedPolicy)
 
 	if policy == nil {
 		t.Fatalf("Policy should not return nil!")
@@ -168,9 +177,9 @@ func TestCreateDeviceFleetPolicy(t *testing.T) {
 		}, nil
 	}
 
-	policy := CreateDeviceFleetPolicy(client, &cliArgs)
+	policy, err := CreateDeviceFleetPolicy(context.Background(), client, &cliArgs)
 
-	if *policy.PolicyName != policyName1 {
+	if err != nil || *policy.PolicyName != policyName1 {
 		t.Fatalf("Invalid response")
 	}
 
@@ -183,9 +192,9 @@ func TestCreateDeviceFleetPolicy(t *testing.T) {
 		}, nil
 	}
 
-	policy = CreateDeviceFleetPolicy(client, &cliArgs)
+	policy, err = CreateDeviceFleetPolicy(context.Background(), client, &cliArgs)
 
-	if *policy.PolicyName != policyName2 {
+	if err != nil || *policy.PolicyName != policyName2 {
 		t.Fatalf("Invalid response")
 	}
 }
@@ -6,9 +6,9 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/iot"
 	"github.com/aws/aws-sdk-go-v2/service/iot/types"
-	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -17,27 +17,41 @@ type IotClient interface {
 	CreateThingType(ctx context.Context, params *iot.CreateThingTypeInput, optFns ...func(*iot.Options)) (*iot.CreateThingTypeOutput, error)
 	DescribeThing(ctx context.Context, params *iot.DescribeThingInput, optFns ...func(*iot.Options)) (*iot.DescribeThingOutput, error)
 	CreateThing(ctx context.Context, params *iot.CreateThingInput, optFns ...func(*iot.Options)) (*iot.CreateThingOutput, error)
+	DeleteThing(ctx context.Context, params *iot.DeleteThingInput, optFns ...func(*iot.Options)) (*iot.DeleteThingOutput, error)
 	CreateKeysAndCertificate(ctx context.Context, params *iot.CreateKeysAndCertificateInput, optFns ...func(*iot.Options)) (*iot.CreateKeysAndCertificateOutput, error)
+	DescribeCertificate(ctx context.Context, params *iot.DescribeCertificateInput, optFns ...func(*iot.Options)) (*iot.DescribeCertificateOutput, error)
+	UpdateCertificate(ctx context.Context, params *iot.UpdateCertificateInput, optFns ...func(*iot.Options)) (*iot.UpdateCertificateOutput, error)
+	DeleteCertificate(ctx context.Context, params *iot.DeleteCertificateInput, optFns ...func(*iot.Options)) (*iot.DeleteCertificateOutput, error)
 	DescribeEndpoint(ctx context.Context, params *iot.DescribeEndpointInput, optFns ...func(*iot.Options)) (*iot.DescribeEndpointOutput, error)
 	AttachThingPrincipal(ctx context.Context, params *iot.AttachThingPrincipalInput, optFns ...func(*iot.Options)) (*iot.AttachThingPrincipalOutput, error)
+	DetachThingPrincipal(ctx context.Context, params *iot.DetachThingPrincipalInput, optFns ...func(*iot.Options)) (*iot.DetachThingPrincipalOutput, error)
+	ListThingPrincipals(ctx context.Context, params *iot.ListThingPrincipalsInput, optFns ...func(*iot.Options)) (*iot.ListThingPrincipalsOutput, error)
 	CreatePolicy(ctx context.Context, params *iot.CreatePolicyInput, optFns ...func(*iot.Options)) (*iot.CreatePolicyOutput, error)
 	AttachPolicy(ctx context.Context, params *iot.AttachPolicyInput, optFns ...func(*iot.Options)) (*iot.AttachPolicyOutput, error)
+	DetachPolicy(ctx context.Context, params *iot.DetachPolicyInput, optFns ...func(*iot.Options)) (*iot.DetachPolicyOutput, error)
+	DeletePolicy(ctx context.Context, params *iot.DeletePolicyInput, optFns ...func(*iot.Options)) (*iot.DeletePolicyOutput, error)
+	ListAttachedPolicies(ctx context.Context, params *iot.ListAttachedPoliciesInput, optFns ...func(*iot.Options)) (*iot.ListAttachedPoliciesOutput, error)
 }
 
-func GetIotThingType(client IotClient, iotThingType *string) *iot.DescribeThingTypeOutput {
-	ret, err := client.DescribeThingType(context.TODO(), &iot.DescribeThingTypeInput{
+// RoleAliasPolicyPrefix starts the names of the iot policies allowing a certificate to
+// assume the role alias of its fleet.
+const RoleAliasPolicyPrefix = "aliaspolicy-"
+
+// GetIotThingType returns nil without error if the thing type does not exist.
+func GetIotThingType(ctx context.Context, client IotClient, iotThingType *string) (*iot.DescribeThingTypeOutput, error) {
+	ret, err := client.DescribeThingType(ctx, &iot.DescribeThingTypeInput{
 		ThingTypeName: iotThingType,
 	})
 
 	if err != nil {
 		var rnf *types.ResourceNotFoundException
 		if errors.As(err, &rnf) {
-			return nil
+			return nil, nil
 		}
-		log.Fatalf("Failed to describe thing type %s, Encountered error %s\n", *iotThingType, err)
+		return nil, fmt.Errorf("failed to describe thing type %s: %w", *iotThingType, err)
 	}
 
-	return ret
+	return ret, nil
 }
 
 type CreateIotThingTypeOutput struct {
@@ -46,35 +60,39 @@ type CreateIotThingTypeOutput struct {
 	ThingTypeName *string
 }
 
-func CreateIotThingType(client IotClient, iotThingType *string) *CreateIotThingTypeOutput {
+func CreateIotThingType(ctx context.Context, client IotClient, iotThingType *string) (*CreateIotThingTypeOutput, error) {
 
-	describeThingTypeOutput := GetIotThingType(client, iotThingType)
+	describeThingTypeOutput, err := GetIotThingType(ctx, client, iotThingType)
+	if err != nil {
+		return nil, err
+	}
 
 	if describeThingTypeOutput != nil {
 		return &CreateIotThingTypeOutput{
 			ThingTypeName: describeThingTypeOutput.ThingTypeName,
 			ThingTypeArn:  describeThingTypeOutput.ThingTypeArn,
 			ThingTypeId:   describeThingTypeOutput.ThingTypeId,
-		}
+		}, nil
 	}
 
-	ret, err := client.CreateThingType(context.TODO(), &iot.CreateThingTypeInput{
+	ret, err := client.CreateThingType(ctx, &iot.CreateThingTypeInput{
 		ThingTypeName: iotThingType,
 	})
 
 	if err != nil {
-		log.Fatalf("Failed to create thing type %s.Encountered error %s\n", *iotThingType, err)
+		return nil, fmt.Errorf("failed to create thing type %s: %w", *iotThingType, err)
 	}
 
 	return &CreateIotThingTypeOutput{
 		ThingTypeArn:  ret.ThingTypeArn,
 		ThingTypeId:   ret.ThingTypeId,
 		ThingTypeName: ret.ThingTypeName,
-	}
+	}, nil
 }
 
-func GetIotThing(client IotClient, iotThingName *string) *iot.DescribeThingOutput {
-	ret, err := client.DescribeThing(context.TODO(), &iot.DescribeThingInput{
+// GetIotThing returns nil without error if the thing does not exist.
+func GetIotThing(ctx context.Context, client IotClient, iotThingName *string) (*iot.DescribeThingOutput, error) {
+	ret, err := client.DescribeThing(ctx, &iot.DescribeThingInput{
 		ThingName: iotThingName,
 	})
 
@@ -82,13 +100,13 @@ func GetIotThing(client IotClient, iotThingName *string) *iot.DescribeThingOutpu
 		var rne *types.ResourceNotFoundException
 
 		if errors.As(err, &rne) {
-			log.Println("Thing doesn't exist")
-			return nil
+			Logger(ctx).Println("Thing doesn't exist")
+			return nil, nil
 		}
-		log.Fatalf("Failed to describe thing %s. Encountered error %s\n", *iotThingName, err)
+		return nil, fmt.Errorf("failed to describe thing %s: %w", *iotThingName, err)
 	}
 
-	return ret
+	return ret, nil
 }
 
 type CreateIotThingOutput struct {
@@ -98,96 +116,108 @@ type CreateIotThingOutput struct {
 	ThingTypeName *string
 }
 
-func CreateIotThing(client IotClient, iotThingType *string, iotThingName *string) *CreateIotThingOutput {
+func CreateIotThing(ctx context.Context, client IotClient, iotThingType *string, iotThingName *string) (*CreateIotThingOutput, error) {
 
-	describeThingOutput := GetIotThing(client, iotThingName)
+	describeThingOutput, err := GetIotThing(ctx, client, iotThingName)
+	if err != nil {
+		return nil, err
+	}
 
 	if describeThingOutput != nil {
 		return &CreateIotThingOutput{
 			ThingName: describeThingOutput.ThingName,
 			ThingId:   describeThingOutput.ThingId,
 			ThingArn:  describeThingOutput.ThingArn,
-		}
+		}, nil
 	}
 
-	ret, err := client.CreateThing(context.TODO(), &iot.CreateThingInput{
+	ret, err := client.CreateThing(ctx, &iot.CreateThingInput{
 		ThingName:     iotThingName,
 		ThingTypeName: iotThingType,
 	})
 
 	if err != nil {
-		log.Fatalf("Failed to create thing %s. Encountered error %s\n", *iotThingName, err)
+		return nil, fmt.Errorf("failed to create thing %s: %w", *iotThingName, err)
 	}
 
 	return &CreateIotThingOutput{
 		ThingName: ret.ThingName,
 		ThingId:   ret.ThingId,
 		ThingArn:  ret.ThingArn,
-	}
+	}, nil
 }
 
-func CreateIOTCertificates(client IotClient) *iot.CreateKeysAndCertificateOutput {
-	ret, err := client.CreateKeysAndCertificate(context.TODO(), &iot.CreateKeysAndCertificateInput{
+func CreateIOTCertificates(ctx context.Context, client IotClient) (*iot.CreateKeysAndCertificateOutput, error) {
+	ret, err := client.CreateKeysAndCertificate(ctx, &iot.CreateKeysAndCertificateInput{
 		SetAsActive: true,
 	})
 
 	if err != nil {
-		log.Fatalf("Failed to create keys and certificates. Encountered error %s\n", err)
+		return nil, fmt.Errorf("failed to create keys and certificates: %w", err)
 	}
-	return ret
+	return ret, nil
 }
 
-func writeStringToFile(filePath *string, contents *string) {
+func writeStringToFile(filePath *string, contents *string) error {
 	file, err := os.Create(*filePath)
 
 	if err != nil {
-		log.Fatalf("Failed to create file %s. Encountered error %s\n", *filePath, err)
+		return fmt.Errorf("failed to create file %s: %w", *filePath, err)
 	}
 
 	defer file.Close()
 
-	file.WriteString(*contents)
-
+	_, err = file.WriteString(*contents)
+	return err
 }
 
-func WriteCertificatesToFile(certs *iot.CreateKeysAndCertificateOutput, fleetName *string, deviceName *string, certsDirectory *string) {
-	os.MkdirAll(*certsDirectory, os.ModePerm)
+func WriteCertificatesToFile(certs *iot.CreateKeysAndCertificateOutput, fleetName *string, deviceName *string, certsDirectory *string) error {
+	if err := os.MkdirAll(*certsDirectory, os.ModePerm); err != nil {
+		return err
+	}
 	pemFilePath := filepath.Join(*certsDirectory, "device.pem.crt")
 	privateKeyFilePath := filepath.Join(*certsDirectory, "private.pem.key")
 	publicKeyFilePath := filepath.Join(*certsDirectory, "public.pem.key.pub")
 
-	writeStringToFile(&pemFilePath, certs.CertificatePem)
-	writeStringToFile(&privateKeyFilePath, certs.KeyPair.PrivateKey)
-	writeStringToFile(&publicKeyFilePath, certs.KeyPair.PublicKey)
+	if err := writeStringToFile(&pemFilePath, certs.CertificatePem); err != nil {
+		return err
+	}
+	if err := writeStringToFile(&privateKeyFilePath, certs.KeyPair.PrivateKey); err != nil {
+		return err
+	}
+	return writeStringToFile(&publicKeyFilePath, certs.KeyPair.PublicKey)
 }
 
-func GetIotCredentialProviderEndpoint(client IotClient, roleNameAlias *string) *string {
+func GetIotCredentialProviderEndpoint(ctx context.Context, client IotClient, roleNameAlias *string) (*string, error) {
 	endpointType := "iot:CredentialProvider"
-	ret, err := client.DescribeEndpoint(context.TODO(), &iot.DescribeEndpointInput{
+	ret, err := client.DescribeEndpoint(ctx, &iot.DescribeEndpointInput{
 		EndpointType: &endpointType,
 	})
 
 	if err != nil {
-		log.Fatalf("Failed to describe endpoint for %s. Encountered error %s\n", endpointType, err)
+		return nil, fmt.Errorf("failed to describe endpoint for %s: %w", endpointType, err)
 	}
 
 	endpoint := fmt.Sprintf("https://%s/role-aliases/%s/credentials", *ret.EndpointAddress, *roleNameAlias)
-	return &endpoint
+	return &endpoint, nil
 }
 
-func AttachThingToCertificate(client IotClient, certificateArn *string, iotThingName *string) {
-	_, err := client.AttachThingPrincipal(context.TODO(), &iot.AttachThingPrincipalInput{
+func AttachThingToCertificate(ctx context.Context, client IotClient, certificateArn *string, iotThingName *string) error {
+	_, err := client.AttachThingPrincipal(ctx, &iot.AttachThingPrincipalInput{
 		Principal: certificateArn,
 		ThingName: iotThingName,
 	})
 
 	if err != nil {
-		log.Fatalf("Failed to attach thing principal for thing name %s and certificate %s. Encountered error %s\n", *iotThingName, *certificateArn, err)
+		return fmt.Errorf("failed to attach thing principal for thing name %s and certificate %s: %w", *iotThingName, *certificateArn, err)
 	}
+	return nil
 }
 
-func CreateAndAttachRoleAliasPolicy(client IotClient, roleAliasArn *string, certArn *string, iotThingName *string) {
-	policyDocument := `{		
+// CreateAndAttachRoleAliasPolicy creates the policy allowing certArn to assume the role
+// alias and returns its name.
+func CreateAndAttachRoleAliasPolicy(ctx context.Context, client IotClient, roleAliasArn *string, certArn *string, iotThingName *string) (*string, error) {
+	policyDocument := `{
 		"Version": "2012-10-17",
 		"Statement": {
 		  "Effect": "Allow",
@@ -198,19 +228,140 @@ func CreateAndAttachRoleAliasPolicy(client IotClient, roleAliasArn *string, cert
 
 	policyDocument = fmt.Sprintf(policyDocument, *roleAliasArn)
 	now := time.Now()
-	policyName := fmt.Sprintf("aliaspolicy-%d", now.UTC().Unix())
+	policyName := fmt.Sprintf("%s%d", RoleAliasPolicyPrefix, now.UTC().Unix())
 
-	if _, err := client.CreatePolicy(context.TODO(), &iot.CreatePolicyInput{
+	if _, err := client.CreatePolicy(ctx, &iot.CreatePolicyInput{
 		PolicyName:     &policyName,
 		PolicyDocument: &policyDocument,
 	}); err != nil {
-		log.Fatalf("Failed to cerate iot policy %s. Encountered error %s\n", policyName, err)
+		return nil, fmt.Errorf("failed to create iot policy %s: %w", policyName, err)
 	}
 
-	if _, err := client.AttachPolicy(context.TODO(), &iot.AttachPolicyInput{
+	if _, err := client.AttachPolicy(ctx, &iot.AttachPolicyInput{
 		PolicyName: &policyName,
 		Target:     certArn,
 	}); err != nil {
-		log.Fatalf("Failed to attach iot policy %s. Encountered error %s\n", policyName, err)
+		return nil, fmt.Errorf("failed to attach iot policy %s: %w", policyName, err)
+	}
+	return &policyName, nil
+}
+
+// ListThingCertificates returns the principals attached to the thing.
+func ListThingCertificates(ctx context.Context, client IotClient, iotThingName *string) ([]string, error) {
+	principals := make([]string, 0)
+	var nextToken *string
+	for {
+		ret, err := client.ListThingPrincipals(ctx, &iot.ListThingPrincipalsInput{
+			ThingName: iotThingName,
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list principals of thing %s: %w", *iotThingName, err)
+		}
+		principals = append(principals, ret.Principals...)
+		if ret.NextToken == nil || *ret.NextToken == "" {
+			return principals, nil
+		}
+		nextToken = ret.NextToken
+	}
+}
+
+// GetCertificateStatus returns the status of the certificate with the given arn.
+func GetCertificateStatus(ctx context.Context, client IotClient, certificateArn *string) (string, error) {
+	certificateId := CertificateId(*certificateArn)
+	ret, err := client.DescribeCertificate(ctx, &iot.DescribeCertificateInput{
+		CertificateId: &certificateId,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe certificate %s: %w", certificateId, err)
+	}
+	return string(ret.CertificateDescription.Status), nil
+}
+
+// CertificateId extracts the certificate id from a certificate arn.
+func CertificateId(certificateArn string) string {
+	return certificateArn[strings.LastIndex(certificateArn, "/")+1:]
+}
+
+// DeleteThingCertificate detaches the certificate from the thing, deletes the role alias
+// policies created for it and then the deactivated certificate. It returns the names of
+// the deleted policies and whether the certificate was deleted. Certificates with other
+// policies attached are only detached.
+func DeleteThingCertificate(ctx context.Context, client IotClient, certificateArn *string, iotThingName *string) ([]string, bool, error) {
+	if _, err := client.DetachThingPrincipal(ctx, &iot.DetachThingPrincipalInput{
+		Principal: certificateArn,
+		ThingName: iotThingName,
+	}); err != nil {
+		return nil, false, fmt.Errorf("failed to detach certificate %s from thing %s: %w", *certificateArn, *iotThingName, err)
+	}
+
+	deleted := make([]string, 0)
+	var marker *string
+	policies := make([]types.Policy, 0)
+	for {
+		ret, err := client.ListAttachedPolicies(ctx, &iot.ListAttachedPoliciesInput{
+			Target: certificateArn,
+			Marker: marker,
+		})
+		if err != nil {
+			return deleted, false, fmt.Errorf("failed to list policies of certificate %s: %w", *certificateArn, err)
+		}
+		policies = append(policies, ret.Policies...)
+		if ret.NextMarker == nil || *ret.NextMarker == "" {
+			break
+		}
+		marker = ret.NextMarker
+	}
+
+	shared := false
+	for _, policy := range policies {
+		if !strings.HasPrefix(*policy.PolicyName, RoleAliasPolicyPrefix) {
+			// policies attached by others stay, and so does the certificate
+			shared = true
+			continue
+		}
+		if _, err := client.DetachPolicy(ctx, &iot.DetachPolicyInput{
+			PolicyName: policy.PolicyName,
+			Target:     certificateArn,
+		}); err != nil {
+			return deleted, false, fmt.Errorf("failed to detach iot policy %s: %w", *policy.PolicyName, err)
+		}
+		if _, err := client.DeletePolicy(ctx, &iot.DeletePolicyInput{
+			PolicyName: policy.PolicyName,
+		}); err != nil {
+			return deleted, false, fmt.Errorf("failed to delete iot policy %s: %w", *policy.PolicyName, err)
+		}
+		deleted = append(deleted, *policy.PolicyName)
+	}
+	if shared {
+		return deleted, false, nil
+	}
+
+	certificateId := CertificateId(*certificateArn)
+	if _, err := client.UpdateCertificate(ctx, &iot.UpdateCertificateInput{
+		CertificateId: &certificateId,
+		NewStatus:     types.CertificateStatusInactive,
+	}); err != nil {
+		return deleted, false, fmt.Errorf("failed to deactivate certificate %s: %w", certificateId, err)
+	}
+	if _, err := client.DeleteCertificate(ctx, &iot.DeleteCertificateInput{
+		CertificateId: &certificateId,
+	}); err != nil {
+		return deleted, false, fmt.Errorf("failed to delete certificate %s: %w", certificateId, err)
+	}
+	return deleted, true, nil
+}
+
+// DeleteIotThing deletes the thing and returns false if it did not exist.
+func DeleteIotThing(ctx context.Context, client IotClient, iotThingName *string) (bool, error) {
+	if _, err := client.DeleteThing(ctx, &iot.DeleteThingInput{
+		ThingName: iotThingName,
+	}); err != nil {
+		var rnf *types.ResourceNotFoundException
+		if errors.As(err, &rnf) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to delete thing %s: %w", *iotThingName, err)
 	}
+	return true, nil
 }
@@ -21,6 +21,15 @@ var iotMockDescribeEndpoint func(ctx context.Context, params *iot.DescribeEndpoi
 var iotMockAttachThingPrincipal func(ctx context.Context, params *iot.AttachThingPrincipalInput, optFns ...func(*iot.Options)) (*iot.AttachThingPrincipalOutput, error)
 var iotMockCreatePolicy func(ctx context.Context, params *iot.CreatePolicyInput, optFns ...func(*iot.Options)) (*iot.CreatePolicyOutput, error)
 var iotMockAttachPolicy func(ctx context.Context, params *iot.AttachPolicyInput, optFns ...func(*iot.Options)) (*iot.AttachPolicyOutput, error)
+var iotMockDeleteThing func(ctx context.Context, params *iot.DeleteThingInput, optFns ...func(*iot.Options)) (*iot.DeleteThingOutput, error)
+var iotMockDescribeCertificate func(ctx context.Context, params *iot.DescribeCertificateInput, optFns ...func(*iot.Options)) (*iot.DescribeCertificateOutput, error)
+var iotMockUpdateCertificate func(ctx context.Context, params *iot.UpdateCertificateInput, optFns ...func(*iot.Options)) (*iot.UpdateCertificateOutput, error)
+var iotMockDeleteCertificate func(ctx context.Context, params *iot.DeleteCertificateInput, optFns ...func(*iot.Options)) (*iot.DeleteCertificateOutput, error)
+var iotMockDetachThingPrincipal func(ctx context.Context, params *iot.DetachThingPrincipalInput, optFns ...func(*iot.Options)) (*iot.DetachThingPrincipalOutput, error)
+var iotMockListThingPrincipals func(ctx context.Context, params *iot.ListThingPrincipalsInput, optFns ...func(*iot.Options)) (*iot.ListThingPrincipalsOutput, error)
+var iotMockDetachPolicy func(ctx context.Context, params *iot.DetachPolicyInput, optFns ...func(*iot.Options)) (*iot.DetachPolicyOutput, error)
+var iotMockDeletePolicy func(ctx context.Context, params *iot.DeletePolicyInput, optFns ...func(*iot.Options)) (*iot.DeletePolicyOutput, error)
+var iotMockListAttachedPolicies func(ctx context.Context, params *iot.ListAttachedPoliciesInput, optFns ...func(*iot.Options)) (*iot.ListAttachedPoliciesOutput, error)
 
 func (iot mockClient) DescribeThingType(ctx context.Context, params *iot.DescribeThingTypeInput, optFns ...func(*iot.Options)) (*iot.DescribeThingTypeOutput, error) {
 	return iotMockDescribeThingType(ctx, params, optFns...)
@@ -58,6 +67,42 @@ func (iot mockClient) AttachPolicy(ctx context.Context, params *iot.AttachPolicy
 	return iotMockAttachPolicy(ctx, params, optFns...)
 }
 
+func (iot mockClient) DeleteThing(ctx context.Context, params *iot.DeleteThingInput, optFns ...func(*iot.Options)) (*iot.DeleteThingOutput, error) {
+	return iotMockDeleteThing(ctx, params, optFns...)
+}
+
+func (iot mockClient) DescribeCertificate(ctx context.Context, params *iot.DescribeCertificateInput, optFns ...func(*iot.Options)) (*iot.DescribeCertificateOutput, error) {
+	return iotMockDescribeCertificate(ctx, params, optFns...)
+}
+
+func (iot mockClient) UpdateCertificate(ctx context.Context, params *iot.UpdateCertificateInput, optFns ...func(*iot.Options)) (*iot.UpdateCertificateOutput, error) {
+	return iotMockUpdateCertificate(ctx, params, optFns...)
+}
+
+func (iot mockClient) DeleteCertificate(ctx context.Context, params *iot.DeleteCertificateInput, optFns ...func(*iot.Options)) (*iot.DeleteCertificateOutput, error) {
+	return iotMockDeleteCertificate(ctx, params, optFns...)
+}
+
+func (iot mockClient) DetachThingPrincipal(ctx context.Context, params *iot.DetachThingPrincipalInput, optFns ...func(*iot.Options)) (*iot.DetachThingPrincipalOutput, error) {
+	return iotMockDetachThingPrincipal(ctx, params, optFns...)
+}
+
+func (iot mockClient) ListThingPrincipals(ctx context.Context, params *iot.ListThingPrincipalsInput, optFns ...func(*iot.Options)) (*iot.ListThingPrincipalsOutput, error) {
+	return iotMockListThingPrincipals(ctx, params, optFns...)
+}
+
+func (iot mockClient) DetachPolicy(ctx context.Context, params *iot.DetachPolicyInput, optFns ...func(*iot.Options)) (*iot.DetachPolicyOutput, error) {
+	return iotMockDetachPolicy(ctx, params, optFns...)
+}
+
+func (iot mockClient) DeletePolicy(ctx context.Context, params *iot.DeletePolicyInput, optFns ...func(*iot.Options)) (*iot.DeletePolicyOutput, error) {
+	return iotMockDeletePolicy(ctx, params, optFns...)
+}
+
+func (iot mockClient) ListAttachedPolicies(ctx context.Context, params *iot.ListAttachedPoliciesInput, optFns ...func(*iot.Options)) (*iot.ListAttachedPoliciesOutput, error) {
+	return iotMockListAttachedPolicies(ctx, params, optFns...)
+}
+
 func TestGetIotThingType(t *testing.T) {
 	client := mockClient{}
 	nonExistantThingType := "NonExistantThingType"
@@ -73,15 +118,19 @@ func TestGetIotThingType(t *testing.T) {
 		}
 	}
 
-	ret := GetIotThingType(client, &dummyThingType)
+	ret, err := GetIotThingType(context.Background(), client, &dummyThingType)
+
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if *ret.ThingTypeName != dummyThingType {
 		t.Fatalf("Invalid thing type in response")
 	}
 
-	ret = GetIotThingType(client, &nonExistantThingType)
+	ret, err = GetIotThingType(context.Background(), client, &nonExistantThingType)
 
-	if ret != nil {
+	if err != nil || ret != nil {
 		t.Fatalf(fmt.Sprintf("Should return nil for %s", nonExistantThingType))
 	}
 }
@@ -103,7 +152,11 @@ func TestCreateIotThingType(t *testing.T) {
 		}
 	}
 
-	ret := CreateIotThingType(client, &existingThingType)
+	ret, err := CreateIotThingType(context.Background(), client, &existingThingType)
+
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if *ret.ThingTypeName != existingThingType {
 		log.Fatalf("Should return existing thing type.")
@@ -117,7 +170,11 @@ func TestCreateIotThingType(t *testing.T) {
 		}, nil
 	}
 
-	ret = CreateIotThingType(client, &dummyThingType)
+	ret, err = CreateIotThingType(context.Background(), client, &dummyThingType)
+
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if *ret.ThingTypeName != dummyThingType {
 		log.Fatalf(fmt.Sprintf("Should return %s!", dummyThingType))
@@ -138,15 +195,15 @@ func TestGetIotThing(t *testing.T) {
 		}
 	}
 
-	ret := GetIotThing(client, &existingThingName)
+	ret, err := GetIotThing(context.Background(), client, &existingThingName)
 
-	if ret.ThingName != &existingThingName {
+	if err != nil || ret.ThingName != &existingThingName {
 		t.Fatalf("Invalid thing name!")
 	}
 
-	ret = GetIotThing(client, &nonExistingThingName)
+	ret, err = GetIotThing(context.Background(), client, &nonExistingThingName)
 
-	if ret != nil {
+	if err != nil || ret != nil {
 		t.Fatalf("Should return nil for non existing thing")
 	}
 }
@@ -178,15 +235,73 @@ func TestCreateIotThing(t *testing.T) {
 
 	}
 
-	ret := CreateIotThing(client, &thingType, &existingThingName)
+	ret, err := CreateIotThing(context.Background(), client, &thingType, &existingThingName)
 
-	if *ret.ThingName != existingThingName {
+	if err != nil || *ret.ThingName != existingThingName {
 		t.Fatalf("Invalid thing name")
 	}
 
-	ret = CreateIotThing(client, &thingType, &nonExistingThingName)
+	ret, err = CreateIotThing(context.Background(), client, &thingType, &nonExistingThingName)
 
-	if *ret.ThingName != nonExistingThingName {
+	if err != nil || *ret.ThingName != nonExistingThingName {
 		t.Fatalf("Invalid thing name")
 	}
 }
+
+func TestDeleteThingCertificate(t *testing.T) {
+	client := mockClient{}
+	thingName := "DummyThing"
+	certificateArn := "arn:aws:iot:us-west-2:012345678912:cert/abcdef"
+	aliasPolicy := RoleAliasPolicyPrefix + "1650000000"
+	otherPolicy := "OperatorPolicy"
+	policies := []types.Policy{{PolicyName: &aliasPolicy}}
+	calls := make([]string, 0)
+
+	iotMockDetachThingPrincipal = func(ctx context.Context, params *iot.DetachThingPrincipalInput, optFns ...func(*iot.Options)) (*iot.DetachThingPrincipalOutput, error) {
+		calls = append(calls, "DetachThingPrincipal")
+		return &iot.DetachThingPrincipalOutput{}, nil
+	}
+	iotMockListAttachedPolicies = func(ctx context.Context, params *iot.ListAttachedPoliciesInput, optFns ...func(*iot.Options)) (*iot.ListAttachedPoliciesOutput, error) {
+		return &iot.ListAttachedPoliciesOutput{Policies: policies}, nil
+	}
+	iotMockDetachPolicy = func(ctx context.Context, params *iot.DetachPolicyInput, optFns ...func(*iot.Options)) (*iot.DetachPolicyOutput, error) {
+		calls = append(calls, "DetachPolicy "+*params.PolicyName)
+		return &iot.DetachPolicyOutput{}, nil
+	}
+	iotMockDeletePolicy = func(ctx context.Context, params *iot.DeletePolicyInput, optFns ...func(*iot.Options)) (*iot.DeletePolicyOutput, error) {
+		calls = append(calls, "DeletePolicy "+*params.PolicyName)
+		return &iot.DeletePolicyOutput{}, nil
+	}
+	iotMockUpdateCertificate = func(ctx context.Context, params *iot.UpdateCertificateInput, optFns ...func(*iot.Options)) (*iot.UpdateCertificateOutput, error) {
+		if *params.CertificateId != "abcdef" || params.NewStatus != types.CertificateStatusInactive {
+			t.Fatalf("Unexpected certificate update %s %s", *params.CertificateId, params.NewStatus)
+		}
+		calls = append(calls, "UpdateCertificate")
+		return &iot.UpdateCertificateOutput{}, nil
+	}
+	iotMockDeleteCertificate = func(ctx context.Context, params *iot.DeleteCertificateInput, optFns ...func(*iot.Options)) (*iot.DeleteCertificateOutput, error) {
+		calls = append(calls, "DeleteCertificate")
+		return &iot.DeleteCertificateOutput{}, nil
+	}
+
+	deleted, certificateDeleted, err := DeleteThingCertificate(context.Background(), client, &certificateArn, &thingName)
+	if err != nil || !certificateDeleted {
+		t.Fatalf("Certificate should be deleted, got %t %v", certificateDeleted, err)
+	}
+	expected := fmt.Sprint([]string{"DetachThingPrincipal", "DetachPolicy " + aliasPolicy, "DeletePolicy " + aliasPolicy, "UpdateCertificate", "DeleteCertificate"})
+	if fmt.Sprint(calls) != expected || len(deleted) != 1 {
+		t.Fatalf("Expected calls %s, got %v", expected, calls)
+	}
+
+	// certificates with policies of others are kept
+	policies = append(policies, types.Policy{PolicyName: &otherPolicy})
+	calls = calls[:0]
+	if _, certificateDeleted, err = DeleteThingCertificate(context.Background(), client, &certificateArn, &thingName); err != nil || certificateDeleted {
+		t.Fatalf("Certificate should be kept, got %t %v", certificateDeleted, err)
+	}
+	for _, call := range calls {
+		if call == "DeleteCertificate" || call == "DeletePolicy "+otherPolicy {
+			t.Fatalf("Unexpected call %s", call)
+		}
+	}
+}
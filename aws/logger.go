@@ -0,0 +1,22 @@
+package aws
+
+import (
+	"context"
+	"log"
+)
+
+type loggerKey struct{}
+
+// WithLogger returns a context whose AWS calls, retries and downloads log to logger
+// instead of the standard logger.
+func WithLogger(ctx context.Context, logger *log.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// Logger returns the logger of ctx, the standard logger if none was set.
+func Logger(ctx context.Context) *log.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*log.Logger); ok && logger != nil {
+		return logger
+	}
+	return log.Default()
+}
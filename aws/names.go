@@ -0,0 +1,90 @@
+package aws
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+var (
+	// SageMaker fleet and device names, alphanumerics separated by hyphens
+	sagemakerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9](-*[a-zA-Z0-9])*$`)
+	iotNamePattern       = regexp.MustCompile(`^[a-zA-Z0-9:_-]+$`)
+	iamNamePattern       = regexp.MustCompile(`^[\w+=,.@-]+$`)
+	bucketNamePattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]*[a-z0-9]$`)
+)
+
+// placeholderAccount stands in for the account before it is resolved, account ids
+// always have twelve digits.
+const placeholderAccount = "000000000000"
+
+// checkName checks a resource name against the pattern and length limit of its service.
+func checkName(kind string, name string, maxLength int, pattern *regexp.Regexp, allowed string) []string {
+	problems := make([]string, 0)
+	if name == "" {
+		return append(problems, fmt.Sprintf("%s must not be empty", kind))
+	}
+	if len(name) > maxLength {
+		problems = append(problems, fmt.Sprintf("%s %s is %d characters long, at most %d are allowed", kind, name, len(name), maxLength))
+	}
+	if !pattern.MatchString(name) {
+		problems = append(problems, fmt.Sprintf("%s %s may only contain %s", kind, name, allowed))
+	}
+	return problems
+}
+
+func checkBucketName(name string) []string {
+	problems := make([]string, 0)
+	if len(name) < 3 || len(name) > 63 {
+		problems = append(problems, fmt.Sprintf("bucket name %s must be between 3 and 63 characters long", name))
+	}
+	if !bucketNamePattern.MatchString(name) {
+		problems = append(problems, fmt.Sprintf("bucket name %s may only contain lower case letters, digits, dots and hyphens and must begin and end with a letter or digit", name))
+	}
+	if strings.Contains(name, "..") {
+		problems = append(problems, fmt.Sprintf("bucket name %s must not contain two adjacent dots", name))
+	}
+	if net.ParseIP(name) != nil {
+		problems = append(problems, fmt.Sprintf("bucket name %s must not be formatted as an IP address", name))
+	}
+	if strings.HasPrefix(name, "xn--") || strings.HasSuffix(name, "-s3alias") || strings.HasSuffix(name, "--ol-s3") {
+		problems = append(problems, fmt.Sprintf("bucket name %s uses a prefix or suffix reserved by S3", name))
+	}
+	return problems
+}
+
+// ValidateNames checks the names of the fleet, device, iot thing, role, policies and bucket
+// setup creates against the naming rules of their services and returns all problems
+// together. Without a fleet bucket the default bucket of the account is checked.
+func ValidateNames(cliArgs *cli.CliArgs) error {
+	const sagemakerAllowed = "letters, digits and hyphens and must begin and end with a letter or digit"
+	const iotAllowed = "letters, digits, colons, underscores and hyphens"
+	const iamAllowed = "letters, digits and +=,.@_-"
+
+	problems := make([]string, 0)
+	problems = append(problems, checkName("device fleet name", cliArgs.DeviceFleet, 63, sagemakerNamePattern, sagemakerAllowed)...)
+	problems = append(problems, checkName("device name", cliArgs.DeviceName, 63, sagemakerNamePattern, sagemakerAllowed)...)
+	problems = append(problems, checkName("iot thing type", cliArgs.IotThingType, 128, iotNamePattern, iotAllowed)...)
+	problems = append(problems, checkName("iot thing name", cliArgs.IotThingName, 128, iotNamePattern, iotAllowed)...)
+	problems = append(problems, checkName("device fleet role", cliArgs.DeviceFleetRole, 64, iamNamePattern, iamAllowed)...)
+
+	names := *cliArgs
+	if names.DeviceFleetBucket == "" {
+		account := names.Account
+		if account == "" {
+			account = placeholderAccount
+		}
+		names.DeviceFleetBucket = DefaultDeviceFleetBucket(account)
+	}
+	problems = append(problems, checkBucketName(names.DeviceFleetBucket)...)
+	problems = append(problems, checkName("device fleet policy", DeviceFleetPolicyName(&names), 128, iamNamePattern, iamAllowed)...)
+	problems = append(problems, checkName("device fleet bucket policy", DeviceFleetBucketPolicyName(&names), 128, iamNamePattern, iamAllowed)...)
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
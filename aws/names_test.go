@@ -0,0 +1,76 @@
+package aws
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"strings"
+	"testing"
+)
+
+func TestValidateNames(t *testing.T) {
+	cliArgs := &cli.CliArgs{
+		DeviceFleet:     "test-fleet",
+		DeviceName:      "test-device",
+		IotThingType:    "Sagemaker_test-fleet",
+		IotThingName:    "Sagemaker_test-device",
+		DeviceFleetRole: "Sagemaker_test-fleet_role",
+	}
+	if err := ValidateNames(cliArgs); err != nil {
+		t.Fatal(err)
+	}
+
+	cliArgs.DeviceFleetBucket = "my.bucket"
+	if err := ValidateNames(cliArgs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateNamesReportsAllProblems(t *testing.T) {
+	longFleet := strings.Repeat("f", 60)
+	cliArgs := &cli.CliArgs{
+		DeviceFleet:       longFleet,
+		DeviceName:        "-device",
+		IotThingType:      "Sagemaker_" + longFleet,
+		IotThingName:      "Sagemaker device",
+		DeviceFleetRole:   "Sagemaker_" + longFleet + "_role",
+		DeviceFleetBucket: "Bucket..Name-" + strings.Repeat("b", 60),
+	}
+	err := ValidateNames(cliArgs)
+	if err == nil {
+		t.Fatal("Expected invalid names to be reported")
+	}
+	expected := []string{
+		"device name -device may only contain",
+		"iot thing name Sagemaker device may only contain",
+		"device fleet role Sagemaker_" + longFleet + "_role is 75 characters long, at most 64",
+		"bucket name Bucket..Name-",
+		"must not contain two adjacent dots",
+		"device fleet bucket policy " + longFleet + "-bucket..name-",
+	}
+	for _, problem := range expected {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected %q to be reported, got:\n%s", problem, err)
+		}
+	}
+	if strings.Contains(err.Error(), "device fleet name") {
+		t.Errorf("The fleet name is valid, got:\n%s", err)
+	}
+}
+
+func TestValidateNamesDefaultBucketPolicyLength(t *testing.T) {
+	// <fleet>-sagemaker-edgemanager-<account>-policy exceeds 128 characters for long fleet names
+	fleet := strings.Repeat("f", 63)
+	cliArgs := &cli.CliArgs{
+		DeviceFleet:     fleet,
+		DeviceName:      "device",
+		IotThingType:    "type",
+		IotThingName:    "thing",
+		DeviceFleetRole: "role",
+	}
+	if err := ValidateNames(cliArgs); err != nil {
+		t.Fatal(err)
+	}
+	cliArgs.DeviceFleetBucket = strings.Repeat("b", 63)
+	if err := ValidateNames(cliArgs); err == nil || !strings.Contains(err.Error(), "device fleet bucket policy") {
+		t.Errorf("Expected the bucket policy name to be too long, got %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package aws
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+type IamSimulationClient interface {
+	SimulatePrincipalPolicy(ctx context.Context, params *iam.SimulatePrincipalPolicyInput, optFns ...func(*iam.Options)) (*iam.SimulatePrincipalPolicyOutput, error)
+}
+
+// RequiredPermission is a set of actions a setup step performs on the same resources.
+type RequiredPermission struct {
+	Sid       string
+	Step      string
+	Actions   []string
+	Resources []string
+	// PassedToService restricts iam:PassRole to the service the role is passed to
+	PassedToService string
+	// ReleaseStore marks downloads from the agent release store, which may use other credentials
+	ReleaseStore bool
+}
+
+// DeniedPermission is an action the caller may not perform on a resource.
+type DeniedPermission struct {
+	Step     string
+	Action   string
+	Resource string
+	Decision string
+}
+
+// RequiredPermissions lists the actions and resource ARNs of the planned setup steps.
+func RequiredPermissions(cliArgs *cli.CliArgs) []RequiredPermission {
+	account := cliArgs.Account
+	region := cliArgs.Region
+	bucket := cliArgs.DeviceFleetBucket
+	if bucket == "" {
+		bucket = DefaultDeviceFleetBucket(account)
+	}
+	// the bucket policy is named after the resolved bucket
+	bucketArgs := *cliArgs
+	bucketArgs.DeviceFleetBucket = bucket
+
+	roleArn := fmt.Sprintf("arn:aws:iam::%s:role/%s", account, cliArgs.DeviceFleetRole)
+	fleetArn := fmt.Sprintf("arn:aws:sagemaker:%s:%s:device-fleet/%s", region, account, cliArgs.DeviceFleet)
+	deviceArn := fmt.Sprintf("%s/device/%s", fleetArn, cliArgs.DeviceName)
+	thingArn := fmt.Sprintf("arn:aws:iot:%s:%s:thing/%s", region, account, cliArgs.IotThingName)
+
+	agentBucket := cliArgs.ReleaseStore.BucketName(cliArgs.TargetPlatform.Os, cliArgs.TargetPlatform.ReleaseArch(), cliArgs.TargetPlatform.GetAccelerator().ReleaseVariant)
+	certBucket := cliArgs.ReleaseStore.BucketName("linux", constants.X64, "")
+
+	return []RequiredPermission{
+		{
+			Sid:       "FleetBucket",
+			Step:      "Step-1 Creating S3 bucket",
+			Actions:   []string{"s3:CreateBucket"},
+			Resources: []string{fmt.Sprintf("arn:aws:s3:::%s", bucket)},
+		},
+		{
+			Sid:     "FleetPolicies",
+			Step:    "Step-2/3 Creating device fleet policies",
+			Actions: []string{"iam:GetPolicy", "iam:CreatePolicy"},
+			Resources: []string{
+				fmt.Sprintf("arn:aws:iam::%s:policy/%s", account, DeviceFleetPolicyName(cliArgs)),
+				fmt.Sprintf("arn:aws:iam::%s:policy/%s", account, DeviceFleetBucketPolicyName(&bucketArgs)),
+			},
+		},
+		{
+			Sid:       "FleetRole",
+			Step:      "Step-4 Creating device fleet role",
+			Actions:   []string{"iam:GetRole", "iam:CreateRole", "iam:ListAttachedRolePolicies", "iam:AttachRolePolicy"},
+			Resources: []string{roleArn},
+		},
+		{
+			Sid:       "IotThingType",
+			Step:      "Step-5 Creating iot thing type",
+			Actions:   []string{"iot:DescribeThingType", "iot:CreateThingType"},
+			Resources: []string{fmt.Sprintf("arn:aws:iot:%s:%s:thingtype/%s", region, account, cliArgs.IotThingType)},
+		},
+		{
+			Sid:       "IotThing",
+			Step:      "Step-6 Creating iot thing",
+			Actions:   []string{"iot:DescribeThing", "iot:CreateThing"},
+			Resources: []string{thingArn},
+		},
+		{
+			Sid:       "DeviceFleet",
+			Step:      "Step-7 Creating device fleet",
+			Actions:   []string{"sagemaker:DescribeDeviceFleet", "sagemaker:CreateDeviceFleet"},
+			Resources: []string{fleetArn},
+		},
+		{
+			Sid:             "PassFleetRole",
+			Step:            "Step-7 Creating device fleet",
+			Actions:         []string{"iam:PassRole"},
+			Resources:       []string{roleArn},
+			PassedToService: "sagemaker.amazonaws.com",
+		},
+		{
+			Sid:       "RegisterDevice",
+			Step:      "Step-8 Registering device",
+			Actions:   []string{"sagemaker:RegisterDevices"},
+			Resources: []string{fleetArn},
+		},
+		{
+			Sid:       "Device",
+			Step:      "Step-8 Registering device",
+			Actions:   []string{"sagemaker:DescribeDevice", "sagemaker:AddTags"},
+			Resources: []string{deviceArn},
+		},
+		{
+			Sid:          "ReleaseStoreList",
+			Step:         "Step-9 Downloading Agent",
+			Actions:      []string{"s3:ListBucket"},
+			Resources:    []string{fmt.Sprintf("arn:aws:s3:::%s", agentBucket)},
+			ReleaseStore: true,
+		},
+		{
+			Sid:     "ReleaseStoreGet",
+			Step:    "Step-9/10 Downloading Agent and code signing root certificate",
+			Actions: []string{"s3:GetObject"},
+			Resources: []string{
+				fmt.Sprintf("arn:aws:s3:::%s/%s*", agentBucket, cliArgs.ReleaseStore.Prefix),
+				fmt.Sprintf("arn:aws:s3:::%s/Certificates/*", certBucket),
+			},
+			ReleaseStore: true,
+		},
+		{
+			Sid:       "IotCertificate",
+			Step:      "Step-11 Creating iot certificates",
+			Actions:   []string{"iot:CreateKeysAndCertificate", "iot:DescribeEndpoint"},
+			Resources: []string{"*"},
+		},
+		{
+			Sid:       "AttachThingPrincipal",
+			Step:      "Step-12 Attaching certificate to thing",
+			Actions:   []string{"iot:AttachThingPrincipal"},
+			Resources: []string{thingArn},
+		},
+		{
+			Sid:       "RoleAliasPolicy",
+			Step:      "Step-13 Configuring Agent",
+			Actions:   []string{"iot:CreatePolicy"},
+			Resources: []string{fmt.Sprintf("arn:aws:iot:%s:%s:policy/%s*", region, account, RoleAliasPolicyPrefix)},
+		},
+		{
+			Sid:       "AttachRoleAliasPolicy",
+			Step:      "Step-13 Configuring Agent",
+			Actions:   []string{"iot:AttachPolicy"},
+			Resources: []string{fmt.Sprintf("arn:aws:iot:%s:%s:cert/*", region, account)},
+		},
+	}
+}
+
+// RequiredPolicyDocument renders the permissions as an IAM policy for the operator.
+func RequiredPolicyDocument(permissions []RequiredPermission) *PolicyDocument {
+	policyDocument := &PolicyDocument{Version: "2012-10-17", Statement: make([]StatementEntry, 0)}
+	for _, permission := range permissions {
+		statement := StatementEntry{
+			Sid:      permission.Sid,
+			Effect:   "Allow",
+			Action:   permission.Actions,
+			Resource: permission.Resources,
+		}
+		if permission.PassedToService != "" {
+			statement.Condition = map[string]interface{}{
+				"StringEquals": map[string]interface{}{"iam:PassedToService": permission.PassedToService},
+			}
+		}
+		policyDocument.Statement = append(policyDocument.Statement, statement)
+	}
+	return policyDocument
+}
+
+// PolicySourceArn maps a caller identity to the IAM user or role whose policies are
+// simulated. Sessions of assumed roles map to their role.
+func PolicySourceArn(callerArn string) (string, error) {
+	parts := strings.SplitN(callerArn, ":", 6)
+	if len(parts) != 6 {
+		return "", fmt.Errorf("invalid caller arn %s", callerArn)
+	}
+	partition, service, account, resource := parts[1], parts[2], parts[4], parts[5]
+
+	switch {
+	case service == "iam" && (strings.HasPrefix(resource, "user/") || strings.HasPrefix(resource, "role/")):
+		return callerArn, nil
+	case service == "sts" && strings.HasPrefix(resource, "assumed-role/"):
+		names := strings.Split(resource, "/")
+		if len(names) < 3 {
+			return "", fmt.Errorf("invalid assumed role arn %s", callerArn)
+		}
+		return fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, account, names[1]), nil
+	}
+	return "", fmt.Errorf("policies of %s cannot be simulated", callerArn)
+}
+
+// SimulatePermissions evaluates every required action and resource for the caller and
+// returns all denied ones.
+func SimulatePermissions(ctx context.Context, client IamSimulationClient, callerArn string, permissions []RequiredPermission) ([]DeniedPermission, error) {
+	policySourceArn, err := PolicySourceArn(callerArn)
+	if err != nil {
+		return nil, err
+	}
+
+	denied := make([]DeniedPermission, 0)
+	for _, permission := range permissions {
+		input := &iam.SimulatePrincipalPolicyInput{
+			PolicySourceArn: &policySourceArn,
+			ActionNames:     permission.Actions,
+		}
+		if permission.PassedToService != "" {
+			contextKey := "iam:PassedToService"
+			input.ContextEntries = []types.ContextEntry{
+				{
+					ContextKeyName:   &contextKey,
+					ContextKeyType:   types.ContextKeyTypeEnumString,
+					ContextKeyValues: []string{permission.PassedToService},
+				},
+			}
+		}
+
+		// one resource per call keeps each result bound to its resource
+		for _, resource := range permission.Resources {
+			input.ResourceArns = []string{resource}
+			input.Marker = nil
+			for {
+				output, err := client.SimulatePrincipalPolicy(ctx, input)
+				if err != nil {
+					return nil, fmt.Errorf("failed to simulate policies of %s: %w", policySourceArn, err)
+				}
+				for _, result := range output.EvaluationResults {
+					if result.EvalDecision == types.PolicyEvaluationDecisionTypeAllowed {
+						continue
+					}
+					denied = append(denied, DeniedPermission{
+						Step:     permission.Step,
+						Action:   *result.EvalActionName,
+						Resource: resource,
+						Decision: string(result.EvalDecision),
+					})
+				}
+				if !output.IsTruncated {
+					break
+				}
+				input.Marker = output.Marker
+			}
+		}
+	}
+	return denied, nil
+}
@@ -0,0 +1,186 @@
+package aws
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+type mockIamSimulation struct{}
+
+var mockSimulatePrincipalPolicy func(ctx context.Context, params *iam.SimulatePrincipalPolicyInput, optFns ...func(*iam.Options)) (*iam.SimulatePrincipalPolicyOutput, error)
+
+func (client mockIamSimulation) SimulatePrincipalPolicy(ctx context.Context, params *iam.SimulatePrincipalPolicyInput, optFns ...func(*iam.Options)) (*iam.SimulatePrincipalPolicyOutput, error) {
+	return mockSimulatePrincipalPolicy(ctx, params, optFns...)
+}
+
+func permissionsCliArgs() *cli.CliArgs {
+	return &cli.CliArgs{
+		DeviceFleet:     "dummyfleet",
+		DeviceName:      "dummydevice",
+		Account:         "012345678912",
+		Region:          "us-west-2",
+		DeviceFleetRole: "DummyRole",
+		IotThingType:    "Sagemaker_dummyfleet",
+		IotThingName:    "Sagemaker_dummydevice",
+		TargetPlatform:  options.TargetPlatform{Os: "linux", Arch: "x64"},
+		ReleaseStore: options.ReleaseStore{
+			BucketTemplate: "sagemaker-edge-release-store-{region}-{os}-{arch}",
+			Region:         "us-west-2",
+			Prefix:         "Releases/",
+		},
+	}
+}
+
+func findPermission(permissions []RequiredPermission, sid string) *RequiredPermission {
+	for i := range permissions {
+		if permissions[i].Sid == sid {
+			return &permissions[i]
+		}
+	}
+	return nil
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	permissions := RequiredPermissions(permissionsCliArgs())
+
+	expected := map[string]string{
+		"FleetBucket":           "arn:aws:s3:::sagemaker-edgemanager-012345678912",
+		"FleetPolicies":         "arn:aws:iam::012345678912:policy/dummyfleet-policy",
+		"FleetRole":             "arn:aws:iam::012345678912:role/DummyRole",
+		"IotThing":              "arn:aws:iot:us-west-2:012345678912:thing/Sagemaker_dummydevice",
+		"RegisterDevice":        "arn:aws:sagemaker:us-west-2:012345678912:device-fleet/dummyfleet",
+		"Device":                "arn:aws:sagemaker:us-west-2:012345678912:device-fleet/dummyfleet/device/dummydevice",
+		"ReleaseStoreGet":       "arn:aws:s3:::sagemaker-edge-release-store-us-west-2-linux-x64/Releases/*",
+		"RoleAliasPolicy":       "arn:aws:iot:us-west-2:012345678912:policy/aliaspolicy-*",
+		"AttachRoleAliasPolicy": "arn:aws:iot:us-west-2:012345678912:cert/*",
+	}
+	for sid, resource := range expected {
+		permission := findPermission(permissions, sid)
+		if permission == nil {
+			t.Errorf("Missing permission %s", sid)
+			continue
+		}
+		if permission.Resources[0] != resource {
+			t.Errorf("Expected resource %s for %s, got %s", resource, sid, permission.Resources[0])
+		}
+	}
+
+	bucketPolicies := findPermission(permissions, "FleetPolicies").Resources
+	if bucketPolicies[1] != "arn:aws:iam::012345678912:policy/dummyfleet-sagemaker-edgemanager-012345678912-policy" {
+		t.Errorf("Unexpected bucket policy arn %s", bucketPolicies[1])
+	}
+}
+
+func TestRequiredPolicyDocument(t *testing.T) {
+	policyDocument := RequiredPolicyDocument(RequiredPermissions(permissionsCliArgs()))
+
+	for _, statement := range policyDocument.Statement {
+		if statement.Sid != "PassFleetRole" {
+			continue
+		}
+		condition := statement.Condition["StringEquals"].(map[string]interface{})
+		if condition["iam:PassedToService"] != "sagemaker.amazonaws.com" {
+			t.Errorf("Unexpected pass role condition %v", statement.Condition)
+		}
+		return
+	}
+	t.Error("Missing pass role statement")
+}
+
+func TestPolicySourceArn(t *testing.T) {
+	tests := map[string]string{
+		"arn:aws:iam::012345678912:user/operator":                     "arn:aws:iam::012345678912:user/operator",
+		"arn:aws:iam::012345678912:role/EdgeSetup":                    "arn:aws:iam::012345678912:role/EdgeSetup",
+		"arn:aws:sts::012345678912:assumed-role/EdgeSetup/session":    "arn:aws:iam::012345678912:role/EdgeSetup",
+		"arn:aws-cn:sts::012345678912:assumed-role/EdgeSetup/session": "arn:aws-cn:iam::012345678912:role/EdgeSetup",
+		"arn:aws:sts::012345678912:federated-user/operator":           "",
+		"arn:aws:iam::012345678912:root":                              "",
+	}
+	for callerArn, expected := range tests {
+		policySourceArn, err := PolicySourceArn(callerArn)
+		if expected == "" {
+			if err == nil {
+				t.Errorf("Expected an error for %s", callerArn)
+			}
+			continue
+		}
+		if err != nil || policySourceArn != expected {
+			t.Errorf("Expected %s for %s, got %s (%v)", expected, callerArn, policySourceArn, err)
+		}
+	}
+}
+
+func TestSimulatePermissions(t *testing.T) {
+	permissions := []RequiredPermission{
+		{Step: "Step-4", Actions: []string{"iam:GetRole", "iam:CreateRole"}, Resources: []string{"arn:aws:iam::012345678912:role/DummyRole"}},
+		{Step: "Step-7", Actions: []string{"iam:PassRole"}, Resources: []string{"arn:aws:iam::012345678912:role/DummyRole"}, PassedToService: "sagemaker.amazonaws.com"},
+	}
+
+	calls := 0
+	mockSimulatePrincipalPolicy = func(ctx context.Context, params *iam.SimulatePrincipalPolicyInput, optFns ...func(*iam.Options)) (*iam.SimulatePrincipalPolicyOutput, error) {
+		calls++
+		if *params.PolicySourceArn != "arn:aws:iam::012345678912:role/EdgeSetup" {
+			t.Errorf("Unexpected policy source %s", *params.PolicySourceArn)
+		}
+		output := &iam.SimulatePrincipalPolicyOutput{}
+		for _, action := range params.ActionNames {
+			action := action
+			decision := types.PolicyEvaluationDecisionTypeAllowed
+			if action == "iam:CreateRole" {
+				decision = types.PolicyEvaluationDecisionTypeImplicitDeny
+			}
+			if action == "iam:PassRole" && len(params.ContextEntries) == 0 {
+				decision = types.PolicyEvaluationDecisionTypeImplicitDeny
+			}
+			output.EvaluationResults = append(output.EvaluationResults, types.EvaluationResult{EvalActionName: &action, EvalDecision: decision})
+		}
+		return output, nil
+	}
+
+	denied, err := SimulatePermissions(context.Background(), mockIamSimulation{}, "arn:aws:sts::012345678912:assumed-role/EdgeSetup/session", permissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 simulations, got %d", calls)
+	}
+	if len(denied) != 1 || denied[0].Action != "iam:CreateRole" || denied[0].Step != "Step-4" {
+		t.Errorf("Expected iam:CreateRole to be denied, got %v", denied)
+	}
+}
+
+func TestSimulatePermissionsScopedPolicy(t *testing.T) {
+	// a least privilege policy grants these actions only on their own resource type
+	scopes := map[string]string{
+		"iot:CreatePolicy":         ":policy/",
+		"iot:AttachPolicy":         ":cert/",
+		"sagemaker:DescribeDevice": "/device/",
+		"sagemaker:AddTags":        "/device/",
+	}
+	mockSimulatePrincipalPolicy = func(ctx context.Context, params *iam.SimulatePrincipalPolicyInput, optFns ...func(*iam.Options)) (*iam.SimulatePrincipalPolicyOutput, error) {
+		output := &iam.SimulatePrincipalPolicyOutput{}
+		for _, action := range params.ActionNames {
+			action := action
+			decision := types.PolicyEvaluationDecisionTypeAllowed
+			if scope, ok := scopes[action]; ok && !strings.Contains(params.ResourceArns[0], scope) {
+				decision = types.PolicyEvaluationDecisionTypeImplicitDeny
+			}
+			output.EvaluationResults = append(output.EvaluationResults, types.EvaluationResult{EvalActionName: &action, EvalDecision: decision})
+		}
+		return output, nil
+	}
+
+	denied, err := SimulatePermissions(context.Background(), mockIamSimulation{}, "arn:aws:iam::012345678912:role/EdgeSetup", RequiredPermissions(permissionsCliArgs()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(denied) != 0 {
+		t.Errorf("A correctly scoped policy should be granted, got %v", denied)
+	}
+}
@@ -0,0 +1,156 @@
+package aws
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	awsStd "github.com/aws/aws-sdk-go-v2/aws"
+	awsMiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awsHttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// ErrorClass tells whether a failed call is worth retrying.
+type ErrorClass int
+
+const (
+	// ErrorPermanent fails the same way on every attempt, e.g. invalid names or missing permissions
+	ErrorPermanent ErrorClass = iota
+	ErrorThrottling
+	// ErrorTransient covers connection errors, timeouts and server side failures
+	ErrorTransient
+	// ErrorIamPropagation is returned while a new role or policy is not visible everywhere yet
+	ErrorIamPropagation
+)
+
+func (class ErrorClass) String() string {
+	switch class {
+	case ErrorThrottling:
+		return "throttling"
+	case ErrorTransient:
+		return "transient"
+	case ErrorIamPropagation:
+		return "IAM propagation"
+	default:
+		return "permanent"
+	}
+}
+
+// errorRule classifies an error code of an operation. Empty operations match every
+// operation of the service, an empty message any message.
+type errorRule struct {
+	Service   string
+	Operation string
+	Code      string
+	Message   string
+	Class     ErrorClass
+}
+
+// errorRules take precedence over the SDK's throttling and transient error checks.
+var errorRules = []errorRule{
+	// IAM is eventually consistent, new roles and policies take a few seconds to attach
+	{Service: "IAM", Operation: "AttachRolePolicy", Code: "NoSuchEntity", Class: ErrorIamPropagation},
+	// SageMaker cannot assume a role created moments ago
+	{Service: "SageMaker", Operation: "CreateDeviceFleet", Code: "ValidationException", Message: "role", Class: ErrorIamPropagation},
+	{Service: "SageMaker", Operation: "RegisterDevices", Code: "ValidationException", Message: "role", Class: ErrorIamPropagation},
+	// an exceeded IoT quota does not recover by waiting
+	{Service: "IoT", Code: "LimitExceededException", Class: ErrorPermanent},
+	{Service: "IoT", Code: "InternalFailureException", Class: ErrorTransient},
+	{Service: "IoT", Code: "ServiceUnavailableException", Class: ErrorTransient},
+	{Service: "SageMaker", Code: "ResourceLimitExceeded", Class: ErrorPermanent},
+	{Service: "IAM", Code: "LimitExceeded", Class: ErrorPermanent},
+	{Service: "IAM", Code: "ServiceFailure", Class: ErrorTransient},
+}
+
+// ErrorCode returns the API error code of err, the HTTP status or the type of error
+// for failures without a response.
+func ErrorCode(err error) string {
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	var responseErr *awsHttp.ResponseError
+	if errors.As(err, &responseErr) {
+		return fmt.Sprintf("HTTP %d", responseErr.HTTPStatusCode())
+	}
+	if retry.IsErrorTimeouts(retry.DefaultTimeouts).IsErrorTimeout(err) == awsStd.TrueTernary {
+		return "Timeout"
+	}
+	return "RequestError"
+}
+
+// ClassifyError classifies the error of an operation of service, named by its SDK service
+// id such as "IAM" or "SageMaker". Without service and operation only rules for all
+// services apply.
+func ClassifyError(service string, operation string, err error) ErrorClass {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorPermanent
+	}
+
+	code := ErrorCode(err)
+	for _, rule := range errorRules {
+		if rule.Service != service || (rule.Operation != "" && rule.Operation != operation) || rule.Code != code {
+			continue
+		}
+		if rule.Message != "" && !strings.Contains(strings.ToLower(err.Error()), rule.Message) {
+			continue
+		}
+		return rule.Class
+	}
+
+	if retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == awsStd.TrueTernary {
+		return ErrorThrottling
+	}
+	if retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == awsStd.TrueTernary {
+		return ErrorTransient
+	}
+	return ErrorPermanent
+}
+
+// mayRetry reports whether err is retryable for any operation. The operation is only
+// known once the SDK asks for a retry token.
+func mayRetry(err error) bool {
+	if ClassifyError("", "", err) != ErrorPermanent {
+		return true
+	}
+	code := ErrorCode(err)
+	for _, rule := range errorRules {
+		if rule.Code == code && rule.Class != ErrorPermanent {
+			return true
+		}
+	}
+	return false
+}
+
+// policyRetryer is the standard SDK retryer limited to the errors ClassifyError
+// considers retryable for the failed operation.
+type policyRetryer struct {
+	*retry.Standard
+}
+
+// NewRetryer returns a retryer with the attempts and backoff of opts that retries
+// throttling, transient and IAM propagation errors and logs every retry.
+func NewRetryer(opts *cli.RetryOptions) awsStd.Retryer {
+	return &policyRetryer{retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = opts.MaxAttempts
+		o.MaxBackoff = opts.MaxBackoff
+		o.Backoff = retry.NewExponentialJitterBackoff(opts.MaxBackoff)
+		o.Retryables = []retry.IsErrorRetryable{retry.IsErrorRetryableFunc(func(err error) awsStd.Ternary {
+			return awsStd.BoolTernary(mayRetry(err))
+		})}
+	})}
+}
+
+func (r *policyRetryer) GetRetryToken(ctx context.Context, opErr error) (func(error) error, error) {
+	service, operation := awsMiddleware.GetServiceID(ctx), awsMiddleware.GetOperationName(ctx)
+	class := ClassifyError(service, operation, opErr)
+	if class == ErrorPermanent {
+		// ends the attempts with the original error
+		return nil, opErr
+	}
+	Logger(ctx).Printf("Retrying %s %s after %s error %s.\n", service, operation, class, ErrorCode(opErr))
+	return r.Standard.GetRetryToken(ctx, opErr)
+}
@@ -0,0 +1,104 @@
+package aws
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	awsMiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/smithy-go"
+)
+
+func TestClassifyError(t *testing.T) {
+	apiError := func(code string, message string) error {
+		return &smithy.GenericAPIError{Code: code, Message: message}
+	}
+	cases := []struct {
+		service   string
+		operation string
+		err       error
+		expected  ErrorClass
+	}{
+		{"IAM", "AttachRolePolicy", apiError("NoSuchEntity", "role not found"), ErrorIamPropagation},
+		{"IAM", "GetRole", apiError("NoSuchEntity", "role not found"), ErrorPermanent},
+		{"SageMaker", "CreateDeviceFleet", apiError("ValidationException", "Unable to assume role"), ErrorIamPropagation},
+		{"SageMaker", "CreateDeviceFleet", apiError("ValidationException", "Value at 'deviceFleetName' failed to satisfy constraint"), ErrorPermanent},
+		{"SageMaker", "DescribeDevice", apiError("ValidationException", "Unable to assume role"), ErrorPermanent},
+		{"SageMaker", "CreateDeviceFleet", apiError("ThrottlingException", "Rate exceeded"), ErrorThrottling},
+		{"S3", "GetObject", apiError("SlowDown", "Reduce your request rate"), ErrorThrottling},
+		{"IoT", "CreateThing", apiError("LimitExceededException", "Too many things"), ErrorPermanent},
+		{"IoT", "CreateThing", apiError("ServiceUnavailableException", "Unavailable"), ErrorTransient},
+		{"IAM", "CreateRole", apiError("ServiceFailure", "Internal failure"), ErrorTransient},
+		{"IAM", "CreateRole", apiError("AccessDenied", "Not authorized"), ErrorPermanent},
+		{"STS", "GetCallerIdentity", context.Canceled, ErrorPermanent},
+	}
+	for _, c := range cases {
+		if class := ClassifyError(c.service, c.operation, c.err); class != c.expected {
+			t.Errorf("%s %s %s: expected %s, got %s", c.service, c.operation, c.err, c.expected, class)
+		}
+	}
+}
+
+func TestRetryerStopsOnPermanentError(t *testing.T) {
+	retryer := NewRetryer(&cli.RetryOptions{MaxAttempts: 3, MaxBackoff: time.Millisecond})
+	limitErr := &smithy.GenericAPIError{Code: "LimitExceededException"}
+	if !retryer.IsErrorRetryable(limitErr) {
+		t.Fatal("Throttling codes should be candidates before the service is known")
+	}
+	ctx := awsMiddleware.SetServiceID(context.Background(), "IoT")
+	if _, err := retryer.GetRetryToken(ctx, limitErr); err != limitErr {
+		t.Errorf("Expected the IoT quota error to end the attempts, got %v", err)
+	}
+
+	if retryer.IsErrorRetryable(&smithy.GenericAPIError{Code: "AccessDenied"}) {
+		t.Error("AccessDenied should not be retried")
+	}
+}
+
+func TestRetryPolicyPerOperation(t *testing.T) {
+	setEnv(t, "AWS_CA_BUNDLE", "")
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		action := r.Form.Get("Action")
+		requests[action]++
+		if action == "AttachRolePolicy" && requests[action] > 2 {
+			fmt.Fprint(w, "<AttachRolePolicyResponse><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></AttachRolePolicyResponse>")
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "<ErrorResponse><Error><Type>Sender</Type><Code>NoSuchEntity</Code><Message>The role cannot be found.</Message></Error><RequestId>1</RequestId></ErrorResponse>")
+	}))
+	defer server.Close()
+
+	client := iam.New(iam.Options{
+		Region:           "us-east-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		EndpointResolver: iam.EndpointResolverFromURL(server.URL),
+		Retryer:          NewRetryer(&cli.RetryOptions{MaxAttempts: 5, MaxBackoff: time.Millisecond}),
+	})
+
+	role, policy := "role", "arn:aws:iam::aws:policy/test"
+	if _, err := client.AttachRolePolicy(context.Background(), &iam.AttachRolePolicyInput{RoleName: &role, PolicyArn: &policy}); err != nil {
+		t.Fatal(err)
+	}
+	if requests["AttachRolePolicy"] != 3 {
+		t.Errorf("Expected AttachRolePolicy to be retried until IAM caught up, got %d attempts", requests["AttachRolePolicy"])
+	}
+
+	_, err := client.GetRole(context.Background(), &iam.GetRoleInput{RoleName: &role})
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "NoSuchEntity" {
+		t.Errorf("Expected NoSuchEntity, got %v", err)
+	}
+	if requests["GetRole"] != 1 {
+		t.Errorf("A missing role should not be retried by GetRole, got %d attempts", requests["GetRole"])
+	}
+}
@@ -1,13 +1,12 @@
 package aws
 
 import (
+	"aws-sagemaker-edge-quick-device-setup/cache"
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"os"
-	"path/filepath"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -18,12 +17,18 @@ type S3Client interface {
 	CreateBucket(ctx context.Context, params *s3.CreateBucketInput, optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error)
 	ListObjects(ctx context.Context, params *s3.ListObjectsInput, optFns ...func(*s3.Options)) (*s3.ListObjectsOutput, error)
 	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
 }
 
-func CreateS3Bucket(client S3Client, bucketName *string, accountId *string, region *string) *string {
+// DefaultDeviceFleetBucket is the fleet bucket used when none is given.
+func DefaultDeviceFleetBucket(accountId string) string {
+	return fmt.Sprintf("sagemaker-edgemanager-%s", accountId)
+}
+
+func CreateS3Bucket(ctx context.Context, client S3Client, bucketName *string, accountId *string, region *string) (*string, error) {
 
 	if *bucketName == "" {
-		*bucketName = fmt.Sprintf("sagemaker-edgemanager-%s", *accountId)
+		*bucketName = DefaultDeviceFleetBucket(*accountId)
 	}
 
 	locationConstraint := types.BucketLocationConstraint(*region)
@@ -31,7 +36,7 @@ func CreateS3Bucket(client S3Client, bucketName *string, accountId *string, regi
 		locationConstraint = types.BucketLocationConstraintEu
 	}
 
-	_, err := client.CreateBucket(context.TODO(), &s3.CreateBucketInput{
+	_, err := client.CreateBucket(ctx, &s3.CreateBucketInput{
 		Bucket: bucketName,
 		CreateBucketConfiguration: &types.CreateBucketConfiguration{
 			LocationConstraint: locationConstraint,
@@ -42,50 +47,101 @@ func CreateS3Bucket(client S3Client, bucketName *string, accountId *string, regi
 		var bne *types.BucketAlreadyOwnedByYou
 		var be *types.BucketAlreadyExists
 		if errors.As(err, &bne) || errors.As(err, &be) {
-			return bucketName
+			return bucketName, nil
 		}
-		log.Fatalf("Failed to create bucket %s. Encountered error %s\n", *bucketName, err)
+		return nil, fmt.Errorf("failed to create bucket %s: %w", *bucketName, err)
 	}
 
-	return bucketName
+	return bucketName, nil
 }
 
-func DownloadFileFromS3ToPath(client S3Client, bucketName *string, key *string, filePath *string) *string {
-	downloader := manager.NewDownloader(client)
-	os.MkdirAll(filepath.Dir(*filePath), os.ModePerm)
-	fd, err := os.Create(*filePath)
-	if err != nil {
-		log.Fatalf("Failed to create file %s. Encountered error %s\n", *filePath, err)
-	}
-	_, err = downloader.Download(context.TODO(), fd, &s3.GetObjectInput{
+// DownloadFileFromS3 returns the local path of the object, downloading it into the cache
+// unless an entry for the object's current ETag is already present.
+func DownloadFileFromS3(ctx context.Context, client S3Client, objectCache *cache.Cache, bucketName *string, key *string) (*string, error) {
+	headObjectOutput, err := client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: bucketName,
 		Key:    key,
 	})
 
 	if err != nil {
-		log.Fatalf("Failed to download object %s from bucket %s. Encountered error %s\n", *key, *bucketName, err)
+		return nil, fmt.Errorf("failed to head object %s from bucket %s: %w", *key, *bucketName, err)
+	}
+
+	etag := ""
+	if headObjectOutput.ETag != nil {
+		etag = strings.Trim(*headObjectOutput.ETag, "\"")
+	}
+
+	if entry, ok := objectCache.Lookup(*bucketName, *key, etag); ok {
+		Logger(ctx).Printf("Using cached copy of s3://%s/%s\n", *bucketName, *key)
+		filePath := objectCache.ObjectPath(entry)
+		return &filePath, nil
 	}
-	return filePath
-}
 
-func DownloadFileFromS3(client S3Client, bucketName *string, key *string) *string {
-	tempDir, err := ioutil.TempDir("", "aws_sagemaker_quick_device_setup")
+	fd, err := objectCache.TempFile()
 	if err != nil {
-		log.Fatal("Failed to create temp directory.", err)
+		return nil, fmt.Errorf("failed to create temp file in cache: %w", err)
 	}
-	filePath := filepath.Join(tempDir, *key)
-	return DownloadFileFromS3ToPath(client, bucketName, key, &filePath)
+	tempPath := fd.Name()
+
+	downloader := manager.NewDownloader(client)
+	_, err = downloader.Download(ctx, fd, &s3.GetObjectInput{
+		Bucket:  bucketName,
+		Key:     key,
+		IfMatch: headObjectOutput.ETag,
+	})
+	fd.Close()
+
+	if err != nil {
+		os.Remove(tempPath)
+		return nil, fmt.Errorf("failed to download object %s from bucket %s: %w", *key, *bucketName, err)
+	}
+
+	entry, err := objectCache.Store(*bucketName, *key, etag, tempPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store object %s from bucket %s in cache: %w", *key, *bucketName, err)
+	}
+	filePath := objectCache.ObjectPath(entry)
+	return &filePath, nil
 }
 
-func ListBucket(client S3Client, bucketName *string, prefix *string) *s3.ListObjectsOutput {
+func ListBucket(ctx context.Context, client S3Client, bucketName *string, prefix *string) (*s3.ListObjectsOutput, error) {
 
 	listObjectsInput := &s3.ListObjectsInput{
 		Bucket: bucketName,
 		Prefix: prefix,
 	}
-	output, err := client.ListObjects(context.TODO(), listObjectsInput)
+	output, err := client.ListObjects(ctx, listObjectsInput)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects in bucket %s for prefix %s: %w", *bucketName, *prefix, err)
+	}
+	return output, nil
+}
+
+type S3AccessClient interface {
+	GetBucketLocation(ctx context.Context, params *s3.GetBucketLocationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// VerifyBucketAccess performs the bucket calls the agent needs for data capture,
+// GetBucketLocation on the bucket and PutObject of a small marker object at key.
+func VerifyBucketAccess(ctx context.Context, client S3AccessClient, bucketName *string, key *string) error {
+	_, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
+		Bucket: bucketName,
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to get location of bucket %s: %w", *bucketName, err)
+	}
+
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: bucketName,
+		Key:    key,
+		Body:   strings.NewReader("sagemaker edge device credentials verified\n"),
+	})
+
 	if err != nil {
-		log.Fatalf("Failed to list objects in bucket %s for prefix %s. Encountered error %s\n", *bucketName, *prefix, err)
+		return fmt.Errorf("failed to put object %s into bucket %s: %w", *key, *bucketName, err)
 	}
-	return output
+	return nil
 }
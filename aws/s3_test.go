@@ -1 +1,50 @@
 package aws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type mockS3AccessClient struct{}
+
+var s3MockGetBucketLocation func(ctx context.Context, params *s3.GetBucketLocationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error)
+var s3MockPutObject func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+
+func (s3Client mockS3AccessClient) GetBucketLocation(ctx context.Context, params *s3.GetBucketLocationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error) {
+	return s3MockGetBucketLocation(ctx, params, optFns...)
+}
+
+func (s3Client mockS3AccessClient) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return s3MockPutObject(ctx, params, optFns...)
+}
+
+func TestVerifyBucketAccess(t *testing.T) {
+	bucket := "sagemaker-edgemanager-012345679012"
+	key := "demo/verify-credentials/some-thing.txt"
+	var putKey string
+
+	s3MockGetBucketLocation = func(ctx context.Context, params *s3.GetBucketLocationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error) {
+		return &s3.GetBucketLocationOutput{}, nil
+	}
+	s3MockPutObject = func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+		putKey = *params.Key
+		return &s3.PutObjectOutput{}, nil
+	}
+
+	if err := VerifyBucketAccess(context.Background(), mockS3AccessClient{}, &bucket, &key); err != nil {
+		t.Fatal(err)
+	}
+	if putKey != key {
+		t.Fatalf("Marker object should be written to %s, got %s", key, putKey)
+	}
+
+	s3MockPutObject = func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+		return nil, errors.New("AccessDenied")
+	}
+	if err := VerifyBucketAccess(context.Background(), mockS3AccessClient{}, &bucket, &key); err == nil {
+		t.Fatal("Denied PutObject should be reported")
+	}
+}
@@ -1,12 +1,10 @@
 package aws
 
 import (
-	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/options"
 	"context"
 	"errors"
 	"fmt"
-	"log"
-	"reflect"
 
 	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
 	"github.com/aws/aws-sdk-go-v2/service/sagemaker/types"
@@ -20,29 +18,38 @@ type SagemakerClient interface {
 	CreateDeviceFleet(ctx context.Context, params *sagemaker.CreateDeviceFleetInput, optFns ...func(*sagemaker.Options)) (*sagemaker.CreateDeviceFleetOutput, error)
 	DescribeDevice(ctx context.Context, params *sagemaker.DescribeDeviceInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DescribeDeviceOutput, error)
 	RegisterDevices(ctx context.Context, params *sagemaker.RegisterDevicesInput, optFns ...func(*sagemaker.Options)) (*sagemaker.RegisterDevicesOutput, error)
+	DeregisterDevices(ctx context.Context, params *sagemaker.DeregisterDevicesInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DeregisterDevicesOutput, error)
 }
 
-func GetDeviceFleet(client SagemakerClient, fleetName *string) *sagemaker.DescribeDeviceFleetOutput {
+// GetDeviceFleet returns nil without error if the fleet does not exist.
+func GetDeviceFleet(ctx context.Context, client SagemakerClient, fleetName *string) (*sagemaker.DescribeDeviceFleetOutput, error) {
 
-	ret, err := client.DescribeDeviceFleet(context.TODO(), &sagemaker.DescribeDeviceFleetInput{
+	ret, err := client.DescribeDeviceFleet(ctx, &sagemaker.DescribeDeviceFleetInput{
 		DeviceFleetName: fleetName,
 	})
 
 	if err != nil {
-		return nil
+		var rnf *types.ResourceNotFound
+		if errors.As(err, &rnf) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to describe device fleet %s: %w", *fleetName, err)
 	}
 
-	return ret
+	return ret, nil
 
 }
 
-func CreateDeviceFleet(client SagemakerClient, fleetName *string, role *iamTypes.Role, s3Bucket *string) {
+func CreateDeviceFleet(ctx context.Context, client SagemakerClient, fleetName *string, role *iamTypes.Role, s3Bucket *string) error {
 	s3OutputLocation := fmt.Sprintf("s3://%s/%s", *s3Bucket, *fleetName)
 
-	describeDeviceFleetOutput := GetDeviceFleet(client, fleetName)
+	describeDeviceFleetOutput, err := GetDeviceFleet(ctx, client, fleetName)
+	if err != nil {
+		return err
+	}
 
 	if describeDeviceFleetOutput == nil {
-		_, err := client.CreateDeviceFleet(context.TODO(), &sagemaker.CreateDeviceFleetInput{
+		_, err := client.CreateDeviceFleet(ctx, &sagemaker.CreateDeviceFleetInput{
 			DeviceFleetName: fleetName,
 			OutputConfig: &types.EdgeOutputConfig{
 				S3OutputLocation: &s3OutputLocation,
@@ -53,37 +60,46 @@ func CreateDeviceFleet(client SagemakerClient, fleetName *string, role *iamTypes
 		if err != nil {
 			var oe *smithy.OperationError
 			if errors.As(err, &oe) {
-				log.Printf("failed to call service: %s, operation: %s, error: %v", oe.Service(), oe.Operation(), oe.Unwrap())
+				Logger(ctx).Printf("failed to call service: %s, operation: %s, error: %v", oe.Service(), oe.Operation(), oe.Unwrap())
 			}
-			log.Fatalf("Failed to create device fleet %s. Encountered error %s\n", *fleetName, reflect.TypeOf(err))
+			return fmt.Errorf("failed to create device fleet %s: %w", *fleetName, err)
 		}
 
 	}
+	return nil
 }
 
-func GetDevice(client SagemakerClient, fleetName *string, deviceName *string) *sagemaker.DescribeDeviceOutput {
-	ret, err := client.DescribeDevice(context.TODO(), &sagemaker.DescribeDeviceInput{
+// GetDevice returns nil without error if the device is not registered with the fleet.
+func GetDevice(ctx context.Context, client SagemakerClient, fleetName *string, deviceName *string) (*sagemaker.DescribeDeviceOutput, error) {
+	ret, err := client.DescribeDevice(ctx, &sagemaker.DescribeDeviceInput{
 		DeviceFleetName: fleetName,
 		DeviceName:      deviceName,
 	})
 
 	if err != nil {
-		return nil
+		var rnf *types.ResourceNotFound
+		if errors.As(err, &rnf) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to describe device %s: %w", *deviceName, err)
 	}
 
-	return ret
+	return ret, nil
 }
 
-func RegisterDevice(client SagemakerClient, fleetName *string, deviceName *string, iotThingName *string, targetPlatform *cli.TargetPlatform) {
+func RegisterDevice(ctx context.Context, client SagemakerClient, fleetName *string, deviceName *string, iotThingName *string, targetPlatform *options.TargetPlatform) error {
 
-	getDeviceOutput := GetDevice(client, fleetName, deviceName)
+	getDeviceOutput, err := GetDevice(ctx, client, fleetName, deviceName)
+	if err != nil {
+		return err
+	}
 
 	targetOsKey := "os"
 	targetArchKey := "arch"
 	targetAccelerator := "accelerator"
 
 	if getDeviceOutput == nil {
-		_, err := client.RegisterDevices(context.TODO(), &sagemaker.RegisterDevicesInput{
+		_, err := client.RegisterDevices(ctx, &sagemaker.RegisterDevicesInput{
 			DeviceFleetName: fleetName,
 			Devices: []types.Device{
 				{
@@ -108,19 +124,36 @@ func RegisterDevice(client SagemakerClient, fleetName *string, deviceName *strin
 		})
 
 		if err != nil {
-			log.Fatalf("Failed to register device %s with fleet %s. Encountered error %s", *fleetName, *deviceName, err)
+			return fmt.Errorf("failed to register device %s with fleet %s: %w", *deviceName, *fleetName, err)
 		}
 	}
+	return nil
+}
+
+// DeregisterDevice removes the device from the fleet and returns false if it was not registered.
+func DeregisterDevice(ctx context.Context, client SagemakerClient, fleetName *string, deviceName *string) (bool, error) {
+	getDeviceOutput, err := GetDevice(ctx, client, fleetName, deviceName)
+	if err != nil || getDeviceOutput == nil {
+		return false, err
+	}
+
+	if _, err := client.DeregisterDevices(ctx, &sagemaker.DeregisterDevicesInput{
+		DeviceFleetName: fleetName,
+		DeviceNames:     []string{*deviceName},
+	}); err != nil {
+		return false, fmt.Errorf("failed to deregister device %s from fleet %s: %w", *deviceName, *fleetName, err)
+	}
+	return true, nil
 }
 
-func GetRoleAliasArn(client SagemakerClient, deviceFleet *string) *string {
-	ret, err := client.DescribeDeviceFleet(context.TODO(), &sagemaker.DescribeDeviceFleetInput{
+func GetRoleAliasArn(ctx context.Context, client SagemakerClient, deviceFleet *string) (*string, error) {
+	ret, err := client.DescribeDeviceFleet(ctx, &sagemaker.DescribeDeviceFleetInput{
 		DeviceFleetName: deviceFleet,
 	})
 
 	if err != nil {
-		log.Fatalf("Failed to describe device fleet %s. Encountered error %s", *deviceFleet, err)
+		return nil, fmt.Errorf("failed to describe device fleet %s: %w", *deviceFleet, err)
 	}
 
-	return ret.IotRoleAlias
+	return ret.IotRoleAlias, nil
 }
@@ -15,6 +15,7 @@ var mockDescribeDeviceFleet func(ctx context.Context, params *sagemaker.Describe
 var mockCreateDeviceFleet func(ctx context.Context, params *sagemaker.CreateDeviceFleetInput, optFns ...func(*sagemaker.Options)) (*sagemaker.CreateDeviceFleetOutput, error)
 var mockDescribeDevice func(ctx context.Context, params *sagemaker.DescribeDeviceInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DescribeDeviceOutput, error)
 var mockRegisterDevices func(ctx context.Context, params *sagemaker.RegisterDevicesInput, optFns ...func(*sagemaker.Options)) (*sagemaker.RegisterDevicesOutput, error)
+var mockDeregisterDevices func(ctx context.Context, params *sagemaker.DeregisterDevicesInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DeregisterDevicesOutput, error)
 
 func (sm mockSagemakerClient) DescribeDeviceFleet(ctx context.Context, params *sagemaker.DescribeDeviceFleetInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DescribeDeviceFleetOutput, error) {
 	return mockDescribeDeviceFleet(ctx, params, optFns...)
@@ -32,6 +33,10 @@ func (sm mockSagemakerClient) RegisterDevices(ctx context.Context, params *sagem
 	return mockRegisterDevices(ctx, params, optFns...)
 }
 
+func (sm mockSagemakerClient) DeregisterDevices(ctx context.Context, params *sagemaker.DeregisterDevicesInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DeregisterDevicesOutput, error) {
+	return mockDeregisterDevices(ctx, params, optFns...)
+}
+
 func TestGetDeviceFleet(t *testing.T) {
 	client := mockSagemakerClient{}
 	nonExistantDeviceFleet := "NonExistantDeviceFleet"
@@ -47,13 +52,13 @@ func TestGetDeviceFleet(t *testing.T) {
 		}, nil
 	}
 
-	ret := GetDeviceFleet(client, &nonExistantDeviceFleet)
+	ret, err := GetDeviceFleet(context.Background(), client, &nonExistantDeviceFleet)
 
-	if ret != nil {
+	if err != nil || ret != nil {
 		t.Fatalf("Should return nil for non existant device fleet")
 	}
 
-	ret = GetDeviceFleet(client, &dummyFleet)
+	ret, _ = GetDeviceFleet(context.Background(), client, &dummyFleet)
 
 	if *ret.DeviceFleetName != dummyFleet {
 		t.Fatalf("Invalid device fleet name.")
@@ -88,8 +93,12 @@ func TestCreateDeviceFleet(t *testing.T) {
 		return &sagemaker.CreateDeviceFleetOutput{}, nil
 	}
 
-	CreateDeviceFleet(client, &existingDeviceFleet, &role, &s3Bucket)
-	CreateDeviceFleet(client, &nonExistantDeviceFleet, &role, &s3Bucket)
+	if err := CreateDeviceFleet(context.Background(), client, &existingDeviceFleet, &role, &s3Bucket); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateDeviceFleet(context.Background(), client, &nonExistantDeviceFleet, &role, &s3Bucket); err != nil {
+		t.Fatal(err)
+	}
 }
 
 func TestGetDevice(t *testing.T) {
@@ -108,14 +117,44 @@ func TestGetDevice(t *testing.T) {
 		}, nil
 	}
 
-	ret := GetDevice(client, &existingFleet, &nonExistantDevice)
-	if ret != nil {
+	ret, err := GetDevice(context.Background(), client, &existingFleet, &nonExistantDevice)
+	if err != nil || ret != nil {
 		t.Fatalf("Should return nil for non existing device")
 	}
 
-	ret = GetDevice(client, &existingFleet, &existingDevice)
+	ret, _ = GetDevice(context.Background(), client, &existingFleet, &existingDevice)
 
 	if *ret.DeviceName != existingDevice {
 		t.Fatalf("Invalid device")
 	}
 }
+
+func TestDeregisterDevice(t *testing.T) {
+	client := mockSagemakerClient{}
+	fleet := "DummyFleet"
+	device := "DummyDevice"
+	registered := true
+	mockDescribeDevice = func(ctx context.Context, params *sagemaker.DescribeDeviceInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DescribeDeviceOutput, error) {
+		if !registered {
+			return nil, &types.ResourceNotFound{}
+		}
+		return &sagemaker.DescribeDeviceOutput{DeviceName: params.DeviceName}, nil
+	}
+	mockDeregisterDevices = func(ctx context.Context, params *sagemaker.DeregisterDevicesInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DeregisterDevicesOutput, error) {
+		if len(params.DeviceNames) != 1 || params.DeviceNames[0] != device {
+			t.Fatalf("Unexpected devices %v", params.DeviceNames)
+		}
+		registered = false
+		return &sagemaker.DeregisterDevicesOutput{}, nil
+	}
+
+	deregistered, err := DeregisterDevice(context.Background(), client, &fleet, &device)
+	if err != nil || !deregistered {
+		t.Fatalf("Device should be deregistered, got %t %v", deregistered, err)
+	}
+
+	deregistered, err = DeregisterDevice(context.Background(), client, &fleet, &device)
+	if err != nil || deregistered {
+		t.Fatalf("Missing device should be skipped, got %t %v", deregistered, err)
+	}
+}
@@ -0,0 +1,29 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type StsClient interface {
+	GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+// ResolveAccount defaults account to the account of the caller identity and rejects an
+// explicitly given account that differs from it, so bucket names and ARNs never point
+// at another account. It returns the caller ARN.
+func ResolveAccount(ctx context.Context, client StsClient, account *string) (*string, error) {
+	identity, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %w", err)
+	}
+
+	if *account == "" {
+		*account = *identity.Account
+	} else if *account != *identity.Account {
+		return nil, fmt.Errorf("account %s does not match account %s of caller %s", *account, *identity.Account, *identity.Arn)
+	}
+	return identity.Arn, nil
+}
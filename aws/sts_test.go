@@ -0,0 +1,71 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type mockSts struct{}
+
+var mockGetCallerIdentity func(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+
+func (client mockSts) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	return mockGetCallerIdentity(ctx, params, optFns...)
+}
+
+func mockCallerIdentity(account string, arn string) {
+	mockGetCallerIdentity = func(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+		return &sts.GetCallerIdentityOutput{Account: &account, Arn: &arn}, nil
+	}
+}
+
+func TestResolveAccountDefaultsToCaller(t *testing.T) {
+	mockCallerIdentity("012345678912", "arn:aws:iam::012345678912:user/operator")
+
+	account := ""
+	callerArn, err := ResolveAccount(context.Background(), mockSts{}, &account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account != "012345678912" {
+		t.Errorf("Expected account 012345678912, got %s", account)
+	}
+	if *callerArn != "arn:aws:iam::012345678912:user/operator" {
+		t.Errorf("Unexpected caller arn %s", *callerArn)
+	}
+}
+
+func TestResolveAccountMatches(t *testing.T) {
+	mockCallerIdentity("012345678912", "arn:aws:sts::012345678912:assumed-role/EdgeSetup/session")
+
+	account := "012345678912"
+	if _, err := ResolveAccount(context.Background(), mockSts{}, &account); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveAccountMismatch(t *testing.T) {
+	mockCallerIdentity("012345678912", "arn:aws:iam::012345678912:user/operator")
+
+	account := "012345678913"
+	if _, err := ResolveAccount(context.Background(), mockSts{}, &account); err == nil {
+		t.Error("Expected an error for a mismatching account")
+	}
+	if account != "012345678913" {
+		t.Errorf("Expected account to be kept, got %s", account)
+	}
+}
+
+func TestResolveAccountError(t *testing.T) {
+	mockGetCallerIdentity = func(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+		return nil, errors.New("ExpiredToken")
+	}
+
+	account := ""
+	if _, err := ResolveAccount(context.Background(), mockSts{}, &account); err == nil {
+		t.Error("Expected the caller identity error")
+	}
+}
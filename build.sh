@@ -2,19 +2,30 @@ VERSION=0.0.4
 
 
 build () {
-    GOOS=$1 GOARCH=$2 go build -ldflags "-X aws-sagemaker-edge-quick-device-setup/distinfo.OS=$1 -X aws-sagemaker-edge-quick-device-setup/distinfo.ARCH=$2 -X aws-sagemaker-edge-quick-device-setup/distinfo.VERSION=$VERSION" -o ./bin/aws-sagemaker-edge-quick-device-setup-$1-$2 main.go
+    GOOS=$1 GOARCH=$2 GOARM=$3 go build -ldflags "-X aws-sagemaker-edge-quick-device-setup/distinfo.OS=$1 -X aws-sagemaker-edge-quick-device-setup/distinfo.ARCH=$2 -X aws-sagemaker-edge-quick-device-setup/distinfo.VERSION=$VERSION" -o ./bin/aws-sagemaker-edge-quick-device-setup-$1-$2 .
 }
 
-if [ $1-$2 != "linux-amd64" -a $1-$2 != "linux-arm64" ]; then
-    echo "USAGE: bash build.sh OS ARCH"
-    echo "Supported operating system and architecture combinations"
-    echo "- linux amd64"
-    echo "- linux arm64"
-    exit 1
-fi
+case $1-$2 in
+    linux-amd64|linux-arm64|linux-386)
+        GOARM=""
+        ;;
+    linux-arm)
+        # armv7 (armhf) devices such as the Raspberry Pi
+        GOARM=7
+        ;;
+    *)
+        echo "USAGE: bash build.sh OS ARCH"
+        echo "Supported operating system and architecture combinations"
+        echo "- linux amd64"
+        echo "- linux arm64"
+        echo "- linux 386"
+        echo "- linux arm"
+        exit 1
+        ;;
+esac
 
 
-build $1 $2
+build $1 $2 $GOARM
 for algo in sha1sum sha224sum sha256sum sha384sum sha512sum; do
     ${algo} ./bin/aws-sagemaker-edge-quick-device-setup-$1-$2 > ./bin/aws-sagemaker-edge-quick-device-setup-$1-$2.${algo}
 done 
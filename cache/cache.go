@@ -0,0 +1,257 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	objectsDir = "objects"
+	entriesDir = "entries"
+	tempDir    = "tmp"
+
+	// temp files older than this are leftovers of interrupted runs
+	staleTempAge = time.Hour
+)
+
+// Entry describes a cached S3 object. Objects are stored once per content digest,
+// entries map a bucket/key/ETag triple to the stored content.
+type Entry struct {
+	Bucket   string
+	Key      string
+	ETag     string
+	Digest   string
+	Size     int64
+	LastUsed time.Time
+}
+
+type Cache struct {
+	Dir string
+}
+
+// DefaultDir returns the cache directory under the user cache dir, falling back to
+// the temp dir when the user cache dir can not be determined.
+func DefaultDir() string {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		base = os.TempDir()
+	}
+	return filepath.Join(base, "aws-sagemaker-edge-quick-device-setup")
+}
+
+// New creates the cache layout under dir and removes temp files left behind by earlier runs.
+func New(dir string) (*Cache, error) {
+	for _, sub := range []string{objectsDir, entriesDir, tempDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	cache := &Cache{Dir: dir}
+	if _, err := cache.CleanTemp(staleTempAge); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+func entryId(bucket string, key string, etag string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%s", bucket, key, etag)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (cache *Cache) entryPath(bucket string, key string, etag string) string {
+	return filepath.Join(cache.Dir, entriesDir, entryId(bucket, key, etag)+".json")
+}
+
+// ObjectPath returns the location of the cached content of entry.
+func (cache *Cache) ObjectPath(entry *Entry) string {
+	return filepath.Join(cache.Dir, objectsDir, entry.Digest)
+}
+
+// Lookup returns the entry for bucket/key/etag if it is cached and its content still
+// matches the recorded digest. Corrupted entries are dropped.
+func (cache *Cache) Lookup(bucket string, key string, etag string) (*Entry, bool) {
+	entryPath := cache.entryPath(bucket, key, etag)
+	entry, err := readEntry(entryPath)
+	if err != nil {
+		return nil, false
+	}
+
+	digest, size, err := digestFile(cache.ObjectPath(entry))
+	if err != nil || digest != entry.Digest || size != entry.Size {
+		os.Remove(entryPath)
+		return nil, false
+	}
+
+	entry.LastUsed = time.Now().UTC()
+	writeEntry(entryPath, entry)
+	return entry, true
+}
+
+// TempFile creates a file in the cache temp directory. Downloads are written there
+// and moved into the cache by Store, so an interrupted download never becomes an entry.
+func (cache *Cache) TempFile() (*os.File, error) {
+	return ioutil.TempFile(filepath.Join(cache.Dir, tempDir), "download-")
+}
+
+// Store moves the downloaded file at tempPath into the cache and records the entry.
+func (cache *Cache) Store(bucket string, key string, etag string, tempPath string) (*Entry, error) {
+	digest, size, err := digestFile(tempPath)
+	if err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+
+	entry := &Entry{
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     etag,
+		Digest:   digest,
+		Size:     size,
+		LastUsed: time.Now().UTC(),
+	}
+
+	if err := os.Rename(tempPath, cache.ObjectPath(entry)); err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+
+	if err := writeEntry(cache.entryPath(bucket, key, etag), entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// List returns all cache entries, most recently used first.
+func (cache *Cache) List() ([]Entry, error) {
+	paths, err := filepath.Glob(filepath.Join(cache.Dir, entriesDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(paths))
+	for _, path := range paths {
+		entry, err := readEntry(path)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// Prune removes entries that were not used within maxAge, objects no longer referenced
+// by any entry and all temp files. It returns the removed entries.
+func (cache *Cache) Prune(maxAge time.Duration) ([]Entry, error) {
+	paths, err := filepath.Glob(filepath.Join(cache.Dir, entriesDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := make([]Entry, 0)
+	referenced := make(map[string]bool)
+	for _, path := range paths {
+		entry, err := readEntry(path)
+		if err != nil {
+			// unreadable entries can never be looked up again
+			os.Remove(path)
+			continue
+		}
+		if entry.LastUsed.Before(cutoff) {
+			if err := os.Remove(path); err != nil {
+				return removed, err
+			}
+			removed = append(removed, *entry)
+			continue
+		}
+		referenced[entry.Digest] = true
+	}
+
+	objects, err := ioutil.ReadDir(filepath.Join(cache.Dir, objectsDir))
+	if err != nil {
+		return removed, err
+	}
+	for _, object := range objects {
+		if !referenced[object.Name()] {
+			if err := os.Remove(filepath.Join(cache.Dir, objectsDir, object.Name())); err != nil {
+				return removed, err
+			}
+		}
+	}
+
+	_, err = cache.CleanTemp(0)
+	return removed, err
+}
+
+// CleanTemp removes temp files older than maxAge and returns the number of removed files.
+func (cache *Cache) CleanTemp(maxAge time.Duration) (int, error) {
+	files, err := ioutil.ReadDir(filepath.Join(cache.Dir, tempDir))
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	cutoff := time.Now().Add(-maxAge)
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "download-") || file.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(cache.Dir, tempDir, file.Name())); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func digestFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+func readEntry(path string) (*Entry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func writeEntry(path string, entry *Entry) error {
+	data, err := json.MarshalIndent(entry, "", " ")
+	if err != nil {
+		return err
+	}
+	temp := path + ".tmp"
+	if err := ioutil.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func storeString(t *testing.T, cache *Cache, bucket string, key string, etag string, contents string) *Entry {
+	fd, err := cache.TempFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.WriteString(contents)
+	fd.Close()
+
+	entry, err := cache.Store(bucket, key, etag, fd.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+func TestStoreAndLookup(t *testing.T) {
+	cache, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.Lookup("bucket", "Releases/agent.tgz", "etag-1"); ok {
+		t.Fatal("Lookup should miss on an empty cache")
+	}
+
+	entry := storeString(t, cache, "bucket", "Releases/agent.tgz", "etag-1", "agent")
+
+	found, ok := cache.Lookup("bucket", "Releases/agent.tgz", "etag-1")
+	if !ok || found.Digest != entry.Digest {
+		t.Fatal("Lookup should hit after store")
+	}
+
+	if _, ok := cache.Lookup("bucket", "Releases/agent.tgz", "etag-2"); ok {
+		t.Fatal("Lookup should miss when the ETag changed")
+	}
+
+	// identical content of a different key shares the stored object
+	other := storeString(t, cache, "mirror", "Releases/agent.tgz", "etag-3", "agent")
+	if cache.ObjectPath(other) != cache.ObjectPath(entry) {
+		t.Fatal("Identical content should be stored once")
+	}
+
+	temps, _ := ioutil.ReadDir(filepath.Join(cache.Dir, tempDir))
+	if len(temps) != 0 {
+		t.Fatal("Store should not leave temp files behind")
+	}
+}
+
+func TestLookupDropsCorruptedEntries(t *testing.T) {
+	cache, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := storeString(t, cache, "bucket", "key", "etag", "agent")
+	if err := ioutil.WriteFile(cache.ObjectPath(entry), []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.Lookup("bucket", "key", "etag"); ok {
+		t.Fatal("Lookup should miss when the content does not match the digest")
+	}
+
+	entries, _ := cache.List()
+	if len(entries) != 0 {
+		t.Fatal("Corrupted entry should be removed")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	cache, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := storeString(t, cache, "bucket", "old", "etag", "old agent")
+	recent := storeString(t, cache, "bucket", "recent", "etag", "recent agent")
+
+	old.LastUsed = time.Now().Add(-48 * time.Hour)
+	if err := writeEntry(cache.entryPath(old.Bucket, old.Key, old.ETag), old); err != nil {
+		t.Fatal(err)
+	}
+
+	fd, _ := cache.TempFile()
+	fd.Close()
+
+	removed, err := cache.Prune(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(removed) != 1 || removed[0].Key != "old" {
+		t.Fatalf("Only the old entry should be removed, removed %v", removed)
+	}
+	if _, err := os.Stat(cache.ObjectPath(old)); !os.IsNotExist(err) {
+		t.Fatal("Unreferenced object should be removed")
+	}
+	if _, err := os.Stat(cache.ObjectPath(recent)); err != nil {
+		t.Fatal("Referenced object should be kept")
+	}
+	if _, err := os.Stat(fd.Name()); !os.IsNotExist(err) {
+		t.Fatal("Temp files should be removed")
+	}
+}
@@ -0,0 +1,23 @@
+package cli
+
+import "testing"
+
+func TestReleaseCredentialsDefaults(t *testing.T) {
+	operator := CredentialOptions{Profile: "prod", ExternalId: "secret", RoleSessionName: "session", MfaSerial: "arn:aws:iam::111111111111:mfa/operator"}
+
+	// -profile prod -releaseAssumeRoleArn X assumes X from prod
+	release := CredentialOptions{AssumeRoleArn: "arn:aws:iam::222222222222:role/Release"}.WithDefaults(operator)
+	expected := CredentialOptions{Profile: "prod", AssumeRoleArn: "arn:aws:iam::222222222222:role/Release", ExternalId: "secret", RoleSessionName: "session", MfaSerial: "arn:aws:iam::111111111111:mfa/operator"}
+	if release != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, release)
+	}
+
+	release = CredentialOptions{Profile: "releases", ExternalId: "other"}.WithDefaults(operator)
+	if release.Profile != "releases" || release.ExternalId != "other" || release.MfaSerial != operator.MfaSerial {
+		t.Fatalf("Release options should override the operator options, got %+v", release)
+	}
+
+	if release := (CredentialOptions{}).WithDefaults(operator); release != operator {
+		t.Fatalf("Release store should use the operator credentials, got %+v", release)
+	}
+}
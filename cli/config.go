@@ -0,0 +1,234 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigEnvPrefix starts the environment variables that set options, e.g.
+// SMEDGE_DEVICE_FLEET for -deviceFleet. SMEDGE_CONFIG names the config file.
+const ConfigEnvPrefix = "SMEDGE_"
+
+// PrintEffectiveConfigCommand prints every option with its value and source.
+const PrintEffectiveConfigCommand = "print-effective-config"
+
+// Sources of option values, from highest to lowest precedence.
+const (
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// ConfigValue is the effective value of an option. Booleans and numbers keep their
+// type, other values are given as on the command line.
+type ConfigValue struct {
+	Name   string
+	Value  interface{}
+	Source string
+}
+
+// nonConfigFlags only make sense on the command line.
+var nonConfigFlags = map[string]bool{"config": true, "version": true, "dist": true}
+
+// secretFlags are masked by print-effective-config.
+var secretFlags = map[string]bool{"externalId": true, "releaseExternalId": true}
+
+// EnvName returns the environment variable of a flag, e.g. SMEDGE_IOT_THING_TYPE
+// for iotThingType and SMEDGE_ENABLE_DB for enableDB.
+func EnvName(flagName string) string {
+	runes := []rune(flagName)
+	var name strings.Builder
+	name.WriteString(ConfigEnvPrefix)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			previous := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextLower) {
+				name.WriteRune('_')
+			}
+		}
+		name.WriteRune(unicode.ToUpper(r))
+	}
+	return name.String()
+}
+
+// configFile holds the options of a YAML config file as flag values, keyed by flag name.
+type configFile struct {
+	Values map[string]string
+	// AgentConfigOverrides is set if the file lists the overrides inline instead of naming a file
+	AgentConfigOverrides map[string]interface{}
+}
+
+// loadConfigFile reads a YAML config file with flag names as keys. Lists are joined with
+// commas, agentConfigOverrides may be given inline as a mapping.
+func loadConfigFile(path string, fs *flag.FlagSet) (*configFile, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	document := make(map[string]interface{})
+	if err := yaml.Unmarshal(contents, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	file := &configFile{Values: make(map[string]string)}
+	problems := make([]string, 0)
+	for _, key := range sortedConfigKeys(document) {
+		if fs.Lookup(key) == nil || nonConfigFlags[key] {
+			problems = append(problems, fmt.Sprintf("unknown option %s", key))
+			continue
+		}
+		switch value := document[key].(type) {
+		case nil:
+		case map[string]interface{}:
+			if key != "agentConfigOverrides" {
+				problems = append(problems, fmt.Sprintf("%s must be a single value", key))
+				continue
+			}
+			file.AgentConfigOverrides = value
+		case []interface{}:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			file.Values[key] = strings.Join(items, ",")
+		default:
+			file.Values[key] = fmt.Sprint(value)
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid config file %s: %s", path, strings.Join(problems, "; "))
+	}
+	return file, nil
+}
+
+// applyConfig sets the flags not given on the command line from environ and then from
+// the config file, and returns the effective value and source of every option.
+func applyConfig(fs *flag.FlagSet, configPath string, environ []string) ([]ConfigValue, map[string]interface{}, error) {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	env := make(map[string]string)
+	for _, entry := range environ {
+		if key := strings.SplitN(entry, "=", 2); len(key) == 2 && strings.HasPrefix(key[0], ConfigEnvPrefix) {
+			env[key[0]] = key[1]
+		}
+	}
+	if configPath == "" {
+		configPath = env[ConfigEnvPrefix+"CONFIG"]
+	}
+	delete(env, ConfigEnvPrefix+"CONFIG")
+
+	file := &configFile{Values: make(map[string]string)}
+	if configPath != "" {
+		var err error
+		if file, err = loadConfigFile(configPath, fs); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	values := make([]ConfigValue, 0)
+	var inlineOverrides map[string]interface{}
+	problems := make([]string, 0)
+	fs.VisitAll(func(f *flag.Flag) {
+		if nonConfigFlags[f.Name] {
+			return
+		}
+		envName := EnvName(f.Name)
+		envValue, inEnv := env[envName]
+		delete(env, envName)
+		fileValue, inFile := file.Values[f.Name]
+
+		source := SourceDefault
+		switch {
+		case explicit[f.Name]:
+			source = SourceFlag
+		case inEnv:
+			source = SourceEnv
+			if err := fs.Set(f.Name, envValue); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", envName, err))
+			}
+		case inFile:
+			source = SourceFile
+			if err := fs.Set(f.Name, fileValue); err != nil {
+				problems = append(problems, fmt.Sprintf("%s in %s: %s", f.Name, configPath, err))
+			}
+		case f.Name == "agentConfigOverrides" && file.AgentConfigOverrides != nil:
+			source = SourceFile
+			inlineOverrides = file.AgentConfigOverrides
+		}
+		values = append(values, ConfigValue{Name: f.Name, Value: configValue(f.Value), Source: source})
+	})
+	unknown := make([]string, 0, len(env))
+	for name := range env {
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		problems = append(problems, fmt.Sprintf("unknown environment variable %s", name))
+	}
+	if len(problems) > 0 {
+		return nil, nil, errors.New(strings.Join(problems, "; "))
+	}
+	return values, inlineOverrides, nil
+}
+
+// EffectiveConfigYaml renders values as a config file with the source of each value
+// as a comment. Secrets and proxy passwords are masked.
+func EffectiveConfigYaml(values []ConfigValue, agentConfigOverrides map[string]interface{}) ([]byte, error) {
+	document := &yaml.Node{Kind: yaml.MappingNode}
+	for _, value := range values {
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: value.Name}
+		node := &yaml.Node{}
+		if value.Name == "agentConfigOverrides" && agentConfigOverrides != nil {
+			if err := node.Encode(agentConfigOverrides); err != nil {
+				return nil, err
+			}
+		} else {
+			plain := value.Value
+			if text, ok := plain.(string); ok && text != "" {
+				if secretFlags[value.Name] {
+					plain = "****"
+				} else if value.Name == "proxy" {
+					if proxyUrl, err := url.Parse(text); err == nil {
+						plain = proxyUrl.Redacted()
+					}
+				}
+			}
+			if err := node.Encode(plain); err != nil {
+				return nil, err
+			}
+		}
+		key.LineComment = value.Source
+		document.Content = append(document.Content, key, node)
+	}
+	return yaml.Marshal(document)
+}
+
+func configValue(value flag.Value) interface{} {
+	if getter, ok := value.(flag.Getter); ok {
+		switch typed := getter.Get().(type) {
+		case bool, int, int64, uint, uint64, float64:
+			return typed
+		}
+	}
+	return value.String()
+}
+
+func sortedConfigKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvName(t *testing.T) {
+	cases := map[string]string{
+		"deviceFleet":              "SMEDGE_DEVICE_FLEET",
+		"enableDB":                 "SMEDGE_ENABLE_DB",
+		"s3FolderPrefix":           "SMEDGE_S3_FOLDER_PREFIX",
+		"downloadRootCA":           "SMEDGE_DOWNLOAD_ROOT_CA",
+		"capturePushPeriodSeconds": "SMEDGE_CAPTURE_PUSH_PERIOD_SECONDS",
+		"os":                       "SMEDGE_OS",
+	}
+	for flagName, expected := range cases {
+		if name := EnvName(flagName); name != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, flagName, name)
+		}
+	}
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "setup.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyConfigPrecedence(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	deviceFleet := fs.String("deviceFleet", "", "")
+	deviceName := fs.String("deviceName", "", "")
+	region := fs.String("region", "us-west-2", "")
+	arch := fs.String("arch", "", "")
+	enableDB := fs.Bool("enableDB", false, "")
+	verifyTimeout := fs.Duration("verifyTimeout", 30*time.Second, "")
+	noProxy := fs.String("noProxy", "", "")
+	fs.String("agentConfigOverrides", "", "")
+	if err := fs.Parse([]string{"-deviceName", "from-flag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeConfigFile(t, `
+deviceFleet: from-file
+deviceName: ignored
+arch: armv8
+enableDB: true
+verifyTimeout: 1m
+noProxy: [localhost, 10.0.0.0/8]
+agentConfigOverrides:
+  sagemaker_edge_core_capture_data_batch_size: 10
+`)
+	environ := []string{"SMEDGE_CONFIG=" + path, "SMEDGE_ARCH=x64", "HOME=/root"}
+	values, overrides, err := applyConfig(fs, "", environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *deviceFleet != "from-file" || *deviceName != "from-flag" || *region != "us-west-2" || *arch != "x64" || !*enableDB || *verifyTimeout != time.Minute || *noProxy != "localhost,10.0.0.0/8" {
+		t.Errorf("Unexpected values %s %s %s %s %t %s %s", *deviceFleet, *deviceName, *region, *arch, *enableDB, *verifyTimeout, *noProxy)
+	}
+	if overrides["sagemaker_edge_core_capture_data_batch_size"] != 10 {
+		t.Errorf("Unexpected inline overrides %v", overrides)
+	}
+
+	sources := make(map[string]string)
+	for _, value := range values {
+		sources[value.Name] = value.Source
+	}
+	expected := map[string]string{"deviceFleet": SourceFile, "deviceName": SourceFlag, "region": SourceDefault, "arch": SourceEnv, "agentConfigOverrides": SourceFile}
+	for name, source := range expected {
+		if sources[name] != source {
+			t.Errorf("Expected %s from %s, got %s", name, source, sources[name])
+		}
+	}
+
+	config, err := EffectiveConfigYaml(values, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(config), "deviceName: from-flag # flag") || !strings.Contains(string(config), "sagemaker_edge_core_capture_data_batch_size: 10") {
+		t.Errorf("Unexpected effective config:\n%s", config)
+	}
+}
+
+func TestApplyConfigRejectsUnknownOptions(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("deviceFleet", "", "")
+	fs.Bool("enableDB", false, "")
+
+	path := writeConfigFile(t, "deviceFleets: typo\n")
+	if _, _, err := applyConfig(fs, path, nil); err == nil || !strings.Contains(err.Error(), "deviceFleets") {
+		t.Errorf("Expected the unknown option to be reported, got %v", err)
+	}
+	if _, _, err := applyConfig(fs, "", []string{"SMEDGE_DEVICE_FLEAT=typo"}); err == nil {
+		t.Error("Expected the unknown environment variable to be reported")
+	}
+	if _, _, err := applyConfig(fs, "", []string{"SMEDGE_ENABLE_DB=maybe"}); err == nil {
+		t.Error("Expected the invalid boolean to be reported")
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/aws"
+	"aws-sagemaker-edge-quick-device-setup/cache"
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/common"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	awsStd "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iot"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+func runCacheCommand(cliArgs *cli.CliArgs) {
+	if len(cliArgs.CommandArgs) != 1 {
+		log.Fatal("Usage: cache list|prune [-cacheDirectory dir] [-cacheMaxAge duration]")
+	}
+
+	objectCache, err := cache.New(cliArgs.CacheDirectory)
+	if err != nil {
+		log.Fatal("Failed to initialize download cache. Encountered Error ", err)
+	}
+
+	switch cliArgs.CommandArgs[0] {
+	case "list":
+		entries, err := objectCache.List()
+		if err != nil {
+			log.Fatal("Failed to list cache entries. Encountered Error ", err)
+		}
+		printCacheEntries(entries)
+	case "prune":
+		removed, err := objectCache.Prune(cliArgs.CacheMaxAge)
+		if err != nil {
+			log.Fatal("Failed to prune cache. Encountered Error ", err)
+		}
+		fmt.Printf("Removed %d cache entries not used within %s\n", len(removed), cliArgs.CacheMaxAge)
+		printCacheEntries(removed)
+	default:
+		log.Fatalf("Unknown cache command %s\n", cliArgs.CommandArgs[0])
+	}
+}
+
+func printCacheEntries(entries []cache.Entry) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "DIGEST\tSIZE\tLAST USED\tOBJECT")
+	for _, entry := range entries {
+		fmt.Fprintf(writer, "%.12s\t%d\t%s\ts3://%s/%s\n", entry.Digest, entry.Size, entry.LastUsed.Local().Format(time.RFC3339), entry.Bucket, entry.Key)
+	}
+	writer.Flush()
+}
+
+// runVerifyCredentialsCommand proves the chain the agent relies on: the device certificate
+// is exchanged for role alias credentials, which must be able to write to the fleet bucket.
+// Agents capturing to disk have no bucket in their config, -deviceFleetBucket is checked
+// instead if given.
+func runVerifyCredentialsCommand(ctx context.Context, cliArgs *cli.CliArgs) {
+	configPath := filepath.Join(cliArgs.AgentDirectory, "sagemaker_edge_config.json")
+	config, err := common.LoadAgentConfig(configPath)
+	if err != nil {
+		log.Fatalf("Failed to load agent config %s. Encountered error %s\n", configPath, err)
+	}
+
+	httpClient, err := common.NewDeviceHTTPClient(config, &cliArgs.Http)
+	if err != nil {
+		log.Fatal("Failed to configure device http client. Encountered Error ", err)
+	}
+
+	log.Printf("Requesting credentials from %s as %s...\n", config.ProviderAwsIotCredEndpoint, config.IotThingName)
+	credentials, err := common.FetchIotCredentials(ctx, httpClient, config)
+	if err != nil {
+		log.Fatal("Failed to get credentials with the device certificate. Encountered Error ", err)
+	}
+	log.Printf("Received credentials %s valid until %s\n", credentials.AccessKeyId, credentials.Expiration.Local().Format(time.RFC3339))
+
+	plainClient, err := common.NewHTTPClient(&cliArgs.Http)
+	if err != nil {
+		log.Fatal("Failed to configure http client. Encountered Error ", err)
+	}
+	s3Client := s3.New(s3.Options{
+		Region:     config.Region,
+		HTTPClient: plainClient,
+		Credentials: awsStd.CredentialsProviderFunc(func(ctx context.Context) (awsStd.Credentials, error) {
+			return awsStd.Credentials{
+				AccessKeyID:     credentials.AccessKeyId,
+				SecretAccessKey: credentials.SecretAccessKey,
+				SessionToken:    credentials.SessionToken,
+				CanExpire:       true,
+				Expires:         credentials.Expiration,
+			}, nil
+		}),
+	})
+
+	bucket := config.S3BucketName
+	if bucket == "" {
+		bucket = cliArgs.DeviceFleetBucket
+	}
+	if bucket == "" {
+		log.Println("Agent captures to disk and no -deviceFleetBucket was given, skipping the fleet bucket check.")
+		log.Println("Device credentials verified without bucket access.")
+		return
+	}
+
+	key := path.Join(config.FolderPrefix, "verify-credentials", config.IotThingName+".txt")
+	log.Printf("Writing s3://%s/%s with the device credentials...\n", bucket, key)
+	if err := aws.VerifyBucketAccess(ctx, s3Client, &bucket, &key); err != nil {
+		log.Fatal("Device credentials cannot access the fleet bucket. Encountered Error ", err)
+	}
+	log.Println("Device credentials verified.")
+}
+
+// runBundleCommand provisions the device from a workstation. The agent is staged in a
+// temporary directory with device side paths and packaged with an install script.
+func runBundleCommand(ctx context.Context, cliArgs *cli.CliArgs) {
+	staging, err := ioutil.TempDir("", "sagemaker-edge-bundle-")
+	if err != nil {
+		log.Fatal("Failed to create staging directory. Encountered Error ", err)
+	}
+	// the staging directory holds the device's private key until it is packaged, it is
+	// removed before any fatal error is logged
+	err = os.Chmod(staging, 0700)
+	if err == nil {
+		err = bundle(ctx, cliArgs, staging)
+	}
+	if removeErr := os.RemoveAll(staging); removeErr != nil {
+		log.Printf("Failed to remove staging directory %s. Encountered Error %s\n", staging, removeErr)
+	}
+	if err != nil {
+		log.Fatal("Failed to create device bundle. Encountered Error ", err)
+	}
+}
+
+func bundle(ctx context.Context, cliArgs *cli.CliArgs, staging string) error {
+	cliArgs.AgentDirectory = filepath.Join(staging, "agent")
+	if err := setup(ctx, cliArgs); err != nil {
+		return fmt.Errorf("setup failed: %w", err)
+	}
+
+	log.Println("Packaging device bundle...")
+	if err := common.WriteBundle(cliArgs, staging); err != nil {
+		return fmt.Errorf("failed to write bundle install script: %w", err)
+	}
+	topLevel := fmt.Sprintf("sagemaker-edge-%s", cliArgs.DeviceName)
+	if err := common.CreateTarball(staging, cliArgs.BundleOutput, topLevel); err != nil {
+		return fmt.Errorf("failed to create bundle %s: %w", cliArgs.BundleOutput, err)
+	}
+	log.Printf("Device bundle written to %s. Copy it to the device and run %s/install.sh as root.\n", cliArgs.BundleOutput, topLevel)
+	return nil
+}
+
+// runPrintEffectiveConfigCommand prints the merged options as a config file, with the
+// source of each value as a comment.
+func runPrintEffectiveConfigCommand(cliArgs *cli.CliArgs) {
+	config, err := cli.EffectiveConfigYaml(cliArgs.EffectiveConfig, cliArgs.AgentConfigOverrideValues)
+	if err != nil {
+		log.Fatal("Failed to encode configuration. Encountered Error ", err)
+	}
+	fmt.Print(string(config))
+}
+
+// runPrintRequiredPermissionsCommand prints the policy setup needs with the given options.
+// Without -account the ARNs match any account.
+func runPrintRequiredPermissionsCommand(cliArgs *cli.CliArgs) {
+	if cliArgs.Account == "" {
+		cliArgs.Account = "*"
+	}
+	policyDocument := aws.RequiredPolicyDocument(aws.RequiredPermissions(cliArgs))
+	policy, err := json.MarshalIndent(policyDocument, "", "    ")
+	if err != nil {
+		log.Fatal("Failed to encode policy. Encountered Error ", err)
+	}
+	fmt.Println(string(policy))
+}
+
+// runStatusCommand prints the fleet, registration, thing and certificates of the device
+// and whether the agent directory holds a config.
+func runStatusCommand(ctx context.Context, cliArgs *cli.CliArgs) {
+	clients, err := newClients(ctx, cliArgs)
+	if err != nil {
+		log.Fatal("Failed to configure aws clients. Encountered Error ", err)
+	}
+	p, err := newProvisioner(cliArgs, clients, nil)
+	if err != nil {
+		log.Fatal("Invalid options. Encountered Error ", err)
+	}
+	status, err := p.Status(ctx)
+	if err != nil {
+		log.Fatal("Failed to get device status. Encountered Error ", err)
+	}
+
+	fmt.Printf("Device Fleet: %s (exists: %t)\n", cliArgs.DeviceFleet, status.FleetExists)
+	if status.RoleAliasArn != "" {
+		fmt.Printf("Role Alias: %s\n", status.RoleAliasArn)
+	}
+	fmt.Printf("Device: %s (registered: %t)\n", cliArgs.DeviceName, status.DeviceRegistered)
+	if status.AgentVersion != "" {
+		fmt.Printf("\tAgent Version: %s\n", status.AgentVersion)
+	}
+	if status.LatestHeartbeat != nil {
+		fmt.Printf("\tLatest Heartbeat: %s\n", status.LatestHeartbeat.Local().Format(time.RFC3339))
+	}
+	fmt.Printf("IOT Thing: %s (exists: %t)\n", p.Options().IotThingName, status.ThingExists)
+	for _, certificate := range status.Certificates {
+		fmt.Printf("\tCertificate: %s %s\n", certificate.Arn, certificate.Status)
+	}
+	if status.ConfigPath != "" {
+		fmt.Printf("Agent Config: %s\n", status.ConfigPath)
+	} else {
+		fmt.Printf("Agent Config: missing in %s\n", cliArgs.AgentDirectory)
+	}
+	if state := status.LastSetup; state != nil {
+		outcome := "completed"
+		if state.Interrupted {
+			outcome = "interrupted at " + state.FailedStep
+		} else if !state.Completed {
+			outcome = "failed at " + state.FailedStep
+		}
+		fmt.Printf("Last Setup: %s %s\n", state.Finished.Local().Format(time.RFC3339), outcome)
+	}
+}
+
+// runTeardownCommand removes the device's registration, iot thing and certificates.
+func runTeardownCommand(ctx context.Context, cliArgs *cli.CliArgs) {
+	clients, err := newClients(ctx, cliArgs)
+	if err != nil {
+		log.Fatal("Failed to configure aws clients. Encountered Error ", err)
+	}
+	p, err := newProvisioner(cliArgs, clients, nil)
+	if err != nil {
+		log.Fatal("Invalid options. Encountered Error ", err)
+	}
+	result, err := p.Teardown(ctx)
+	if err != nil {
+		log.Fatal("Teardown failed. Encountered Error ", err)
+	}
+
+	log.Printf("Device deregistered: %t\n", result.DeregisteredDevice)
+	for _, certificate := range result.DetachedCertificates {
+		log.Printf("Certificate detached: %s\n", certificate)
+	}
+	for _, certificate := range result.DeletedCertificates {
+		log.Printf("Certificate deleted: %s\n", certificate)
+	}
+	for _, policy := range result.DeletedPolicies {
+		log.Printf("Iot policy deleted: %s\n", policy)
+	}
+	log.Printf("Iot thing deleted: %t\n", result.DeletedThing)
+	log.Println("Fleet resources and the local agent directory are kept.")
+}
+
+// runCheckNetworkCommand checks DNS, TCP, proxy and TLS for every endpoint of the device.
+// An existing agent config provides region, bucket and credential provider, otherwise
+// they are looked up with the operator's credentials.
+func runCheckNetworkCommand(ctx context.Context, cliArgs *cli.CliArgs) {
+	region := cliArgs.Region
+	fleetBucket := cliArgs.DeviceFleetBucket
+	credentialEndpoint := ""
+	var certificates []tls.Certificate
+
+	configPath := filepath.Join(cliArgs.AgentDirectory, "sagemaker_edge_config.json")
+	if config, err := common.LoadAgentConfig(configPath); err == nil {
+		log.Printf("Using endpoints of agent config %s\n", configPath)
+		region = config.Region
+		fleetBucket = config.S3BucketName
+		credentialEndpoint = config.ProviderAwsIotCredEndpoint
+		// the credential provider requires the device certificate
+		if certificate, err := tls.LoadX509KeyPair(config.AwsCertFile, config.AwsCertPKFile); err == nil {
+			certificates = []tls.Certificate{certificate}
+		}
+	} else if os.IsNotExist(err) {
+		httpClient, err := common.NewHTTPClient(&cliArgs.Http)
+		if err != nil {
+			log.Fatal("Failed to configure http client. Encountered Error ", err)
+		}
+		cfg, err := aws.LoadConfig(ctx, region, httpClient, &cliArgs.Credentials, &cliArgs.Retry)
+		if err != nil {
+			log.Fatal("Failed to load default aws config. Encountered Error ", err)
+		}
+		if fleetBucket == "" {
+			if _, err := aws.ResolveAccount(ctx, sts.NewFromConfig(cfg), &cliArgs.Account); err != nil {
+				log.Fatal("Failed to resolve AWS account. Encountered Error ", err)
+			}
+			fleetBucket = aws.DefaultDeviceFleetBucket(cliArgs.Account)
+		}
+		roleAlias := fmt.Sprintf("SageMakerEdge-%s", cliArgs.DeviceFleet)
+		endpoint, err := aws.GetIotCredentialProviderEndpoint(ctx, iot.NewFromConfig(cfg), &roleAlias)
+		if err != nil {
+			log.Fatal("Failed to look up the credential provider endpoint. Encountered Error ", err)
+		}
+		credentialEndpoint = *endpoint
+	} else {
+		log.Fatalf("Failed to load agent config %s. Encountered error %s\n", configPath, err)
+	}
+
+	checker, err := common.NewNetworkChecker(&cliArgs.Http, cliArgs.NetworkTimeout)
+	if err != nil {
+		log.Fatal("Failed to configure network checks. Encountered Error ", err)
+	}
+
+	failures := make([]string, 0)
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ENDPOINT\tHOST\tPROXY\tDNS\tTCP\tTLS\tTIME")
+	for _, endpoint := range common.NetworkEndpoints(cliArgs, region, fleetBucket, credentialEndpoint) {
+		if endpoint.URL == credentialEndpoint {
+			endpoint.Certificates = certificates
+		}
+		result := checker.Check(ctx, endpoint)
+		proxy := result.Proxy
+		if proxy == "" {
+			proxy = "direct"
+		} else if result.ProxyError != nil {
+			proxy += " (failed)"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", endpoint.Name, result.Host, proxy,
+			stageStatus(result.DNSError, true),
+			stageStatus(result.TCPError, result.DNSError == nil),
+			stageStatus(result.TLSError, result.DNSError == nil && result.TCPError == nil && result.ProxyError == nil),
+			result.Duration.Round(time.Millisecond))
+		if err := result.Err(); err != nil {
+			failures = append(failures, fmt.Sprintf("%s (%s): %s", endpoint.Name, result.Host, err))
+		}
+	}
+	writer.Flush()
+
+	for _, failure := range failures {
+		log.Println(failure)
+	}
+	if len(failures) > 0 {
+		log.Fatalf("%d endpoints are not reachable.\n", len(failures))
+	}
+}
+
+func stageStatus(err error, ran bool) string {
+	switch {
+	case !ran:
+		return "-"
+	case err != nil:
+		return "failed"
+	}
+	return "ok"
+}
@@ -2,10 +2,18 @@ package common
 
 import (
 	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
+	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
 )
 
 type AgentConfig struct {
@@ -24,46 +32,148 @@ type AgentConfig struct {
 	ProviderAwsIotCredEndpoint   string `json:"sagemaker_edge_provider_aws_iot_cred_endpoint"`
 	ProviderProvider             string `json:"sagemaker_edge_provider_provider"`
 	ProviderProviderPath         string `json:"sagemaker_edge_provider_provider_path"`
-	S3BucketName                 string `json:"sagemaker_edge_provider_s3_bucket_name"`
+	S3BucketName                 string `json:"sagemaker_edge_provider_s3_bucket_name,omitempty"`
 	DataCaptureDestination       string `json:"sagemaker_edge_core_capture_data_destination"`
+	CaptureDataDiskPath          string `json:"sagemaker_edge_core_capture_data_disk_path,omitempty"`
 	DBModulePath                 string `json:"sagemaker_edge_db_module_path,omitempty"`
 	LocalDataRootPath            string `json:"sagemaker_edge_local_data_root_path,omitempty"`
 	DeploymentLibPath            string `json:"sagemaker_edge_deployment_lib_path,omitempty"`
 	DeploymentPollInterval       int    `json:"sagemaker_edge_model_deployment_poll_interval,omitempty"`
 	DLRBackendOptions            string `json:"sagemaker_edge_dlr_backend_options,omitempty"`
+	// Extra holds override keys unknown to this tool, written as is
+	Extra map[string]interface{} `json:"-"`
 }
 
 func (config *AgentConfig) FromCliArgs(cliArgs *cli.CliArgs) {
+	// paths are written as the agent sees them on the device
+	agentDirectory := cliArgs.DeviceAgentDirectory()
 	config.DeviceName = cliArgs.DeviceName
 	config.DeviceFleetName = cliArgs.DeviceFleet
 	config.IotThingName = cliArgs.IotThingName
-	config.CapturDataBatchSize = 1
-	config.CaptureDataBufferSize = 2
-	config.CaptureDataPushPeriodSeconds = 5
+	config.CapturDataBatchSize = cliArgs.Capture.BatchSize
+	config.CaptureDataBufferSize = cliArgs.Capture.BufferSize
+	config.CaptureDataPushPeriodSeconds = cliArgs.Capture.PushPeriodSeconds
 	config.FolderPrefix = cliArgs.S3FolderPrefix
 	config.Region = cliArgs.Region
-	config.AwsRootCertsPath = filepath.Join(cliArgs.AgentDirectory, "certificates")
-	config.AwsCaCertFile = filepath.Join(cliArgs.AgentDirectory, "iot-credentials", "AmazonRootCA1.pem")
-	config.AwsCertFile = filepath.Join(cliArgs.AgentDirectory, "iot-credentials", "device.pem.crt")
-	config.AwsCertPKFile = filepath.Join(cliArgs.AgentDirectory, "iot-credentials", "private.pem.key")
+	config.AwsRootCertsPath = filepath.Join(agentDirectory, "certificates")
+	config.AwsCaCertFile = filepath.Join(agentDirectory, "iot-credentials", "AmazonRootCA1.pem")
+	config.AwsCertFile = filepath.Join(agentDirectory, "iot-credentials", "device.pem.crt")
+	config.AwsCertPKFile = filepath.Join(agentDirectory, "iot-credentials", "private.pem.key")
 	config.ProviderAwsIotCredEndpoint = "endpoint"
 	config.ProviderProvider = "Aws"
-	config.ProviderProviderPath = filepath.Join(cliArgs.AgentDirectory, "lib", "libprovider_aws.so")
+	config.ProviderProviderPath = filepath.Join(agentDirectory, "lib", "libprovider_aws.so")
 	if cliArgs.EnableDB {
-		config.DBModulePath = filepath.Join(cliArgs.AgentDirectory, "lib", "libsagemaker_edge_db_handler_library.so")
-		config.LocalDataRootPath = filepath.Join(cliArgs.AgentDirectory, "local_data")
+		config.DBModulePath = filepath.Join(agentDirectory, "lib", "libsagemaker_edge_db_handler_library.so")
+		config.LocalDataRootPath = filepath.Join(agentDirectory, "local_data")
 	}
 	if cliArgs.EnableDeployment {
-		config.DeploymentLibPath = filepath.Join(cliArgs.AgentDirectory, "lib", "libdeployment_smedge_library.so")
+		config.DeploymentLibPath = filepath.Join(agentDirectory, "lib", "libdeployment_smedge_library.so")
 		config.DeploymentPollInterval = 1440
 	}
-	config.DLRBackendOptions = ""
-	config.S3BucketName = cliArgs.DeviceFleetBucket
-	config.DataCaptureDestination = "Cloud"
+	config.DLRBackendOptions = cliArgs.TargetPlatform.GetAccelerator().DLRBackendOptions
+	config.DataCaptureDestination = cliArgs.Capture.Destination
+	if cliArgs.Capture.Destination == constants.CAPTURE_DESTINATION_DISK {
+		config.CaptureDataDiskPath = cliArgs.Capture.DiskPath
+	} else {
+		config.S3BucketName = cliArgs.DeviceFleetBucket
+	}
+}
+
+// ValidateCapture checks that the capture destination has what it needs, also after
+// overrides changed it.
+func (config *AgentConfig) ValidateCapture() error {
+	switch config.DataCaptureDestination {
+	case constants.CAPTURE_DESTINATION_CLOUD:
+		if config.S3BucketName == "" {
+			return errors.New("capture destination Cloud requires sagemaker_edge_provider_s3_bucket_name")
+		}
+	case constants.CAPTURE_DESTINATION_DISK:
+		if config.CaptureDataDiskPath == "" {
+			return errors.New("capture destination Disk requires sagemaker_edge_core_capture_data_disk_path")
+		}
+	default:
+		return fmt.Errorf("unknown capture destination %s", config.DataCaptureDestination)
+	}
+	return nil
+}
+
+// WriteToJson atomically writes the config to configPath. A new config is logged, when a
+// different config already exists the changed keys are logged and the previous file is
+// kept as a timestamped backup.
+func (config *AgentConfig) WriteToJson(configPath *string, logger *log.Logger) error {
+	conf, err := json.MarshalIndent(config, "", " ")
+	if err != nil {
+		return err
+	}
+
+	previous, err := ioutil.ReadFile(*configPath)
+	switch {
+	case os.IsNotExist(err):
+		logger.Println(string(conf))
+	case err != nil:
+		return err
+	case bytes.Equal(previous, conf):
+		logger.Printf("Agent config %s is unchanged.\n", *configPath)
+		return nil
+	default:
+		changes, err := DiffAgentConfig(previous, conf)
+		if err != nil {
+			logger.Printf("Existing agent config %s is not valid JSON, replacing it.\n", *configPath)
+		} else {
+			logger.Printf("Agent config %s changes:\n", *configPath)
+			for _, change := range changes {
+				logger.Printf("\t%s\n", change)
+			}
+		}
+
+		backupPath := fmt.Sprintf("%s.%s.bak", *configPath, time.Now().Format("20060102T150405"))
+		if err := WriteFileAtomic(backupPath, previous, 0400); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", *configPath, err)
+		}
+		logger.Printf("Previous agent config saved to %s\n", backupPath)
+	}
+
+	return WriteFileAtomic(*configPath, conf, 0400)
+}
+
+// DiffAgentConfig compares two encoded configs key by key. Each change is reported as
+// "+ key: value" for added, "- key: value" for removed and "~ key: old -> new" for
+// modified keys, sorted by key.
+func DiffAgentConfig(previous []byte, current []byte) ([]string, error) {
+	before := make(map[string]interface{})
+	if err := json.Unmarshal(previous, &before); err != nil {
+		return nil, err
+	}
+	after := make(map[string]interface{})
+	if err := json.Unmarshal(current, &after); err != nil {
+		return nil, err
+	}
+
+	keys := sortedKeys(before)
+	for _, key := range sortedKeys(after) {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := make([]string, 0)
+	for _, key := range keys {
+		oldValue, hadKey := before[key]
+		newValue, hasKey := after[key]
+		switch {
+		case !hadKey:
+			changes = append(changes, fmt.Sprintf("+ %s: %s", key, encodeValue(newValue)))
+		case !hasKey:
+			changes = append(changes, fmt.Sprintf("- %s: %s", key, encodeValue(oldValue)))
+		case !reflect.DeepEqual(oldValue, newValue):
+			changes = append(changes, fmt.Sprintf("~ %s: %s -> %s", key, encodeValue(oldValue), encodeValue(newValue)))
+		}
+	}
+	return changes, nil
 }
 
-func (config *AgentConfig) WriteToJson(filepath *string) {
-	conf, _ := json.MarshalIndent(config, "", " ")
-	fmt.Println(string(conf))
-	_ = ioutil.WriteFile(*filepath, conf, 0400)
+func encodeValue(value interface{}) string {
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
 }
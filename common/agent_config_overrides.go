@@ -0,0 +1,245 @@
+package common
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type agentConfigKind int
+
+const (
+	kindString agentConfigKind = iota
+	kindInteger
+)
+
+// agentConfigKey describes the accepted values of a known agent configuration key.
+type agentConfigKey struct {
+	Kind   agentConfigKind
+	Min    int64
+	Max    int64
+	Values []string
+}
+
+var agentConfigSchema = map[string]agentConfigKey{
+	"sagemaker_edge_core_device_name":                      {Kind: kindString},
+	"sagemaker_edge_core_device_fleet_name":                {Kind: kindString},
+	"sagemaker_edge_core_iot_thing_name":                   {Kind: kindString},
+	"sagemaker_edge_core_capture_data_batch_size":          {Kind: kindInteger, Min: 1, Max: 10000},
+	"sagemaker_edge_core_capture_data_buffer_size":         {Kind: kindInteger, Min: 1, Max: 100000},
+	"sagemaker_edge_core_capture_data_push_period_seconds": {Kind: kindInteger, Min: 1, Max: 86400},
+	"sagemaker_edge_core_folder_prefix":                    {Kind: kindString},
+	"sagemaker_edge_core_region":                           {Kind: kindString},
+	"sagemaker_edge_core_root_certs_path":                  {Kind: kindString},
+	"sagemaker_edge_provider_aws_ca_cert_file":             {Kind: kindString},
+	"sagemaker_edge_provider_aws_cert_file":                {Kind: kindString},
+	"sagemaker_edge_provider_aws_cert_pk_file":             {Kind: kindString},
+	"sagemaker_edge_provider_aws_iot_cred_endpoint":        {Kind: kindString},
+	"sagemaker_edge_provider_provider":                     {Kind: kindString, Values: []string{"Aws"}},
+	"sagemaker_edge_provider_provider_path":                {Kind: kindString},
+	"sagemaker_edge_provider_s3_bucket_name":               {Kind: kindString},
+	"sagemaker_edge_core_capture_data_disk_path":           {Kind: kindString},
+	"sagemaker_edge_core_capture_data_destination":         {Kind: kindString, Values: []string{constants.CAPTURE_DESTINATION_CLOUD, constants.CAPTURE_DESTINATION_DISK}},
+	"sagemaker_edge_db_module_path":                        {Kind: kindString},
+	"sagemaker_edge_local_data_root_path":                  {Kind: kindString},
+	"sagemaker_edge_deployment_lib_path":                   {Kind: kindString},
+	"sagemaker_edge_model_deployment_poll_interval":        {Kind: kindInteger, Min: 1, Max: math.MaxInt32},
+	"sagemaker_edge_dlr_backend_options":                   {Kind: kindString},
+}
+
+// LoadAgentConfigOverrides reads agent configuration overrides from a JSON or YAML file
+// (by extension .yaml/.yml) and validates them against the known agent keys.
+func LoadAgentConfigOverrides(path string) (map[string]interface{}, error) {
+	overrides, err := ReadAgentConfigOverrides(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateAgentConfigOverrides(overrides); err != nil {
+		return nil, fmt.Errorf("invalid agent config overrides in %s: %w", path, err)
+	}
+	return overrides, nil
+}
+
+// ReadAgentConfigOverrides reads agent configuration overrides like LoadAgentConfigOverrides
+// without validating them, for overrides that are merged before validation.
+func ReadAgentConfigOverrides(path string) (map[string]interface{}, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &overrides)
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.UseNumber()
+		err = decoder.Decode(&overrides)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return overrides, nil
+}
+
+// MergeAgentConfigOverrides returns base with every key of overrides set on top of it.
+// Neither map is modified.
+func MergeAgentConfigOverrides(base map[string]interface{}, overrides map[string]interface{}) map[string]interface{} {
+	if base == nil && overrides == nil {
+		return nil
+	}
+	merged := make(map[string]interface{}, len(base)+len(overrides))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	return merged
+}
+
+// ValidateAgentConfigOverrides checks the type, range and allowed values of known keys.
+// Unknown keys are accepted as is so newer agent options can be set.
+func ValidateAgentConfigOverrides(overrides map[string]interface{}) error {
+	problems := make([]string, 0)
+	for _, key := range sortedKeys(overrides) {
+		schema, known := agentConfigSchema[key]
+		if !known {
+			continue
+		}
+		value := overrides[key]
+
+		switch schema.Kind {
+		case kindString:
+			text, ok := value.(string)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s must be a string, got %v", key, value))
+				continue
+			}
+			if len(schema.Values) > 0 && !containsString(schema.Values, text) {
+				problems = append(problems, fmt.Sprintf("%s must be one of %s, got %s", key, strings.Join(schema.Values, ", "), text))
+			}
+		case kindInteger:
+			number, ok := integerValue(value)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s must be an integer, got %v", key, value))
+				continue
+			}
+			if number < schema.Min || number > schema.Max {
+				problems = append(problems, fmt.Sprintf("%s must be between %d and %d, got %d", key, schema.Min, schema.Max, number))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// UnknownAgentConfigKeys returns the override keys that are not part of the schema.
+func UnknownAgentConfigKeys(overrides map[string]interface{}) []string {
+	unknown := make([]string, 0)
+	for _, key := range sortedKeys(overrides) {
+		if _, known := agentConfigSchema[key]; !known {
+			unknown = append(unknown, key)
+		}
+	}
+	return unknown
+}
+
+// ApplyOverrides merges validated overrides on top of the computed configuration.
+// Known keys replace the corresponding fields, unknown keys are kept in Extra.
+func (config *AgentConfig) ApplyOverrides(overrides map[string]interface{}) error {
+	if err := ValidateAgentConfigOverrides(overrides); err != nil {
+		return err
+	}
+
+	known := make(map[string]interface{})
+	for key, value := range overrides {
+		if _, ok := agentConfigSchema[key]; ok {
+			known[key] = value
+			continue
+		}
+		if config.Extra == nil {
+			config.Extra = make(map[string]interface{})
+		}
+		config.Extra[key] = value
+	}
+
+	encoded, err := json.Marshal(known)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, config)
+}
+
+// MarshalJSON appends the unknown keys of Extra after the known keys, keeping their order.
+func (config AgentConfig) MarshalJSON() ([]byte, error) {
+	type agentConfigFields AgentConfig
+	encoded, err := json.Marshal(agentConfigFields(config))
+	if err != nil || len(config.Extra) == 0 {
+		return encoded, err
+	}
+
+	var buffer bytes.Buffer
+	buffer.Write(bytes.TrimSuffix(encoded, []byte("}")))
+	for _, key := range sortedKeys(config.Extra) {
+		value, err := json.Marshal(config.Extra[key])
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", key, err)
+		}
+		name, _ := json.Marshal(key)
+		buffer.WriteByte(',')
+		buffer.Write(name)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+func integerValue(value interface{}) (int64, bool) {
+	switch number := value.(type) {
+	case int:
+		return int64(number), true
+	case int64:
+		return number, true
+	case uint64:
+		return int64(number), number <= math.MaxInt64
+	case json.Number:
+		parsed, err := number.Int64()
+		return parsed, err == nil
+	case float64:
+		return int64(number), number == math.Trunc(number)
+	}
+	return 0, false
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
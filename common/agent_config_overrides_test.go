@@ -0,0 +1,119 @@
+package common
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeOverrides(t *testing.T, name string, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAgentConfigOverrides(t *testing.T) {
+	jsonPath := writeOverrides(t, "overrides.json", `{
+		"sagemaker_edge_core_capture_data_batch_size": 10,
+		"sagemaker_edge_core_capture_data_destination": "Disk",
+		"sagemaker_edge_future_option": {"enabled": true}
+	}`)
+	yamlPath := writeOverrides(t, "overrides.yaml", `
+sagemaker_edge_core_capture_data_batch_size: 10
+sagemaker_edge_core_capture_data_destination: Disk
+sagemaker_edge_future_option:
+  enabled: true
+`)
+
+	for _, path := range []string{jsonPath, yamlPath} {
+		overrides, err := LoadAgentConfigOverrides(path)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+
+		config := AgentConfig{CapturDataBatchSize: 1, CaptureDataBufferSize: 2, DataCaptureDestination: constants.CAPTURE_DESTINATION_CLOUD}
+		if err := config.ApplyOverrides(overrides); err != nil {
+			t.Fatal(err)
+		}
+		if config.CapturDataBatchSize != 10 || config.DataCaptureDestination != constants.CAPTURE_DESTINATION_DISK {
+			t.Fatalf("%s: known keys should be overridden, got %d %s", path, config.CapturDataBatchSize, config.DataCaptureDestination)
+		}
+		if config.CaptureDataBufferSize != 2 {
+			t.Fatalf("%s: keys without override should be kept", path)
+		}
+
+		encoded, err := json.Marshal(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(encoded), `"sagemaker_edge_future_option":{"enabled":true}`) {
+			t.Fatalf("%s: unknown keys should be passed through, got %s", path, encoded)
+		}
+		if unknown := UnknownAgentConfigKeys(overrides); len(unknown) != 1 || unknown[0] != "sagemaker_edge_future_option" {
+			t.Fatalf("%s: unexpected unknown keys %v", path, unknown)
+		}
+	}
+}
+
+func TestValidateAgentConfigOverrides(t *testing.T) {
+	testCases := map[string]string{
+		"type":  `{"sagemaker_edge_core_capture_data_batch_size": "10"}`,
+		"float": `{"sagemaker_edge_core_capture_data_batch_size": 2.5}`,
+		"range": `{"sagemaker_edge_core_capture_data_push_period_seconds": 0}`,
+		"enum":  `{"sagemaker_edge_core_capture_data_destination": "Local"}`,
+		"name":  `{"sagemaker_edge_core_device_name": 42}`,
+	}
+
+	for name, contents := range testCases {
+		if _, err := LoadAgentConfigOverrides(writeOverrides(t, "overrides.json", contents)); err == nil {
+			t.Fatalf("%s: invalid override should be rejected", name)
+		}
+	}
+
+	if _, err := LoadAgentConfigOverrides(writeOverrides(t, "overrides.json", `[1, 2]`)); err == nil {
+		t.Fatal("Overrides must be an object")
+	}
+}
+
+func TestMarshalAgentConfigWithoutExtra(t *testing.T) {
+	config := AgentConfig{DeviceName: "some-device"}
+	encoded, _ := json.Marshal(config)
+
+	decoded := make(map[string]interface{})
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["sagemaker_edge_core_device_name"] != "some-device" {
+		t.Fatalf("Unexpected encoding %s", encoded)
+	}
+}
+
+func TestMergeAgentConfigOverrides(t *testing.T) {
+	inline := map[string]interface{}{
+		"sagemaker_edge_core_capture_data_batch_size":  10,
+		"sagemaker_edge_core_capture_data_buffer_size": 30,
+	}
+	file := map[string]interface{}{
+		"sagemaker_edge_core_capture_data_batch_size": 20,
+		"sagemaker_edge_future_option":                true,
+	}
+
+	merged := MergeAgentConfigOverrides(inline, file)
+	if len(merged) != 3 || merged["sagemaker_edge_core_capture_data_batch_size"] != 20 || merged["sagemaker_edge_core_capture_data_buffer_size"] != 30 {
+		t.Fatalf("File overrides should be merged over inline overrides key by key, got %v", merged)
+	}
+	if inline["sagemaker_edge_core_capture_data_batch_size"] != 10 || len(inline) != 2 {
+		t.Fatal("Inline overrides should not be modified")
+	}
+	if merged := MergeAgentConfigOverrides(nil, file); len(merged) != 2 {
+		t.Fatalf("Overrides without inline values should be kept, got %v", merged)
+	}
+	if MergeAgentConfigOverrides(nil, nil) != nil {
+		t.Fatal("No overrides should stay nil")
+	}
+}
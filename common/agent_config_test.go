@@ -2,12 +2,18 @@ package common
 
 import (
 	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"path/filepath"
 	"testing"
 )
 
 func TestFromCliArgs(t *testing.T) {
 	var config AgentConfig
-	tp := cli.TargetPlatform{"linux", "amd64", ""}
+	tp := options.TargetPlatform{Os: "linux", Arch: "amd64", Accelerator: ""}
 	cliArgs := cli.CliArgs{
 		DeviceFleet:       "some-fleet",
 		DeviceName:        "some-device",
@@ -69,3 +75,129 @@ func TestFromCliArgs(t *testing.T) {
 		t.Fatal("When deployment  is enabled interval should be 1440.")
 	}
 }
+
+func TestWriteToJson(t *testing.T) {
+	directory := t.TempDir()
+	configPath := filepath.Join(directory, "sagemaker_edge_config.json")
+	config := AgentConfig{DeviceName: "some-device", CapturDataBatchSize: 1}
+
+	if err := config.WriteToJson(&configPath, log.Default()); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.WriteToJson(&configPath, log.Default()); err != nil {
+		t.Fatal("Rewriting a read-only config should succeed: ", err)
+	}
+	if matches, _ := filepath.Glob(configPath + ".*.bak"); len(matches) != 0 {
+		t.Fatal("Unchanged config should not be backed up")
+	}
+
+	previous, _ := ioutil.ReadFile(configPath)
+	config.CapturDataBatchSize = 10
+	if err := config.WriteToJson(&configPath, log.Default()); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, _ := filepath.Glob(configPath + ".*.bak")
+	if len(matches) != 1 {
+		t.Fatalf("Changed config should be backed up once, found %d backups", len(matches))
+	}
+	if backup, _ := ioutil.ReadFile(matches[0]); string(backup) != string(previous) {
+		t.Fatal("Backup should contain the previous config")
+	}
+
+	written := AgentConfig{}
+	contents, _ := ioutil.ReadFile(configPath)
+	if err := json.Unmarshal(contents, &written); err != nil || written.CapturDataBatchSize != 10 {
+		t.Fatalf("Config should be replaced, got %s %v", contents, err)
+	}
+
+	entries, _ := ioutil.ReadDir(directory)
+	if len(entries) != 2 {
+		t.Fatalf("Temporary files should be removed, found %d entries", len(entries))
+	}
+}
+
+func TestDiffAgentConfig(t *testing.T) {
+	previous := []byte(`{"a": 1, "b": "x", "c": true}`)
+	current := []byte(`{"a": 2, "b": "x", "d": "new"}`)
+
+	changes, err := DiffAgentConfig(previous, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"~ a: 1 -> 2", "- c: true", "+ d: \"new\""}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, changes)
+		}
+	}
+}
+
+func TestFromCliArgsCapture(t *testing.T) {
+	cliArgs := cli.CliArgs{
+		DeviceFleetBucket: "sagemaker-edge-bucket-use",
+		AgentDirectory:    "/home/ubuntu/smedge_agent",
+		Capture: options.CaptureOptions{
+			Destination:       constants.CAPTURE_DESTINATION_DISK,
+			DiskPath:          "/data/capture",
+			BatchSize:         10,
+			BufferSize:        30,
+			PushPeriodSeconds: 60,
+		},
+	}
+
+	var config AgentConfig
+	config.FromCliArgs(&cliArgs)
+	if config.DataCaptureDestination != constants.CAPTURE_DESTINATION_DISK || config.CaptureDataDiskPath != "/data/capture" {
+		t.Fatal("Disk destination should be configured with its path")
+	}
+	if config.S3BucketName != "" {
+		t.Fatal("Disk destination should not configure the bucket")
+	}
+	if config.CapturDataBatchSize != 10 || config.CaptureDataBufferSize != 30 || config.CaptureDataPushPeriodSeconds != 60 {
+		t.Fatal("Capture sizes should be taken from the options")
+	}
+	if err := config.ValidateCapture(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.CaptureDataDiskPath = ""
+	if err := config.ValidateCapture(); err == nil {
+		t.Fatal("Disk destination without path should be rejected")
+	}
+
+	cliArgs.Capture.Destination = constants.CAPTURE_DESTINATION_CLOUD
+	config = AgentConfig{}
+	config.FromCliArgs(&cliArgs)
+	if config.S3BucketName != "sagemaker-edge-bucket-use" || config.CaptureDataDiskPath != "" {
+		t.Fatal("Cloud destination should configure the bucket only")
+	}
+	config.S3BucketName = ""
+	if err := config.ValidateCapture(); err == nil {
+		t.Fatal("Cloud destination without bucket should be rejected")
+	}
+}
+
+func TestFromCliArgsAccelerator(t *testing.T) {
+	cliArgs := cli.CliArgs{
+		AgentDirectory: "/home/ubuntu/smedge_agent",
+		TargetPlatform: options.TargetPlatform{Os: "linux", Arch: "arm64", Accelerator: "jetson"},
+	}
+
+	var config AgentConfig
+	config.FromCliArgs(&cliArgs)
+	if config.DLRBackendOptions != `{"device_type": "gpu", "use_tensorrt": true}` {
+		t.Fatalf("Accelerator DLR backend options should be configured, got %s", config.DLRBackendOptions)
+	}
+
+	cliArgs.TargetPlatform.Accelerator = ""
+	config = AgentConfig{}
+	config.FromCliArgs(&cliArgs)
+	if config.DLRBackendOptions != "" {
+		t.Fatal("CPU builds should not set DLR backend options")
+	}
+}
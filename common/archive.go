@@ -0,0 +1,622 @@
+package common
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// DefaultMaxExtractedBytes bounds the total size of extracted archive contents.
+const DefaultMaxExtractedBytes int64 = 4 << 30
+
+var ErrArchiveTooLarge = errors.New("archive exceeds the maximum extracted size")
+
+type ExtractOptions struct {
+	// MaxBytes is the maximum total size of extracted regular files.
+	MaxBytes int64
+	// Verify checks the staged entries before they are moved into the destination.
+	Verify func(staging string) error
+	// Logger receives skipped entries and the summary, nil logs to the standard logger.
+	Logger *log.Logger
+}
+
+func (opts *ExtractOptions) logger() *log.Logger {
+	if opts.Logger == nil {
+		return log.Default()
+	}
+	return opts.Logger
+}
+
+type entryType int
+
+const (
+	entryDir entryType = iota
+	entryFile
+	entrySymlink
+	entryHardlink
+)
+
+type archiveEntry struct {
+	name     string
+	kind     entryType
+	mode     os.FileMode
+	linkname string
+	size     int64
+	modTime  time.Time
+	uid      int
+	gid      int
+	hasOwner bool
+	// open returns the contents of a regular file or the target of a zip symlink
+	open func() (io.ReadCloser, error)
+}
+
+type ArchiveFormat string
+
+const (
+	FormatTar     ArchiveFormat = "tar"
+	FormatTarGzip ArchiveFormat = "tar.gz"
+	FormatTarXz   ArchiveFormat = "tar.xz"
+	FormatTarZstd ArchiveFormat = "tar.zst"
+	FormatZip     ArchiveFormat = "zip"
+)
+
+var ErrUnsupportedArchive = errors.New("unsupported archive format")
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte{'P', 'K', 0x03, 0x04}
+	// the ustar magic is stored at offset 257 of the first tar header
+	tarMagic       = []byte("ustar")
+	tarMagicOffset = 257
+)
+
+// DetectArchiveFormat identifies the archive format of path from its leading bytes.
+// Compressed streams are assumed to contain a tarball.
+func DetectArchiveFormat(path string) (ArchiveFormat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return FormatTarGzip, nil
+	case bytes.HasPrefix(header, xzMagic):
+		return FormatTarXz, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		return FormatTarZstd, nil
+	case bytes.HasPrefix(header, zipMagic):
+		return FormatZip, nil
+	case len(header) >= tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic):
+		return FormatTar, nil
+	}
+	return "", ErrUnsupportedArchive
+}
+
+// ExtractArchive detects the format of src and extracts it into dest.
+func ExtractArchive(src string, dest string, opts ExtractOptions) error {
+	format, err := DetectArchiveFormat(src)
+	if err != nil {
+		return err
+	}
+
+	if format == FormatZip {
+		return ExtractZip(src, dest, opts)
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	switch format {
+	case FormatTarGzip:
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case FormatTarXz:
+		xzReader, err := xz.NewReader(file)
+		if err != nil {
+			return err
+		}
+		reader = xzReader
+	case FormatTarZstd:
+		zstdReader, err := zstd.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer zstdReader.Close()
+		reader = zstdReader
+	}
+
+	return extractTarStream(reader, dest, opts)
+}
+
+func extractTarStream(reader io.Reader, dest string, opts ExtractOptions) error {
+	tarReader := tar.NewReader(reader)
+	return extract(dest, opts, func(emit func(*archiveEntry) error) error {
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			entry := &archiveEntry{
+				name:     header.Name,
+				mode:     os.FileMode(header.Mode).Perm(),
+				linkname: header.Linkname,
+				size:     header.Size,
+				modTime:  header.ModTime,
+				uid:      header.Uid,
+				gid:      header.Gid,
+				hasOwner: true,
+				open: func() (io.ReadCloser, error) {
+					return ioutil.NopCloser(tarReader), nil
+				},
+			}
+
+			switch header.Typeflag {
+			case tar.TypeDir:
+				entry.kind = entryDir
+			case tar.TypeReg, tar.TypeRegA:
+				entry.kind = entryFile
+			case tar.TypeSymlink:
+				entry.kind = entrySymlink
+			case tar.TypeLink:
+				entry.kind = entryHardlink
+			default:
+				opts.logger().Printf("Skipping unsupported tar entry type %c for %s\n", header.Typeflag, header.Name)
+				continue
+			}
+
+			if err := emit(entry); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// ExtractZip extracts the zip archive src into dest.
+func ExtractZip(src string, dest string, opts ExtractOptions) error {
+	reader, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return extract(dest, opts, func(emit func(*archiveEntry) error) error {
+		for _, f := range reader.File {
+			f := f
+			entry := &archiveEntry{
+				name:    f.Name,
+				mode:    f.Mode().Perm(),
+				size:    int64(f.UncompressedSize64),
+				modTime: f.Modified,
+				open: func() (io.ReadCloser, error) {
+					return f.Open()
+				},
+			}
+
+			switch {
+			case f.FileInfo().IsDir():
+				entry.kind = entryDir
+			case f.Mode()&os.ModeSymlink != 0:
+				entry.kind = entrySymlink
+				target, err := readZipLink(f)
+				if err != nil {
+					return err
+				}
+				entry.linkname = target
+			case f.Mode().IsRegular():
+				entry.kind = entryFile
+			default:
+				opts.logger().Printf("Skipping unsupported zip entry %s with mode %s\n", f.Name, f.Mode())
+				continue
+			}
+
+			if err := emit(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func readZipLink(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	// link targets are short, anything larger is not a valid symlink entry
+	target, err := ioutil.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return "", err
+	}
+	return string(target), nil
+}
+
+// extractor writes archive entries into a staging directory. All paths are resolved
+// relative to the staging root and rejected when they would end up outside of it.
+type extractor struct {
+	root    string
+	opts    ExtractOptions
+	written int64
+	dirs    map[string]*archiveEntry
+	count   int
+}
+
+// extract stages the entries produced by walk next to dest and, once every entry was
+// written successfully, swaps the staged top level entries into dest. Entries of dest
+// that are not part of the archive are left untouched.
+func extract(dest string, opts ExtractOptions, walk func(emit func(*archiveEntry) error) error) error {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxExtractedBytes
+	}
+
+	dest, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+		return err
+	}
+
+	staging, err := ioutil.TempDir(filepath.Dir(dest), "."+filepath.Base(dest)+"-staging-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	// resolve symlinks of the temp location so containment checks compare real paths
+	root, err := filepath.EvalSymlinks(staging)
+	if err != nil {
+		return err
+	}
+
+	ext := &extractor{root: root, opts: opts, dirs: make(map[string]*archiveEntry)}
+	if err := walk(ext.write); err != nil {
+		return err
+	}
+	if err := ext.finishDirs(); err != nil {
+		return err
+	}
+	if opts.Verify != nil {
+		if err := opts.Verify(root); err != nil {
+			return err
+		}
+	}
+
+	if err := swapInto(root, dest); err != nil {
+		return err
+	}
+	opts.logger().Printf("Extracted %d entries (%d bytes) into %s\n", ext.count, ext.written, dest)
+	return nil
+}
+
+// resolve maps an archive name to a path under the staging root.
+func (ext *extractor) resolve(name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%s: absolute paths are not allowed", name)
+	}
+	cleaned := filepath.Clean(name)
+	if cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%s: path escapes the destination", name)
+	}
+	return filepath.Join(ext.root, cleaned), nil
+}
+
+// within reports whether path is the staging root or below it.
+func (ext *extractor) within(path string) bool {
+	return path == ext.root || strings.HasPrefix(path, ext.root+string(os.PathSeparator))
+}
+
+// makeParent creates the parent directories of path one component at a time. Symlinks
+// created by earlier entries are followed only while they resolve inside the root, so no
+// directory is ever created outside of it.
+func (ext *extractor) makeParent(path string) error {
+	rel, err := filepath.Rel(ext.root, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+
+	current := ext.root
+	for _, component := range strings.Split(rel, string(os.PathSeparator)) {
+		current = filepath.Join(current, component)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(current, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			real, err := filepath.EvalSymlinks(current)
+			if err != nil {
+				return err
+			}
+			if !ext.within(real) {
+				return fmt.Errorf("%s: parent directory resolves outside the destination", path)
+			}
+			current = real
+			info, err = os.Stat(current)
+			if err != nil {
+				return err
+			}
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s: parent %s is not a directory", path, component)
+		}
+	}
+	return nil
+}
+
+func (ext *extractor) write(entry *archiveEntry) error {
+	path, err := ext.resolve(entry.name)
+	if err != nil {
+		return err
+	}
+	if path == ext.root {
+		// "./" entries describe the destination itself
+		return nil
+	}
+	if err := ext.makeParent(path); err != nil {
+		return err
+	}
+
+	switch entry.kind {
+	case entryDir:
+		err = ext.writeDir(path, entry)
+	case entryFile:
+		err = ext.writeFile(path, entry)
+	case entrySymlink:
+		err = ext.writeSymlink(path, entry)
+	case entryHardlink:
+		err = ext.writeHardlink(path, entry)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", entry.name, err)
+	}
+	ext.count++
+	return nil
+}
+
+func (ext *extractor) writeDir(path string, entry *archiveEntry) error {
+	info, err := os.Lstat(path)
+	if err == nil && !info.IsDir() {
+		return errors.New("directory conflicts with an existing entry")
+	}
+	// directories stay writable until every entry is extracted, the archived mode
+	// and modification time are applied by finishDirs
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	ext.dirs[path] = entry
+	return nil
+}
+
+func (ext *extractor) writeFile(path string, entry *archiveEntry) error {
+	if entry.size > ext.opts.MaxBytes-ext.written {
+		return ErrArchiveTooLarge
+	}
+	if err := removeExisting(path); err != nil {
+		return err
+	}
+
+	rc, err := entry.open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	// copy one byte beyond the limit to detect archives lying about their sizes
+	remaining := ext.opts.MaxBytes - ext.written
+	n, err := io.CopyN(out, rc, remaining+1)
+	ext.written += n
+	if err != nil && err != io.EOF {
+		out.Close()
+		return err
+	}
+	if n > remaining {
+		out.Close()
+		return ErrArchiveTooLarge
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return ext.applyMetadata(path, entry)
+}
+
+// writeSymlink creates the link with its cleaned target, so ".." only appears at the
+// start of it. The target is checked against the real parent directory, which differs
+// from the archive path when a parent is a symlink of an earlier entry.
+func (ext *extractor) writeSymlink(path string, entry *archiveEntry) error {
+	if entry.linkname == "" || filepath.IsAbs(filepath.FromSlash(entry.linkname)) {
+		return fmt.Errorf("symlink target %q is not a relative path", entry.linkname)
+	}
+	target := filepath.Clean(filepath.FromSlash(entry.linkname))
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if !ext.within(parent) || !ext.within(filepath.Join(parent, target)) {
+		return fmt.Errorf("symlink target %s escapes the destination", entry.linkname)
+	}
+	path = filepath.Join(parent, filepath.Base(path))
+	if err := removeExisting(path); err != nil {
+		return err
+	}
+	if err := os.Symlink(target, path); err != nil {
+		return err
+	}
+	if entry.hasOwner && os.Geteuid() == 0 {
+		return os.Lchown(path, entry.uid, entry.gid)
+	}
+	return nil
+}
+
+func (ext *extractor) writeHardlink(path string, entry *archiveEntry) error {
+	target, err := ext.resolve(entry.linkname)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(target)
+	if err != nil {
+		return fmt.Errorf("hardlink target %s must be extracted before the link: %w", entry.linkname, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("hardlink target %s is not a regular file", entry.linkname)
+	}
+	real, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return err
+	}
+	if !ext.within(real) {
+		return fmt.Errorf("hardlink target %s escapes the destination", entry.linkname)
+	}
+	if err := removeExisting(path); err != nil {
+		return err
+	}
+	return os.Link(real, path)
+}
+
+func (ext *extractor) applyMetadata(path string, entry *archiveEntry) error {
+	if entry.hasOwner && os.Geteuid() == 0 {
+		if err := os.Lchown(path, entry.uid, entry.gid); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(path, entry.mode); err != nil {
+		return err
+	}
+	if !entry.modTime.IsZero() {
+		return os.Chtimes(path, entry.modTime, entry.modTime)
+	}
+	return nil
+}
+
+// finishDirs applies directory modes and times deepest first, so that setting a
+// parent's mode or mtime is not undone by work on its children.
+func (ext *extractor) finishDirs() error {
+	paths := make([]string, 0, len(ext.dirs))
+	for path := range ext.dirs {
+		paths = append(paths, path)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+
+	for _, path := range paths {
+		if err := ext.applyMetadata(path, ext.dirs[path]); err != nil {
+			return fmt.Errorf("%s: %w", ext.dirs[path].name, err)
+		}
+	}
+	return nil
+}
+
+func removeExisting(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.New("file conflicts with an existing directory")
+	}
+	return os.Remove(path)
+}
+
+// swapInto moves every top level entry of staging into dest. Replaced entries are
+// moved aside first and restored if any rename fails.
+func swapInto(staging string, dest string) error {
+	entries, err := ioutil.ReadDir(staging)
+	if err != nil {
+		return err
+	}
+
+	backup, err := ioutil.TempDir(filepath.Dir(dest), "."+filepath.Base(dest)+"-previous-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(backup)
+
+	type move struct {
+		name     string
+		replaced bool
+	}
+	done := make([]move, 0, len(entries))
+	rollback := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			os.Rename(filepath.Join(dest, done[i].name), filepath.Join(staging, done[i].name))
+			if done[i].replaced {
+				os.Rename(filepath.Join(backup, done[i].name), filepath.Join(dest, done[i].name))
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		target := filepath.Join(dest, entry.Name())
+		replaced := false
+		if _, err := os.Lstat(target); err == nil {
+			if err := os.Rename(target, filepath.Join(backup, entry.Name())); err != nil {
+				rollback()
+				return err
+			}
+			replaced = true
+		}
+		if err := os.Rename(filepath.Join(staging, entry.Name()), target); err != nil {
+			if replaced {
+				os.Rename(filepath.Join(backup, entry.Name()), target)
+			}
+			rollback()
+			return err
+		}
+		done = append(done, move{name: entry.Name(), replaced: replaced})
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package common
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type testEntry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	body     string
+	linkname string
+}
+
+var testModTime = time.Date(2021, 5, 12, 10, 0, 0, 0, time.UTC)
+
+func buildTestTar(t *testing.T, entries []testEntry) []byte {
+	var buffer bytes.Buffer
+	tarWriter := tar.NewWriter(&buffer)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Mode:     entry.mode,
+			Size:     int64(len(entry.body)),
+			Linkname: entry.linkname,
+			ModTime:  testModTime,
+			Format:   tar.FormatUSTAR,
+		}
+		if entry.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			tarWriter.Write([]byte(entry.body))
+		}
+	}
+	tarWriter.Close()
+	return buffer.Bytes()
+}
+
+func writeTestArchive(t *testing.T, format ArchiveFormat, entries []testEntry) string {
+	path := filepath.Join(t.TempDir(), "agent."+string(format))
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var writer io.WriteCloser
+	switch format {
+	case FormatTar:
+		writer = file
+	case FormatTarGzip:
+		writer = gzip.NewWriter(file)
+	case FormatTarXz:
+		if writer, err = xz.NewWriter(file); err != nil {
+			t.Fatal(err)
+		}
+	case FormatTarZstd:
+		if writer, err = zstd.NewWriter(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writer.Write(buildTestTar(t, entries))
+	writer.Close()
+	return path
+}
+
+func writeTestTarball(t *testing.T, entries []testEntry) string {
+	return writeTestArchive(t, FormatTarGzip, entries)
+}
+
+func TestExtractTar(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "agent")
+	os.MkdirAll(filepath.Join(dest, "iot-credentials"), 0755)
+	ioutil.WriteFile(filepath.Join(dest, "iot-credentials", "device.pem.crt"), []byte("cert"), 0600)
+	os.MkdirAll(filepath.Join(dest, "bin"), 0755)
+	ioutil.WriteFile(filepath.Join(dest, "bin", "stale"), []byte("stale"), 0700)
+
+	tarball := writeTestTarball(t, []testEntry{
+		{name: "./", typeflag: tar.TypeDir, mode: 0755},
+		{name: "./bin/sagemaker_edge_agent_binary", typeflag: tar.TypeReg, mode: 0750, body: "agent"},
+		{name: "./lib/libprovider_aws.so.1", typeflag: tar.TypeReg, mode: 0644, body: "provider"},
+		{name: "./lib/libprovider_aws.so", typeflag: tar.TypeSymlink, linkname: "libprovider_aws.so.1"},
+		{name: "./lib/libprovider_aws_copy.so", typeflag: tar.TypeLink, linkname: "./lib/libprovider_aws.so.1"},
+		{name: "./docs", typeflag: tar.TypeDir, mode: 0700},
+	})
+
+	if err := ExtractArchive(tarball, dest, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dest, "bin", "sagemaker_edge_agent_binary"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0750 {
+		t.Fatalf("Mode should be preserved, got %s", info.Mode())
+	}
+	if !info.ModTime().Equal(testModTime) {
+		t.Fatalf("Modification time should be preserved, got %s", info.ModTime())
+	}
+
+	if target, err := os.Readlink(filepath.Join(dest, "lib", "libprovider_aws.so")); err != nil || target != "libprovider_aws.so.1" {
+		t.Fatalf("Symlink should be extracted, got %s %v", target, err)
+	}
+	if contents, err := ioutil.ReadFile(filepath.Join(dest, "lib", "libprovider_aws_copy.so")); err != nil || string(contents) != "provider" {
+		t.Fatalf("Hardlink should be extracted, got %s %v", contents, err)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "docs")); err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("Directory mode should be preserved, got %v %v", info, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, "bin", "stale")); !os.IsNotExist(err) {
+		t.Fatal("Archive entries should replace previous top level entries")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "iot-credentials", "device.pem.crt")); err != nil {
+		t.Fatal("Entries not in the archive should be kept")
+	}
+
+	siblings, _ := ioutil.ReadDir(filepath.Dir(dest))
+	if len(siblings) != 1 {
+		t.Fatalf("Staging directories should be removed, found %d entries", len(siblings))
+	}
+}
+
+func TestExtractTarRejectsEscapes(t *testing.T) {
+	testCases := map[string][]testEntry{
+		"traversal": {
+			{name: "./bin/agent", typeflag: tar.TypeReg, mode: 0700, body: "agent"},
+			{name: "../evil", typeflag: tar.TypeReg, mode: 0644, body: "evil"},
+		},
+		"absolute": {
+			{name: "/tmp/evil", typeflag: tar.TypeReg, mode: 0644, body: "evil"},
+		},
+		"symlink": {
+			{name: "./lib/escape", typeflag: tar.TypeSymlink, linkname: "../../../etc"},
+		},
+		"absolute symlink": {
+			{name: "./lib/escape", typeflag: tar.TypeSymlink, linkname: "/etc"},
+		},
+		"hardlink": {
+			{name: "./lib/escape", typeflag: tar.TypeLink, linkname: "../../etc/passwd"},
+		},
+		"symlink through symlinked parents": {
+			{name: "deep", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "deep/deep/deep/deep/deep/deep/deep/deep/deep/deep/x", typeflag: tar.TypeSymlink, linkname: "../../../../../../../../../../etc"},
+		},
+	}
+
+	for name, entries := range testCases {
+		dest := filepath.Join(t.TempDir(), "agent")
+		tarball := writeTestTarball(t, entries)
+
+		if err := ExtractArchive(tarball, dest, ExtractOptions{}); err == nil {
+			t.Fatalf("%s: extraction should fail", name)
+		}
+
+		contents, _ := ioutil.ReadDir(dest)
+		if len(contents) != 0 {
+			t.Fatalf("%s: failed extraction should leave the destination untouched", name)
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(dest), "evil")); !os.IsNotExist(err) {
+			t.Fatalf("%s: file written outside of the destination", name)
+		}
+	}
+}
+
+func TestExtractTarFollowsInternalSymlinks(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "agent")
+	tarball := writeTestTarball(t, []testEntry{
+		{name: "lib", typeflag: tar.TypeDir, mode: 0755},
+		{name: "lib64", typeflag: tar.TypeSymlink, linkname: "lib"},
+		{name: "lib64/libprovider_aws.so", typeflag: tar.TypeReg, mode: 0644, body: "provider"},
+	})
+
+	if err := ExtractArchive(tarball, dest, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "lib", "libprovider_aws.so")); err != nil {
+		t.Fatal("File should be written through the internal symlink")
+	}
+}
+
+func TestExtractTarCleansSymlinkTargets(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "agent")
+	tarball := writeTestTarball(t, []testEntry{
+		{name: "deep", typeflag: tar.TypeSymlink, linkname: "."},
+		// resolved by the kernel, deep/.. would be the parent of the destination
+		{name: "x", typeflag: tar.TypeSymlink, linkname: "deep/.."},
+	})
+
+	if err := ExtractArchive(tarball, dest, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "x")); err != nil || target != "." {
+		t.Fatalf("Symlink target should be cleaned, got %s %v", target, err)
+	}
+}
+
+func TestExtractTarMaxBytes(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "agent")
+	tarball := writeTestTarball(t, []testEntry{
+		{name: "bin/a", typeflag: tar.TypeReg, mode: 0644, body: "0123456789"},
+		{name: "bin/b", typeflag: tar.TypeReg, mode: 0644, body: "0123456789"},
+	})
+
+	err := ExtractArchive(tarball, dest, ExtractOptions{MaxBytes: 15})
+	if !errors.Is(err, ErrArchiveTooLarge) {
+		t.Fatalf("Extraction should fail with ErrArchiveTooLarge, got %v", err)
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.zip")
+	file, _ := os.Create(path)
+	zipWriter := zip.NewWriter(file)
+
+	header := &zip.FileHeader{Name: "bin/sagemaker_edge_agent_binary", Method: zip.Deflate, Modified: testModTime}
+	header.SetMode(0750)
+	writer, _ := zipWriter.CreateHeader(header)
+	writer.Write([]byte("agent"))
+
+	link := &zip.FileHeader{Name: "bin/agent", Modified: testModTime}
+	link.SetMode(os.ModeSymlink | 0777)
+	writer, _ = zipWriter.CreateHeader(link)
+	writer.Write([]byte("sagemaker_edge_agent_binary"))
+
+	zipWriter.Close()
+	file.Close()
+
+	dest := filepath.Join(t.TempDir(), "agent")
+	if err := ExtractZip(path, dest, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(filepath.Join(dest, "bin", "sagemaker_edge_agent_binary")); err != nil || info.Mode().Perm() != 0750 {
+		t.Fatalf("Zip file mode should be preserved, got %v %v", info, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "bin", "agent")); err != nil || target != "sagemaker_edge_agent_binary" {
+		t.Fatalf("Zip symlink should be extracted, got %s %v", target, err)
+	}
+}
+
+func TestExtractArchiveFormats(t *testing.T) {
+	entries := []testEntry{
+		{name: "bin/sagemaker_edge_agent_binary", typeflag: tar.TypeReg, mode: 0700, body: "agent"},
+	}
+
+	for _, format := range []ArchiveFormat{FormatTar, FormatTarGzip, FormatTarXz, FormatTarZstd} {
+		archive := writeTestArchive(t, format, entries)
+
+		detected, err := DetectArchiveFormat(archive)
+		if err != nil || detected != format {
+			t.Fatalf("Should detect %s, got %s %v", format, detected, err)
+		}
+
+		dest := filepath.Join(t.TempDir(), "agent")
+		if err := ExtractArchive(archive, dest, ExtractOptions{}); err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if contents, err := ioutil.ReadFile(filepath.Join(dest, "bin", "sagemaker_edge_agent_binary")); err != nil || string(contents) != "agent" {
+			t.Fatalf("%s: agent binary should be extracted, got %s %v", format, contents, err)
+		}
+	}
+}
+
+func TestDetectArchiveFormatUnsupported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.tgz")
+	ioutil.WriteFile(path, []byte("<html>Access Denied</html>"), 0644)
+
+	if _, err := DetectArchiveFormat(path); !errors.Is(err, ErrUnsupportedArchive) {
+		t.Fatalf("Should reject unknown content regardless of suffix, got %v", err)
+	}
+}
+
+func TestIsAgentArchive(t *testing.T) {
+	for _, name := range []string{"1.20220113.a1b2c3d.tgz", "agent.tar.zst", "agent.tar.xz", "agent.tar", "agent.zip"} {
+		if !isAgentArchive(name) {
+			t.Fatalf("%s should be recognised as agent archive", name)
+		}
+	}
+	if isAgentArchive("sha256_hex.shasum") {
+		t.Fatal("shasum files are not agent archives")
+	}
+}
@@ -0,0 +1,160 @@
+package common
+
+import (
+	"archive/tar"
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+type installScript struct {
+	DeviceName  string
+	InstallRoot string
+	Directories []string
+	Service     *options.ServiceOptions
+}
+
+var installScriptTemplate = template.Must(template.New("install").Funcs(template.FuncMap{"quote": shellQuote}).Parse(`#!/bin/sh
+# Installs the SageMaker Edge agent of device {{.DeviceName}} into {{.InstallRoot}}.
+# Set DESTDIR to install below a staging root instead of /.
+set -eu
+
+BUNDLE_DIR=$(cd "$(dirname "$0")" && pwd)
+DESTDIR=${DESTDIR:-}
+INSTALL_ROOT="$DESTDIR"{{quote .InstallRoot}}
+
+mkdir -p "$INSTALL_ROOT"
+cp -R -p "$BUNDLE_DIR/agent/." "$INSTALL_ROOT/"
+chmod 0600 "$INSTALL_ROOT/iot-credentials/private.pem.key"
+{{- range .Directories}}
+mkdir -p "$DESTDIR"{{quote .}}
+{{- end}}
+{{- with .Service}}
+
+if [ -z "$DESTDIR" ]; then
+    id -u {{quote .User}} >/dev/null 2>&1 || useradd --system --no-create-home --shell /usr/sbin/nologin {{quote .User}}
+    chown -R {{quote .User}}:{{quote .User}} "$INSTALL_ROOT"{{range $.Directories}} {{quote .}}{{end}}
+fi
+mkdir -p "$DESTDIR/etc/systemd/system"
+cp "$BUNDLE_DIR/systemd/{{.Name}}.service" "$DESTDIR/etc/systemd/system/{{.Name}}.service"
+if [ -z "$DESTDIR" ]; then
+    systemctl daemon-reload
+{{- if .Enable}}
+    systemctl enable --now {{quote .Name}}.service
+{{- end}}
+fi
+{{- end}}
+
+echo "SageMaker Edge agent installed to $INSTALL_ROOT"
+`))
+
+// shellQuote quotes value for POSIX shells.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// WriteBundle adds the install script and, when requested, the service unit next to the
+// staged agent directory bundleDirectory/agent.
+func WriteBundle(cliArgs *cli.CliArgs, bundleDirectory string) error {
+	script := installScript{
+		DeviceName:  cliArgs.DeviceName,
+		InstallRoot: cliArgs.DeviceAgentDirectory(),
+		Directories: make([]string, 0),
+	}
+	if cliArgs.EnableDB {
+		script.Directories = append(script.Directories, filepath.Join(cliArgs.DeviceAgentDirectory(), "local_data"))
+	}
+	if cliArgs.Capture.Destination == constants.CAPTURE_DESTINATION_DISK {
+		script.Directories = append(script.Directories, cliArgs.Capture.DiskPath)
+	}
+
+	if cliArgs.Service.Install {
+		script.Service = &cliArgs.Service
+		unit, err := RenderServiceUnit(cliArgs)
+		if err != nil {
+			return err
+		}
+		unitPath := filepath.Join(bundleDirectory, "systemd", cliArgs.Service.Name+".service")
+		if err := WriteFileAtomic(unitPath, []byte(unit), 0644); err != nil {
+			return err
+		}
+	}
+
+	var builder strings.Builder
+	if err := installScriptTemplate.Execute(&builder, script); err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(bundleDirectory, "install.sh"), []byte(builder.String()), 0755)
+}
+
+// CreateTarball packs the contents of directory into a gzipped tarball below topLevel.
+// The tarball holds the device private key and is only readable by its owner.
+func CreateTarball(directory string, output string, topLevel string) error {
+	file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	err = filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(topLevel, relative))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		// ownership is set by the install script on the device
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		source, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		_, err = io.Copy(tarWriter, source)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
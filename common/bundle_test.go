@@ -0,0 +1,91 @@
+package common
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundleInstall(t *testing.T) {
+	staging := t.TempDir()
+	agentDirectory := filepath.Join(staging, "agent")
+	os.MkdirAll(filepath.Join(agentDirectory, "bin"), 0755)
+	os.MkdirAll(filepath.Join(agentDirectory, "iot-credentials"), 0755)
+	ioutil.WriteFile(filepath.Join(agentDirectory, "bin", "sagemaker_edge_agent_binary"), []byte("agent"), 0700)
+	ioutil.WriteFile(filepath.Join(agentDirectory, "iot-credentials", "private.pem.key"), []byte("key"), 0644)
+	os.Symlink("sagemaker_edge_agent_binary", filepath.Join(agentDirectory, "bin", "agent"))
+
+	cliArgs := &cli.CliArgs{
+		DeviceName:        "some-device",
+		AgentDirectory:    agentDirectory,
+		DeviceInstallRoot: "/opt/sagemaker edge",
+		EnableDB:          true,
+		Capture:           options.CaptureOptions{Destination: constants.CAPTURE_DESTINATION_DISK, DiskPath: "/data/capture"},
+		Service: options.ServiceOptions{
+			Install: true,
+			Enable:  true,
+			Name:    "sagemaker-edge-agent",
+			User:    "sagemaker-edge",
+			Socket:  "/run/sagemaker-edge-agent/sagemaker_edge_agent.sock",
+		},
+	}
+
+	if err := WriteBundle(cliArgs, staging); err != nil {
+		t.Fatal(err)
+	}
+	tarball := filepath.Join(t.TempDir(), "some-device-bundle.tar.gz")
+	if err := CreateTarball(staging, tarball, "sagemaker-edge-some-device"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(tarball); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Bundle should only be readable by its owner, got %v %v", info, err)
+	}
+
+	unpacked := t.TempDir()
+	if err := ExtractArchive(tarball, unpacked, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	destdir := t.TempDir()
+	install := exec.Command("sh", filepath.Join(unpacked, "sagemaker-edge-some-device", "install.sh"))
+	install.Env = append(os.Environ(), "DESTDIR="+destdir)
+	if output, err := install.CombinedOutput(); err != nil {
+		t.Fatalf("Install script failed: %s\n%s", err, output)
+	}
+
+	installRoot := filepath.Join(destdir, "opt", "sagemaker edge")
+	if info, err := os.Stat(filepath.Join(installRoot, "bin", "sagemaker_edge_agent_binary")); err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("Agent binary should be installed with its mode, got %v %v", info, err)
+	}
+	if target, err := os.Readlink(filepath.Join(installRoot, "bin", "agent")); err != nil || target != "sagemaker_edge_agent_binary" {
+		t.Fatalf("Symlinks should be kept, got %s %v", target, err)
+	}
+	if info, err := os.Stat(filepath.Join(installRoot, "iot-credentials", "private.pem.key")); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Private key should be restricted, got %v %v", info, err)
+	}
+	for _, directory := range []string{filepath.Join(installRoot, "local_data"), filepath.Join(destdir, "data", "capture")} {
+		if _, err := os.Stat(directory); err != nil {
+			t.Fatalf("%s should be created", directory)
+		}
+	}
+
+	unit, err := ioutil.ReadFile(filepath.Join(destdir, "etc", "systemd", "system", "sagemaker-edge-agent.service"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(unit), `ExecStart="/opt/sagemaker edge/bin/sagemaker_edge_agent_binary"`) {
+		t.Fatalf("Unit should use device side paths, got\n%s", unit)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if quoted := shellQuote("it's"); quoted != `'it'\''s'` {
+		t.Fatalf("Unexpected quoting %s", quoted)
+	}
+}
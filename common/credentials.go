@@ -0,0 +1,104 @@
+package common
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// IotCredentials are the temporary credentials issued by the IoT credential provider
+// for the role alias of the device fleet.
+type IotCredentials struct {
+	AccessKeyId     string    `json:"accessKeyId"`
+	SecretAccessKey string    `json:"secretAccessKey"`
+	SessionToken    string    `json:"sessionToken"`
+	Expiration      time.Time `json:"expiration"`
+}
+
+// LoadAgentConfig reads a generated sagemaker_edge_config.json.
+func LoadAgentConfig(path string) (*AgentConfig, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &AgentConfig{}
+	if err := json.Unmarshal(contents, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// NewDeviceHTTPClient returns a client authenticating with the device certificate and
+// trusting only the root CA configured for the agent, as the agent itself does.
+func NewDeviceHTTPClient(config *AgentConfig, opts *options.HttpOptions) (*http.Client, error) {
+	certificate, err := tls.LoadX509KeyPair(config.AwsCertFile, config.AwsCertPKFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load device certificate: %w", err)
+	}
+	rootCA, err := ioutil.ReadFile(config.AwsCaCertFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(rootCA) {
+		return nil, fmt.Errorf("no certificates found in %s", config.AwsCaCertFile)
+	}
+
+	client, err := NewHTTPClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig = &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	client.Timeout = 30 * time.Second
+	return client, nil
+}
+
+// FetchIotCredentials exchanges the device certificate for temporary credentials at the
+// credential provider endpoint of the agent config.
+func FetchIotCredentials(ctx context.Context, client *http.Client, config *AgentConfig) (*IotCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.ProviderAwsIotCredEndpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential provider endpoint %s: %w", config.ProviderAwsIotCredEndpoint, err)
+	}
+	req.Header.Set("x-amzn-iot-thingname", config.IotThingName)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// the provider explains missing role aliases or policies in the body
+		return nil, fmt.Errorf("credential provider returned %s: %s", resp.Status, body)
+	}
+
+	var response struct {
+		Credentials IotCredentials `json:"credentials"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse credential provider response: %w", err)
+	}
+	credentials := &response.Credentials
+	if credentials.AccessKeyId == "" || credentials.SecretAccessKey == "" || credentials.SessionToken == "" {
+		return nil, errors.New("credential provider response is missing credentials")
+	}
+	return credentials, nil
+}
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeDeviceCredentials writes a device certificate and key as CreateKeysAndCertificate
+// would and the server certificate as root CA, returning the matching agent config.
+func writeDeviceCredentials(t *testing.T, server *httptest.Server) (*AgentConfig, *x509.Certificate) {
+	directory := t.TempDir()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	certificatePem := selfSignedPem(t, key)
+	keyDer, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	config := &AgentConfig{
+		IotThingName:               "Sagemaker_some-device",
+		AwsCertFile:                filepath.Join(directory, "device.pem.crt"),
+		AwsCertPKFile:              filepath.Join(directory, "private.pem.key"),
+		AwsCaCertFile:              filepath.Join(directory, "AmazonRootCA1.pem"),
+		ProviderAwsIotCredEndpoint: server.URL + "/role-aliases/some-alias/credentials",
+	}
+	ioutil.WriteFile(config.AwsCertFile, []byte(certificatePem), 0600)
+	ioutil.WriteFile(config.AwsCertPKFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600)
+	ioutil.WriteFile(config.AwsCaCertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+
+	block, _ := pem.Decode([]byte(certificatePem))
+	certificate, _ := x509.ParseCertificate(block.Bytes)
+	return config, certificate
+}
+
+func newCredentialProvider(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchIotCredentials(t *testing.T) {
+	var deviceCertificate *x509.Certificate
+	server := newCredentialProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) != 1 || !r.TLS.PeerCertificates[0].Equal(deviceCertificate) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/role-aliases/some-alias/credentials" || r.Header.Get("x-amzn-iot-thingname") != "Sagemaker_some-device" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"credentials":{"accessKeyId":"ASIAEXAMPLE","secretAccessKey":"secret","sessionToken":"token","expiration":"2022-08-01T12:00:00Z"}}`))
+	})
+
+	config, certificate := writeDeviceCredentials(t, server)
+	deviceCertificate = certificate
+
+	client, err := NewDeviceHTTPClient(config, &options.HttpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials, err := FetchIotCredentials(context.Background(), client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.AccessKeyId != "ASIAEXAMPLE" || credentials.SessionToken != "token" || credentials.Expiration.Year() != 2022 {
+		t.Fatalf("Unexpected credentials %+v", credentials)
+	}
+}
+
+func TestFetchIotCredentialsReportsProviderErrors(t *testing.T) {
+	server := newCredentialProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message":"Access Denied"}`))
+	})
+	config, _ := writeDeviceCredentials(t, server)
+
+	client, _ := NewDeviceHTTPClient(config, &options.HttpOptions{})
+	_, err := FetchIotCredentials(context.Background(), client, config)
+	if err == nil || !strings.Contains(err.Error(), "Access Denied") {
+		t.Fatalf("Provider error should be reported, got %v", err)
+	}
+}
+
+func TestFetchIotCredentialsRejectsUnknownServer(t *testing.T) {
+	server := newCredentialProvider(t, func(w http.ResponseWriter, r *http.Request) {})
+	config, _ := writeDeviceCredentials(t, server)
+
+	other := newCredentialProvider(t, func(w http.ResponseWriter, r *http.Request) {})
+	config.ProviderAwsIotCredEndpoint = other.URL
+
+	client, _ := NewDeviceHTTPClient(config, &options.HttpOptions{})
+	if _, err := FetchIotCredentials(context.Background(), client, config); err == nil {
+		t.Fatal("Server not signed by the configured root CA should be rejected")
+	}
+}
@@ -0,0 +1,191 @@
+package common
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NewHTTPClient builds the HTTP client shared by the AWS SDK clients and plain downloads.
+// Without an explicit proxy the standard proxy environment variables apply.
+func NewHTTPClient(opts *options.HttpOptions) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if opts.Proxy != "" {
+		proxyUrl, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url %s: %w", opts.Proxy, err)
+		}
+		if proxyUrl.Scheme == "" || proxyUrl.Host == "" {
+			return nil, fmt.Errorf("invalid proxy url %s: scheme and host are required", opts.Proxy)
+		}
+		transport.Proxy = proxyFunc(proxyUrl, opts.NoProxy)
+	}
+
+	if opts.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		bundle, err := ioutil.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", opts.CABundle)
+		}
+		// the same roots verify TLS proxies and the endpoints behind them
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// proxyFunc routes every request through proxyUrl unless the target host matches noProxy,
+// a comma separated list of host names, domain suffixes (".example.com"), IPs, CIDRs or "*".
+func proxyFunc(proxyUrl *url.URL, noProxy string) func(*http.Request) (*url.URL, error) {
+	patterns := make([]string, 0)
+	for _, pattern := range strings.Split(noProxy, ",") {
+		if pattern = strings.ToLower(strings.TrimSpace(pattern)); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL, patterns) {
+			return nil, nil
+		}
+		return proxyUrl, nil
+	}
+}
+
+func bypassProxy(target *url.URL, patterns []string) bool {
+	host := strings.ToLower(target.Hostname())
+	port := target.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[target.Scheme]
+	}
+	ip := net.ParseIP(host)
+
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(pattern); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		patternHost, patternPort := pattern, ""
+		if h, p, err := net.SplitHostPort(pattern); err == nil {
+			patternHost, patternPort = h, p
+		}
+		if patternPort != "" && patternPort != port {
+			continue
+		}
+
+		patternHost = strings.TrimPrefix(patternHost, "*")
+		if strings.HasPrefix(patternHost, ".") {
+			if strings.HasSuffix(host, patternHost) || host == patternHost[1:] {
+				return true
+			}
+		} else if host == patternHost || strings.HasSuffix(host, "."+patternHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// systemCABundles are the CA bundles of common distributions, as searched by Go's
+// crypto/x509 on Linux.
+var systemCABundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// AgentCABundleName is the bundle of system roots and -caBundle written for the agent.
+const AgentCABundleName = "sagemaker_edge_ca_bundle.pem"
+
+// writeCombinedCABundle writes the system roots followed by the certificates of caBundle.
+// SSL_CERT_FILE replaces the system roots of the agent, which still has to reach
+// AWS endpoints that are not behind the proxy.
+func writeCombinedCABundle(path string, caBundle string) error {
+	files := systemCABundles
+	if file := os.Getenv("SSL_CERT_FILE"); file != "" {
+		files = []string{file}
+	}
+	var system []byte
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err == nil {
+			system = contents
+			break
+		}
+	}
+	if system == nil {
+		return fmt.Errorf("no system CA bundle found in %s", strings.Join(files, ", "))
+	}
+
+	additional, err := ioutil.ReadFile(caBundle)
+	if err != nil {
+		return err
+	}
+	combined := append(system, '\n')
+	return WriteFileAtomic(path, append(combined, additional...), 0644)
+}
+
+// WriteAgentEnvironment writes the proxy and CA bundle settings as environment variables.
+// The agent configuration has no proxy keys, the agent's HTTP client honours these
+// variables instead, e.g. through the EnvironmentFile of its service unit. A CA bundle
+// is combined with the system roots next to the environment file.
+// Files left by an earlier run are removed when no proxy or CA bundle is configured.
+func WriteAgentEnvironment(path string, opts *options.HttpOptions) error {
+	caBundlePath := filepath.Join(filepath.Dir(path), AgentCABundleName)
+	if opts.CABundle == "" {
+		if err := removeIfExists(caBundlePath); err != nil {
+			return err
+		}
+	}
+	if opts.Proxy == "" && opts.CABundle == "" {
+		return removeIfExists(path)
+	}
+
+	var builder strings.Builder
+	if opts.Proxy != "" {
+		fmt.Fprintf(&builder, "HTTPS_PROXY=%s\nHTTP_PROXY=%s\n", opts.Proxy, opts.Proxy)
+		fmt.Fprintf(&builder, "https_proxy=%s\nhttp_proxy=%s\n", opts.Proxy, opts.Proxy)
+		if opts.NoProxy != "" {
+			fmt.Fprintf(&builder, "NO_PROXY=%s\nno_proxy=%s\n", opts.NoProxy, opts.NoProxy)
+		}
+	}
+	if opts.CABundle != "" {
+		if err := writeCombinedCABundle(caBundlePath, opts.CABundle); err != nil {
+			return fmt.Errorf("failed to write CA bundle %s: %w", caBundlePath, err)
+		}
+		fmt.Fprintf(&builder, "SSL_CERT_FILE=%s\nCURL_CA_BUNDLE=%s\n", caBundlePath, caBundlePath)
+	}
+
+	// proxy urls may carry credentials
+	return WriteFileAtomic(path, []byte(builder.String()), 0600)
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
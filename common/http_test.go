@@ -0,0 +1,144 @@
+package common
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBypassProxy(t *testing.T) {
+	patterns := []string{"localhost", ".internal.example.com", "10.0.0.0/8", "s3.us-west-2.amazonaws.com:8443"}
+
+	testCases := map[string]bool{
+		"https://localhost/":                         true,
+		"https://minio.internal.example.com/":        true,
+		"https://internal.example.com/":              true,
+		"https://10.1.2.3/":                          true,
+		"https://s3.us-west-2.amazonaws.com:8443/":   true,
+		"https://s3.us-west-2.amazonaws.com/":        false,
+		"https://iot.us-west-2.amazonaws.com/":       false,
+		"https://11.1.2.3/":                          false,
+		"https://notinternal.example.com.evil.test/": false,
+	}
+
+	for rawUrl, expected := range testCases {
+		target, _ := url.Parse(rawUrl)
+		if bypassProxy(target, patterns) != expected {
+			t.Fatalf("Bypass for %s should be %t", rawUrl, expected)
+		}
+	}
+
+	wildcard, _ := url.Parse("https://iot.us-west-2.amazonaws.com/")
+	if !bypassProxy(wildcard, []string{"*"}) {
+		t.Fatal("* should bypass every host")
+	}
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+
+	client, err := NewHTTPClient(&options.HttpOptions{Proxy: proxy.URL, NoProxy: "bypass.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Get("http://www.amazontrust.com/repository/AmazonRootCA1.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if proxied != "http://www.amazontrust.com/repository/AmazonRootCA1.pem" {
+		t.Fatalf("Request should be sent through the proxy, got %s", proxied)
+	}
+
+	if _, err := NewHTTPClient(&options.HttpOptions{Proxy: "proxy:3128"}); err == nil {
+		t.Fatal("Proxy url without scheme should be rejected")
+	}
+}
+
+func TestNewHTTPClientCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	plain, _ := NewHTTPClient(&options.HttpOptions{})
+	if _, err := plain.Get(server.URL); err == nil {
+		t.Fatal("Untrusted server certificate should be rejected")
+	}
+
+	bundle := filepath.Join(t.TempDir(), "bundle.pem")
+	ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+
+	client, err := NewHTTPClient(&options.HttpOptions{CABundle: bundle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestWriteAgentEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sagemaker_edge_agent.env")
+
+	if err := WriteAgentEnvironment(path, &options.HttpOptions{Proxy: "http://proxy:3128", NoProxy: "localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	contents, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(contents), "HTTPS_PROXY=http://proxy:3128\n") || !strings.Contains(string(contents), "NO_PROXY=localhost\n") {
+		t.Fatalf("Unexpected environment file %s", contents)
+	}
+
+	if err := WriteAgentEnvironment(path, &options.HttpOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadFile(path); err == nil {
+		t.Fatal("Environment file should be removed without proxy settings")
+	}
+}
+
+func TestWriteAgentEnvironmentCABundle(t *testing.T) {
+	dir := t.TempDir()
+	systemBundle := filepath.Join(dir, "ca-certificates.crt")
+	ioutil.WriteFile(systemBundle, []byte("system roots"), 0644)
+	proxyBundle := filepath.Join(dir, "proxy.pem")
+	ioutil.WriteFile(proxyBundle, []byte("proxy ca"), 0644)
+	os.Setenv("SSL_CERT_FILE", systemBundle)
+	defer os.Unsetenv("SSL_CERT_FILE")
+
+	path := filepath.Join(dir, "agent", "sagemaker_edge_agent.env")
+	if err := WriteAgentEnvironment(path, &options.HttpOptions{CABundle: proxyBundle}); err != nil {
+		t.Fatal(err)
+	}
+	combinedPath := filepath.Join(dir, "agent", AgentCABundleName)
+	contents, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(contents), "SSL_CERT_FILE="+combinedPath+"\n") {
+		t.Fatalf("The agent should use the combined bundle, got %s", contents)
+	}
+	if combined, _ := ioutil.ReadFile(combinedPath); string(combined) != "system roots\nproxy ca" {
+		t.Fatalf("The bundle should keep the system roots, got %q", combined)
+	}
+
+	if err := WriteAgentEnvironment(path, &options.HttpOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(combinedPath); !os.IsNotExist(err) {
+		t.Fatal("Combined bundle should be removed without a CA bundle")
+	}
+}
@@ -0,0 +1,231 @@
+package common
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// NetworkEndpoint is an HTTPS endpoint the setup or the agent connects to.
+type NetworkEndpoint struct {
+	Name string
+	URL  string
+	// Certificates are presented as client certificates, e.g. to the credential provider
+	Certificates []tls.Certificate
+}
+
+// NetworkResult holds the outcome of every stage of an endpoint check. A nil error means
+// the stage passed, stages after a failed one are not run.
+type NetworkResult struct {
+	Endpoint NetworkEndpoint
+	Host     string
+	// Proxy is the redacted proxy url, empty for direct connections
+	Proxy      string
+	Addresses  []string
+	DNSError   error
+	TCPError   error
+	ProxyError error
+	TLSError   error
+	TLSVersion string
+	Duration   time.Duration
+}
+
+// Err returns the error of the first failed stage.
+func (result *NetworkResult) Err() error {
+	for _, err := range []error{result.DNSError, result.TCPError, result.ProxyError, result.TLSError} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NetworkChecker resolves, connects and performs TLS handshakes with endpoints the same
+// way the shared HTTP client does, including its proxy and CA bundle settings.
+type NetworkChecker struct {
+	Resolver  *net.Resolver
+	TLSConfig *tls.Config
+	Proxy     func(*http.Request) (*url.URL, error)
+	Timeout   time.Duration
+}
+
+// NewNetworkChecker builds a checker with the proxy and TLS settings of opts.
+func NewNetworkChecker(opts *options.HttpOptions, timeout time.Duration) (*NetworkChecker, error) {
+	client, err := NewHTTPClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	transport := client.Transport.(*http.Transport)
+	tlsConfig := transport.TLSClientConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return &NetworkChecker{Resolver: net.DefaultResolver, TLSConfig: tlsConfig, Proxy: transport.Proxy, Timeout: timeout}, nil
+}
+
+// Check runs the DNS, TCP, proxy and TLS stages for endpoint. Through a proxy the DNS and
+// TCP stages apply to the proxy, which resolves the endpoint itself.
+func (checker *NetworkChecker) Check(ctx context.Context, endpoint NetworkEndpoint) *NetworkResult {
+	start := time.Now()
+	result := &NetworkResult{Endpoint: endpoint}
+	defer func() { result.Duration = time.Since(start) }()
+
+	target, err := url.Parse(endpoint.URL)
+	if err != nil {
+		result.DNSError = err
+		return result
+	}
+	result.Host = target.Hostname()
+	targetAddress := hostPort(target)
+
+	var proxyUrl *url.URL
+	if checker.Proxy != nil {
+		proxyUrl, err = checker.Proxy(&http.Request{Method: http.MethodConnect, URL: target, Header: make(http.Header)})
+		if err != nil {
+			result.ProxyError = err
+			return result
+		}
+	}
+	dialUrl := target
+	if proxyUrl != nil {
+		dialUrl = proxyUrl
+		result.Proxy = proxyUrl.Redacted()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checker.Timeout)
+	defer cancel()
+
+	result.Addresses, result.DNSError = checker.Resolver.LookupHost(ctx, dialUrl.Hostname())
+	if result.DNSError != nil {
+		return result
+	}
+
+	_, dialPort, _ := net.SplitHostPort(hostPort(dialUrl))
+	var conn net.Conn
+	dialer := &net.Dialer{}
+	for _, address := range result.Addresses {
+		conn, result.TCPError = dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, dialPort))
+		if result.TCPError == nil {
+			break
+		}
+	}
+	if result.TCPError != nil {
+		return result
+	}
+	defer func() { conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if proxyUrl != nil {
+		if conn, result.ProxyError = checker.connect(conn, proxyUrl, targetAddress); result.ProxyError != nil {
+			return result
+		}
+	}
+
+	tlsConfig := checker.TLSConfig.Clone()
+	tlsConfig.ServerName = target.Hostname()
+	tlsConfig.Certificates = endpoint.Certificates
+	tlsConn := tls.Client(conn, tlsConfig)
+	if result.TLSError = tlsConn.Handshake(); result.TLSError != nil {
+		return result
+	}
+	conn = tlsConn
+	result.TLSVersion = tlsVersionName(tlsConn.ConnectionState().Version)
+	return result
+}
+
+// connect opens a tunnel to targetAddress through the proxy connected on conn.
+func (checker *NetworkChecker) connect(conn net.Conn, proxyUrl *url.URL, targetAddress string) (net.Conn, error) {
+	if proxyUrl.Scheme == "https" {
+		tlsConfig := checker.TLSConfig.Clone()
+		tlsConfig.ServerName = proxyUrl.Hostname()
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return conn, fmt.Errorf("TLS handshake with proxy failed: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: targetAddress},
+		Host:   targetAddress,
+		Header: make(http.Header),
+	}
+	if proxyUrl.User != nil {
+		password, _ := proxyUrl.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyUrl.User.Username() + ":" + password))
+		request.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := request.Write(conn); err != nil {
+		return conn, err
+	}
+
+	response, err := http.ReadResponse(bufio.NewReader(conn), request)
+	if err != nil {
+		return conn, fmt.Errorf("no response from proxy: %w", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("proxy refused CONNECT %s: %s", targetAddress, response.Status)
+	}
+	return conn, nil
+}
+
+func hostPort(target *url.URL) string {
+	port := target.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[target.Scheme]
+	}
+	return net.JoinHostPort(target.Hostname(), port)
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// NetworkEndpoints lists the endpoints used by the setup and by the agent of a device
+// in region. Empty fleetBucket or credentialEndpoint skip the corresponding endpoint.
+func NetworkEndpoints(cliArgs *cli.CliArgs, region string, fleetBucket string, credentialEndpoint string) []NetworkEndpoint {
+	endpoints := []NetworkEndpoint{
+		{Name: "IoT", URL: fmt.Sprintf("https://iot.%s.amazonaws.com/", region)},
+		{Name: "SageMaker", URL: fmt.Sprintf("https://api.sagemaker.%s.amazonaws.com/", region)},
+		{Name: "SageMaker Edge", URL: fmt.Sprintf("https://edge.sagemaker.%s.amazonaws.com/", region)},
+	}
+	if credentialEndpoint != "" {
+		endpoints = append(endpoints, NetworkEndpoint{Name: "IoT Credential Provider", URL: credentialEndpoint})
+	}
+	if fleetBucket != "" {
+		endpoints = append(endpoints, NetworkEndpoint{Name: "Fleet Bucket", URL: fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", fleetBucket, region)})
+	}
+
+	releaseBucket := agentReleaseBucket(cliArgs)
+	releaseUrl := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", releaseBucket, cliArgs.ReleaseStore.Region)
+	if cliArgs.ReleaseStore.Endpoint != "" {
+		releaseUrl = cliArgs.ReleaseStore.Endpoint
+		if endpoint, err := url.Parse(cliArgs.ReleaseStore.Endpoint); err == nil && !cliArgs.ReleaseStore.UsePathStyle {
+			endpoint.Host = releaseBucket + "." + endpoint.Host
+			releaseUrl = endpoint.String()
+		}
+	}
+	return append(endpoints, NetworkEndpoint{Name: "Release Store", URL: releaseUrl})
+}
@@ -0,0 +1,180 @@
+package common
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestChecker(server *httptest.Server) *NetworkChecker {
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	return &NetworkChecker{Resolver: net.DefaultResolver, TLSConfig: &tls.Config{RootCAs: pool}, Timeout: 5 * time.Second}
+}
+
+// newConnectProxy is a stand-in proxy tunnelling CONNECT requests to their target.
+func newConnectProxy(t *testing.T, status int) (*httptest.Server, *int) {
+	connects := 0
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		connects++
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, buffered, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			io.Copy(upstream, buffered)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	return proxy, &connects
+}
+
+func TestNetworkCheckDirect(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	result := newTestChecker(server).Check(context.Background(), NetworkEndpoint{Name: "test", URL: server.URL})
+	if err := result.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if result.Host != "127.0.0.1" || result.Proxy != "" || result.TLSVersion == "" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+}
+
+func TestNetworkCheckUntrustedCertificate(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	checker := newTestChecker(server)
+	checker.TLSConfig = &tls.Config{RootCAs: x509.NewCertPool()}
+	result := checker.Check(context.Background(), NetworkEndpoint{Name: "test", URL: server.URL})
+	if result.DNSError != nil || result.TCPError != nil || result.TLSError == nil {
+		t.Errorf("Expected only TLS to fail, got %+v", result)
+	}
+}
+
+func TestNetworkCheckConnectionRefused(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	checker := newTestChecker(server)
+	server.Close()
+
+	result := checker.Check(context.Background(), NetworkEndpoint{Name: "test", URL: server.URL})
+	if result.DNSError != nil || result.TCPError == nil {
+		t.Errorf("Expected TCP to fail, got %+v", result)
+	}
+}
+
+func TestNetworkCheckDNSFailure(t *testing.T) {
+	checker := &NetworkChecker{
+		Resolver: &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			return nil, errors.New("no name server")
+		}},
+		TLSConfig: &tls.Config{},
+		Timeout:   time.Second,
+	}
+
+	result := checker.Check(context.Background(), NetworkEndpoint{Name: "test", URL: "https://iot.us-west-2.amazonaws.com/"})
+	if result.DNSError == nil || result.TCPError != nil {
+		t.Errorf("Expected DNS to fail, got %+v", result)
+	}
+}
+
+func TestNetworkCheckProxy(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	proxy, connects := newConnectProxy(t, http.StatusOK)
+	defer proxy.Close()
+
+	checker := newTestChecker(server)
+	proxyUrl, _ := url.Parse(proxy.URL)
+	proxyUrl.User = url.UserPassword("user", "secret")
+	checker.Proxy = http.ProxyURL(proxyUrl)
+
+	result := checker.Check(context.Background(), NetworkEndpoint{Name: "test", URL: server.URL})
+	if err := result.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if *connects != 1 {
+		t.Errorf("Expected one CONNECT, got %d", *connects)
+	}
+	if result.Proxy != proxyUrl.Redacted() {
+		t.Errorf("Expected redacted proxy %s, got %s", proxyUrl.Redacted(), result.Proxy)
+	}
+}
+
+func TestNetworkCheckProxyRefused(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	proxy, _ := newConnectProxy(t, http.StatusForbidden)
+	defer proxy.Close()
+
+	checker := newTestChecker(server)
+	proxyUrl, _ := url.Parse(proxy.URL)
+	checker.Proxy = http.ProxyURL(proxyUrl)
+
+	result := checker.Check(context.Background(), NetworkEndpoint{Name: "test", URL: server.URL})
+	if result.TCPError != nil || result.ProxyError == nil || result.TLSError != nil {
+		t.Errorf("Expected the proxy to refuse, got %+v", result)
+	}
+}
+
+func TestNetworkEndpoints(t *testing.T) {
+	cliArgs := &cli.CliArgs{
+		TargetPlatform: options.TargetPlatform{Os: "linux", Arch: "armv8"},
+		ReleaseStore: options.ReleaseStore{
+			BucketTemplate: "edge-releases-{os}-{arch}",
+			Region:         "us-east-1",
+			Endpoint:       "https://minio.example.com:9000",
+		},
+	}
+
+	endpoints := NetworkEndpoints(cliArgs, "eu-west-1", "fleet-bucket", "https://abc.credentials.iot.eu-west-1.amazonaws.com/role-aliases/x/credentials")
+	urls := make(map[string]string)
+	for _, endpoint := range endpoints {
+		urls[endpoint.Name] = endpoint.URL
+	}
+	expected := map[string]string{
+		"SageMaker Edge": "https://edge.sagemaker.eu-west-1.amazonaws.com/",
+		"Fleet Bucket":   "https://fleet-bucket.s3.eu-west-1.amazonaws.com/",
+		"Release Store":  "https://edge-releases-linux-armv8.minio.example.com:9000",
+	}
+	for name, url := range expected {
+		if urls[name] != url {
+			t.Errorf("Expected %s for %s, got %s", url, name, urls[name])
+		}
+	}
+
+	cliArgs.ReleaseStore.UsePathStyle = true
+	endpoints = NetworkEndpoints(cliArgs, "eu-west-1", "", "")
+	if len(endpoints) != 4 || endpoints[3].URL != "https://minio.example.com:9000" {
+		t.Errorf("Unexpected endpoints %+v", endpoints)
+	}
+}
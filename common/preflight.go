@@ -0,0 +1,237 @@
+package common
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"context"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxClockSkew is the largest accepted difference between the device clock and AWS.
+// Certificates issued during setup are not yet valid on devices running behind.
+const MaxClockSkew = time.Minute
+
+// agentSpaceFactor estimates the space of the extracted agent from its archive size.
+const agentSpaceFactor = 4
+
+// errPreflightUnsupported is returned by checks not available on the running os.
+var errPreflightUnsupported = errors.New("not supported on this os")
+
+// hostMachine returns the kernel architecture, replaced in tests.
+var hostMachine = unameMachine
+
+// hostGlibcVersion returns the glibc version of the system, replaced in tests.
+var hostGlibcVersion = func() (string, error) {
+	output, err := exec.Command("getconf", "GNU_LIBC_VERSION").Output()
+	if err != nil {
+		return "", fmt.Errorf("no glibc found: %w", err)
+	}
+	// e.g. "glibc 2.31"
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 || fields[0] != "glibc" {
+		return "", fmt.Errorf("unexpected glibc version %s", strings.TrimSpace(string(output)))
+	}
+	return fields[1], nil
+}
+
+// DevicePreflight checks the machine the agent is installed on before any resource is
+// created. All failed checks are returned together.
+func DevicePreflight(ctx context.Context, httpClient *http.Client, cliArgs *cli.CliArgs, archiveSize int64) error {
+	problems := make([]string, 0)
+	check := func(err error) {
+		if errors.Is(err, errPreflightUnsupported) {
+			return
+		}
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	writableErr := CheckWritable(cliArgs.AgentDirectory)
+	check(writableErr)
+	if writableErr == nil {
+		check(CheckDiskSpace(cliArgs.AgentDirectory, uint64(archiveSize)*agentSpaceFactor))
+	}
+	check(CheckDiskSpace(cliArgs.CacheDirectory, uint64(archiveSize)))
+	check(CheckHostArch(&cliArgs.TargetPlatform))
+	check(CheckClockSkew(ctx, httpClient, fmt.Sprintf("https://sts.%s.amazonaws.com/", cliArgs.Region), MaxClockSkew))
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// CheckWritable creates directory if needed and checks that files can be written to it.
+func CheckWritable(directory string) error {
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return fmt.Errorf("cannot create agent directory %s: %w. Choose another -agentDirectory or run with sufficient privileges", directory, err)
+	}
+	file, err := ioutil.TempFile(directory, ".preflight-")
+	if err != nil {
+		return fmt.Errorf("agent directory %s is not writable: %w. Choose another -agentDirectory or run with sufficient privileges", directory, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// CheckDiskSpace checks that the file system of path, or of its nearest existing
+// parent, has required bytes available.
+func CheckDiskSpace(path string, required uint64) error {
+	for {
+		if _, err := os.Stat(path); err == nil || filepath.Dir(path) == path {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+	available, err := freeDiskSpace(path)
+	if err != nil {
+		return err
+	}
+	if available < required {
+		return fmt.Errorf("%s has %d MiB available, %d MiB are required. Free up space or choose another directory", path, available>>20, (required+1<<20-1)>>20)
+	}
+	return nil
+}
+
+// CheckHostArch checks that the kernel executes binaries of the target architecture.
+func CheckHostArch(tp *options.TargetPlatform) error {
+	machine, err := hostMachine()
+	if err != nil {
+		return err
+	}
+	host, err := options.LookupPlatform(tp.Os, machine)
+	if err != nil {
+		return fmt.Errorf("host architecture %s is not supported: %w", machine, err)
+	}
+	if !host.CanRun(tp.ReleaseArch()) {
+		return fmt.Errorf("the %s agent does not run on this %s host. Set -arch to the device architecture or run the setup on the device", tp.ReleaseArch(), machine)
+	}
+	return nil
+}
+
+// CheckClockSkew compares the local clock with the Date header of url.
+func CheckClockSkew(ctx context.Context, client *http.Client, url string, maxSkew time.Duration) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to read the time from %s: %w", url, err)
+	}
+	resp.Body.Close()
+
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("no valid Date header from %s: %w", url, err)
+	}
+	skew := time.Since(serverTime)
+	if skew < 0 {
+		skew = -skew
+	}
+	// the Date header has a resolution of one second
+	if skew > maxSkew+time.Second {
+		return fmt.Errorf("the system clock is off by %s (%s, AWS %s). Synchronize it, e.g. with NTP, before creating certificates", skew.Round(time.Second), time.Now().UTC().Format(time.RFC3339), serverTime.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// RequiredGlibcVersion returns the highest GLIBC symbol version binary links against,
+// empty for binaries without glibc dependencies.
+func RequiredGlibcVersion(binary string) (string, error) {
+	file, err := elf.Open(binary)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	symbols, err := file.ImportedSymbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return "", err
+	}
+	required := ""
+	for _, symbol := range symbols {
+		if !strings.HasPrefix(symbol.Version, "GLIBC_") {
+			continue
+		}
+		version := strings.TrimPrefix(symbol.Version, "GLIBC_")
+		if _, err := parseVersion(version); err != nil {
+			// e.g. GLIBC_PRIVATE
+			continue
+		}
+		if required == "" || compareVersions(version, required) > 0 {
+			required = version
+		}
+	}
+	return required, nil
+}
+
+// CheckGlibc checks that the system glibc provides the symbol versions of binary.
+func CheckGlibc(binary string) error {
+	required, err := RequiredGlibcVersion(binary)
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", binary, err)
+	}
+	if required == "" {
+		return nil
+	}
+	available, err := hostGlibcVersion()
+	if err != nil {
+		return fmt.Errorf("%s requires glibc %s: %w", filepath.Base(binary), required, err)
+	}
+	if compareVersions(available, required) < 0 {
+		return fmt.Errorf("%s requires glibc %s, the system has glibc %s. Upgrade the operating system of the device", filepath.Base(binary), required, available)
+	}
+	return nil
+}
+
+// CheckAgentBinary checks the glibc compatibility of the agent extracted below directory.
+func CheckAgentBinary(directory string) error {
+	return CheckGlibc(filepath.Join(directory, "bin", "sagemaker_edge_agent_binary"))
+}
+
+func parseVersion(version string) ([]int, error) {
+	parts := strings.Split(version, ".")
+	numbers := make([]int, 0, len(parts))
+	for _, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %s", version)
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+// compareVersions compares dotted numeric versions, invalid versions sort first.
+func compareVersions(a string, b string) int {
+	left, _ := parseVersion(a)
+	right, _ := parseVersion(b)
+	for i := 0; i < len(left) || i < len(right); i++ {
+		l, r := 0, 0
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		if l != r {
+			if l < r {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+//go:build linux
+// +build linux
+
+package common
+
+import "syscall"
+
+func freeDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+func unameMachine() (string, error) {
+	var uname syscall.Utsname
+	if err := syscall.Uname(&uname); err != nil {
+		return "", err
+	}
+	// Machine is int8 or uint8 depending on the architecture
+	machine := make([]byte, 0, len(uname.Machine))
+	for _, c := range uname.Machine {
+		if c == 0 {
+			break
+		}
+		machine = append(machine, byte(c))
+	}
+	return string(machine), nil
+}
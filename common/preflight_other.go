@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package common
+
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errPreflightUnsupported
+}
+
+func unameMachine() (string, error) {
+	return "", errPreflightUnsupported
+}
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareVersions(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"2.17", "2.17", 0},
+		{"2.17", "2.27", -1},
+		{"2.31", "2.4", 1},
+		{"2.3.4", "2.3", 1},
+		{"2.3", "2.3.0", 0},
+	}
+	for _, testCase := range testCases {
+		if result := compareVersions(testCase.a, testCase.b); result != testCase.expected {
+			t.Errorf("compareVersions(%s, %s) should be %d, got %d", testCase.a, testCase.b, testCase.expected, result)
+		}
+	}
+}
+
+func TestCheckHostArch(t *testing.T) {
+	defer func(original func() (string, error)) { hostMachine = original }(hostMachine)
+
+	hostMachine = func() (string, error) { return "aarch64", nil }
+	if err := CheckHostArch(&options.TargetPlatform{Os: "linux", Arch: "armv7"}); err != nil {
+		t.Errorf("armv7 agent should run on aarch64: %s", err)
+	}
+
+	hostMachine = func() (string, error) { return "x86_64", nil }
+	err := CheckHostArch(&options.TargetPlatform{Os: "linux", Arch: "arm64"})
+	if err == nil || !strings.Contains(err.Error(), "-arch") {
+		t.Errorf("Expected an arch mismatch, got %v", err)
+	}
+}
+
+func TestCheckGlibc(t *testing.T) {
+	defer func(original func() (string, error)) { hostGlibcVersion = original }(hostGlibcVersion)
+
+	if runtime.GOOS != "linux" {
+		t.Skip("ELF binaries only")
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	required, err := RequiredGlibcVersion(binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if required == "" {
+		t.Skip("test binary does not link against glibc")
+	}
+
+	hostGlibcVersion = func() (string, error) { return required, nil }
+	if err := CheckGlibc(binary); err != nil {
+		t.Error(err)
+	}
+	hostGlibcVersion = func() (string, error) { return "2.0", nil }
+	if err := CheckGlibc(binary); err == nil {
+		t.Error("Expected glibc 2.0 to be too old")
+	}
+}
+
+func TestCheckClockSkew(t *testing.T) {
+	serverTime := time.Now()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", serverTime.UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+
+	if err := CheckClockSkew(context.Background(), server.Client(), server.URL, time.Minute); err != nil {
+		t.Error(err)
+	}
+
+	serverTime = time.Now().Add(10 * time.Minute)
+	if err := CheckClockSkew(context.Background(), server.Client(), server.URL, time.Minute); err == nil {
+		t.Error("Expected a clock skew of 10 minutes to fail")
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	directory := filepath.Join(t.TempDir(), "agent")
+	if err := CheckWritable(directory); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(directory)
+	if len(entries) != 0 {
+		t.Errorf("Expected no leftover files, got %d", len(entries))
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0600)
+	if err := CheckWritable(filepath.Join(file, "agent")); err == nil {
+		t.Error("Expected a directory below a file to fail")
+	}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("statfs is only used on linux")
+	}
+	directory := t.TempDir()
+	if err := CheckDiskSpace(filepath.Join(directory, "missing", "agent"), 1); err != nil {
+		t.Error(err)
+	}
+	if err := CheckDiskSpace(directory, 1<<62); err == nil {
+		t.Error("Expected 4 EiB to be unavailable")
+	}
+}
@@ -0,0 +1,171 @@
+package common
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"embed"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed certs/AmazonRootCA*.pem
+var amazonRootCAs embed.FS
+
+const (
+	RootCAAuto = "auto"
+	RootCARSA  = "rsa"
+	RootCAECC  = "ecc"
+)
+
+type RootCA struct {
+	Name    string
+	KeyType string
+	// SHA-256 fingerprint of the DER encoded certificate
+	Fingerprint string
+}
+
+// AmazonRootCAs lists the Amazon Trust Services roots with their published fingerprints.
+var AmazonRootCAs = []RootCA{
+	{Name: "AmazonRootCA1", KeyType: "RSA 2048", Fingerprint: "8ecde6884f3d87b1125ba31ac3fcb13d7016de7f57cc904fe1cb97c6ae98196e"},
+	{Name: "AmazonRootCA2", KeyType: "RSA 4096", Fingerprint: "1ba5b2aa8c65401a82960118f80bec4f62304d83cec4713a19c39c011ea46db4"},
+	{Name: "AmazonRootCA3", KeyType: "EC P-256", Fingerprint: "18ce6cfe7bf14e60b2e347b8dfe868cb31d02ebb3ada271569f50343b46db3a4"},
+	{Name: "AmazonRootCA4", KeyType: "EC P-384", Fingerprint: "e35d28419ed02025cfa69038cd623962458da5c695fbdea3c22b0bfb25897092"},
+}
+
+func findRootCA(name string) (*RootCA, error) {
+	for i := range AmazonRootCAs {
+		if strings.EqualFold(AmazonRootCAs[i].Name, name) {
+			return &AmazonRootCAs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown root CA %s", name)
+}
+
+// SelectRootCA picks the root CA for the given selection. "auto" chooses the RSA or
+// ECC root matching the key type of the device certificate.
+func SelectRootCA(selection string, certificatePem string) (*RootCA, error) {
+	switch strings.ToLower(selection) {
+	case RootCARSA:
+		return findRootCA("AmazonRootCA1")
+	case RootCAECC:
+		return findRootCA("AmazonRootCA3")
+	case RootCAAuto, "":
+		block, _ := pem.Decode([]byte(certificatePem))
+		if block == nil {
+			return nil, errors.New("device certificate is not PEM encoded")
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch certificate.PublicKey.(type) {
+		case *rsa.PublicKey:
+			return findRootCA("AmazonRootCA1")
+		case *ecdsa.PublicKey:
+			return findRootCA("AmazonRootCA3")
+		}
+		return nil, fmt.Errorf("unsupported device certificate key type %s", certificate.PublicKeyAlgorithm)
+	}
+	return findRootCA(selection)
+}
+
+func (ca *RootCA) FileName() string {
+	return ca.Name + ".pem"
+}
+
+func (ca *RootCA) URL() string {
+	return fmt.Sprintf("https://www.amazontrust.com/repository/%s", ca.FileName())
+}
+
+// Verify checks that contents is a single PEM certificate matching the fingerprint of ca.
+func (ca *RootCA) Verify(contents []byte) error {
+	block, rest := pem.Decode(contents)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("%s is not a PEM encoded certificate", ca.Name)
+	}
+	if len(strings.TrimSpace(string(rest))) != 0 {
+		return fmt.Errorf("%s contains unexpected data after the certificate", ca.Name)
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(block.Bytes)
+	if fingerprint := hex.EncodeToString(sum[:]); fingerprint != ca.Fingerprint {
+		return fmt.Errorf("%s fingerprint mismatch, expected %s got %s", ca.Name, ca.Fingerprint, fingerprint)
+	}
+	return nil
+}
+
+// Embedded returns the bundled certificate of ca after verifying its fingerprint.
+func (ca *RootCA) Embedded() ([]byte, error) {
+	contents, err := amazonRootCAs.ReadFile("certs/" + ca.FileName())
+	if err != nil {
+		return nil, err
+	}
+	if err := ca.Verify(contents); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// Download fetches the certificate of ca from url and verifies its fingerprint.
+func (ca *RootCA) Download(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s, got status %s", url, resp.Status)
+	}
+
+	// root certificates are a couple of kilobytes
+	contents, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if err := ca.Verify(contents); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// InstallRootCA writes the verified certificate of ca into directory and returns its path.
+// The certificate is downloaded from amazontrust.com only when download is set.
+func InstallRootCA(ctx context.Context, client *http.Client, ca *RootCA, directory string, download bool) (string, error) {
+	var contents []byte
+	var err error
+	if download {
+		contents, err = ca.Download(ctx, client, ca.URL())
+	} else {
+		contents, err = ca.Embedded()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(directory, ca.FileName())
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,112 @@
+package common
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func selfSignedPem(t *testing.T, key crypto.Signer) string {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "AWS IoT Certificate"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestEmbeddedRootCAs(t *testing.T) {
+	for i := range AmazonRootCAs {
+		if _, err := AmazonRootCAs[i].Embedded(); err != nil {
+			t.Fatalf("Embedded %s should match its fingerprint: %s", AmazonRootCAs[i].Name, err)
+		}
+	}
+}
+
+func TestSelectRootCA(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	testCases := []struct {
+		selection   string
+		certificate string
+		expected    string
+	}{
+		{"auto", selfSignedPem(t, rsaKey), "AmazonRootCA1"},
+		{"auto", selfSignedPem(t, ecKey), "AmazonRootCA3"},
+		{"rsa", "", "AmazonRootCA1"},
+		{"ecc", "", "AmazonRootCA3"},
+		{"AmazonRootCA4", "", "AmazonRootCA4"},
+	}
+
+	for _, testCase := range testCases {
+		ca, err := SelectRootCA(testCase.selection, testCase.certificate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ca.Name != testCase.expected {
+			t.Fatalf("Selection %s should pick %s, got %s", testCase.selection, testCase.expected, ca.Name)
+		}
+	}
+
+	if _, err := SelectRootCA("AmazonRootCA5", ""); err == nil {
+		t.Fatal("Unknown root CA should be rejected")
+	}
+}
+
+func TestRootCAVerify(t *testing.T) {
+	ca, _ := findRootCA("AmazonRootCA1")
+	other, _ := findRootCA("AmazonRootCA2")
+	contents, _ := other.Embedded()
+
+	if err := ca.Verify(contents); err == nil {
+		t.Fatal("Certificate with a different fingerprint should be rejected")
+	}
+	if err := ca.Verify([]byte("<html>Not Found</html>")); err == nil {
+		t.Fatal("Non PEM content should be rejected")
+	}
+}
+
+func TestRootCADownload(t *testing.T) {
+	ca, _ := findRootCA("AmazonRootCA1")
+	contents, _ := ca.Embedded()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/AmazonRootCA1.pem":
+			w.Write(contents)
+		case "/error.pem":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(contents)
+		default:
+			w.Write([]byte("<html>Moved</html>"))
+		}
+	}))
+	defer server.Close()
+
+	if _, err := ca.Download(context.Background(), server.Client(), server.URL+"/AmazonRootCA1.pem"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ca.Download(context.Background(), server.Client(), server.URL+"/error.pem"); err == nil {
+		t.Fatal("Non 200 responses should be rejected")
+	}
+	if _, err := ca.Download(context.Background(), server.Client(), server.URL+"/html.pem"); err == nil {
+		t.Fatal("HTML responses should be rejected")
+	}
+}
@@ -0,0 +1,220 @@
+package common
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+type serviceUnit struct {
+	Description      string
+	User             string
+	WorkingDirectory string
+	EnvironmentFile  string
+	ExecStart        string
+	RuntimeDirectory string
+	ReadWritePaths   string
+}
+
+var serviceUnitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description={{.Description}}
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+User={{.User}}
+Group={{.User}}
+WorkingDirectory={{.WorkingDirectory}}
+EnvironmentFile=-{{.EnvironmentFile}}
+ExecStart={{.ExecStart}}
+Restart=on-failure
+RestartSec=5
+RuntimeDirectory={{.RuntimeDirectory}}
+NoNewPrivileges=true
+PrivateTmp=true
+PrivateDevices=true
+ProtectSystem=strict
+ProtectHome=read-only
+ProtectKernelTunables=true
+ProtectKernelModules=true
+ProtectControlGroups=true
+RestrictSUIDSGID=true
+LockPersonality=true
+{{- if .ReadWritePaths}}
+ReadWritePaths={{.ReadWritePaths}}
+{{- end}}
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// runCommand executes external tools such as systemctl, replaced in tests. Their output
+// is only reported when they fail.
+var runCommand = func(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// systemdEscape escapes specifiers, systemd expands %x in every setting.
+func systemdEscape(value string) string {
+	return strings.ReplaceAll(value, "%", "%%")
+}
+
+// systemdQuote quotes values containing whitespace or quotes for settings that split
+// their value into words, such as ExecStart= and ReadWritePaths=.
+func systemdQuote(value string) string {
+	value = systemdEscape(value)
+	if strings.ContainsAny(value, " \t\"'\\") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// checkUnitPaths rejects paths that cannot be written to a unit file. Settings end at
+// the line and surrounding whitespace is stripped from their values.
+func checkUnitPaths(paths ...string) error {
+	for _, path := range paths {
+		if strings.ContainsAny(path, "\n\r") || path != strings.TrimSpace(path) {
+			return fmt.Errorf("path %q cannot be used in a systemd unit", path)
+		}
+	}
+	return nil
+}
+
+// RenderServiceUnit renders the systemd unit running the agent with the generated config.
+func RenderServiceUnit(cliArgs *cli.CliArgs) (string, error) {
+	opts := &cliArgs.Service
+	agentDirectory := cliArgs.DeviceAgentDirectory()
+	binary := filepath.Join(agentDirectory, "bin", "sagemaker_edge_agent_binary")
+	config := filepath.Join(agentDirectory, "sagemaker_edge_config.json")
+	environmentFile := filepath.Join(agentDirectory, "sagemaker_edge_agent.env")
+	if err := checkUnitPaths(agentDirectory, opts.Socket, cliArgs.Capture.DiskPath); err != nil {
+		return "", err
+	}
+
+	readWritePaths := make([]string, 0)
+	if cliArgs.EnableDB {
+		readWritePaths = append(readWritePaths, systemdQuote(filepath.Join(agentDirectory, "local_data")))
+	}
+	if cliArgs.Capture.Destination == constants.CAPTURE_DESTINATION_DISK {
+		readWritePaths = append(readWritePaths, systemdQuote(cliArgs.Capture.DiskPath))
+	}
+	// ProtectSystem=strict leaves only the runtime directory writable for the socket
+	if socketDirectory := filepath.Dir(opts.Socket); socketDirectory != filepath.Join("/run", opts.Name) {
+		readWritePaths = append(readWritePaths, systemdQuote(socketDirectory))
+	}
+
+	unit := serviceUnit{
+		Description: fmt.Sprintf("Amazon SageMaker Edge Agent for %s", cliArgs.DeviceName),
+		User:        opts.User,
+		// single path settings take the rest of the line literally, quotes would be
+		// part of the path
+		WorkingDirectory: systemdEscape(agentDirectory),
+		EnvironmentFile:  systemdEscape(environmentFile),
+		ExecStart:        strings.Join([]string{systemdQuote(binary), "-a", systemdQuote(opts.Socket), "-c", systemdQuote(config)}, " "),
+		RuntimeDirectory: opts.Name,
+		ReadWritePaths:   strings.Join(readWritePaths, " "),
+	}
+
+	var builder strings.Builder
+	if err := serviceUnitTemplate.Execute(&builder, unit); err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+func ServiceUnitPath(opts *options.ServiceOptions) string {
+	return filepath.Join(opts.Root, "etc", "systemd", "system", opts.Name+".service")
+}
+
+// InstallService writes the agent unit under the service root and optionally enables it.
+// On the running system ("/" root) the service user is created if missing and given
+// ownership of the agent directory. For other roots only the unit and, when enabled,
+// its install symlinks are written.
+func InstallService(cliArgs *cli.CliArgs) (string, error) {
+	opts := &cliArgs.Service
+	unit, err := RenderServiceUnit(cliArgs)
+	if err != nil {
+		return "", err
+	}
+
+	unitPath := ServiceUnitPath(opts)
+	if err := WriteFileAtomic(unitPath, []byte(unit), 0644); err != nil {
+		return "", err
+	}
+
+	liveSystem := filepath.Clean(opts.Root) == "/"
+	if liveSystem {
+		if err := ensureServiceUser(opts.User); err != nil {
+			return "", err
+		}
+		if err := chownTree(cliArgs.AgentDirectory, opts.User); err != nil {
+			return "", err
+		}
+		if cliArgs.Capture.Destination == constants.CAPTURE_DESTINATION_DISK {
+			if err := chownTree(cliArgs.Capture.DiskPath, opts.User); err != nil {
+				return "", err
+			}
+		}
+		if err := runCommand("systemctl", "daemon-reload"); err != nil {
+			return "", err
+		}
+	}
+
+	if opts.Enable {
+		if liveSystem {
+			err = runCommand("systemctl", "enable", "--now", opts.Name+".service")
+		} else {
+			err = runCommand("systemctl", "--root", opts.Root, "enable", opts.Name+".service")
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to enable %s: %w", opts.Name, err)
+		}
+	}
+	return unitPath, nil
+}
+
+func ensureServiceUser(name string) error {
+	if _, err := user.Lookup(name); err == nil {
+		return nil
+	} else if _, ok := err.(user.UnknownUserError); !ok {
+		return err
+	}
+	return runCommand("useradd", "--system", "--no-create-home", "--shell", "/usr/sbin/nologin", name)
+}
+
+// chownTree hands a directory to the service user, the agent reads its credentials
+// and writes local and captured data.
+func chownTree(root string, name string) error {
+	account, err := user.Lookup(name)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(account.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(account.Gid)
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func serviceCliArgs(root string) *cli.CliArgs {
+	return &cli.CliArgs{
+		DeviceName:     "some-device",
+		AgentDirectory: "/opt/sagemaker edge",
+		EnableDB:       true,
+		Service: options.ServiceOptions{
+			Install: true,
+			Name:    "sagemaker-edge-agent",
+			User:    "sagemaker-edge",
+			Root:    root,
+			Socket:  "/run/sagemaker-edge-agent/sagemaker_edge_agent.sock",
+		},
+	}
+}
+
+func TestRenderServiceUnit(t *testing.T) {
+	unit, err := RenderServiceUnit(serviceCliArgs("/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`ExecStart="/opt/sagemaker edge/bin/sagemaker_edge_agent_binary" -a /run/sagemaker-edge-agent/sagemaker_edge_agent.sock -c "/opt/sagemaker edge/sagemaker_edge_config.json"`,
+		"User=sagemaker-edge\n",
+		"Restart=on-failure\n",
+		"ProtectSystem=strict\n",
+		`ReadWritePaths="/opt/sagemaker edge/local_data"`,
+		"WorkingDirectory=/opt/sagemaker edge\n",
+		"EnvironmentFile=-/opt/sagemaker edge/sagemaker_edge_agent.env\n",
+		"RuntimeDirectory=sagemaker-edge-agent\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(unit, line) {
+			t.Fatalf("Unit should contain %s, got\n%s", line, unit)
+		}
+	}
+
+	withoutDB := serviceCliArgs("/")
+	withoutDB.EnableDB = false
+	unit, _ = RenderServiceUnit(withoutDB)
+	if strings.Contains(unit, "ReadWritePaths") {
+		t.Fatal("ReadWritePaths should be omitted without local data")
+	}
+
+	customSocket := serviceCliArgs("/")
+	customSocket.Service.Socket = "/var/run/edge 100%/agent.sock"
+	unit, _ = RenderServiceUnit(customSocket)
+	if !strings.Contains(unit, `ReadWritePaths="/opt/sagemaker edge/local_data" "/var/run/edge 100%%"`+"\n") {
+		t.Fatalf("The directory of a custom socket should be writable, got\n%s", unit)
+	}
+
+	invalid := serviceCliArgs("/")
+	invalid.AgentDirectory = "/opt/sagemaker\nedge"
+	if _, err := RenderServiceUnit(invalid); err == nil {
+		t.Fatal("Paths with line breaks should be rejected")
+	}
+}
+
+func TestInstallServiceUnderRoot(t *testing.T) {
+	commands := make([]string, 0)
+	defer func(original func(string, ...string) error) { runCommand = original }(runCommand)
+	runCommand = func(name string, args ...string) error {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return nil
+	}
+
+	root := t.TempDir()
+	cliArgs := serviceCliArgs(root)
+	cliArgs.Service.Enable = true
+
+	unitPath, err := InstallService(cliArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unitPath != filepath.Join(root, "etc", "systemd", "system", "sagemaker-edge-agent.service") {
+		t.Fatalf("Unexpected unit path %s", unitPath)
+	}
+	if info, err := os.Stat(unitPath); err != nil || info.Mode().Perm() != 0644 {
+		t.Fatalf("Unit should be written with mode 0644, got %v %v", info, err)
+	}
+	if contents, _ := ioutil.ReadFile(unitPath); !strings.Contains(string(contents), "[Install]") {
+		t.Fatal("Unit should be rendered")
+	}
+
+	if len(commands) != 1 || commands[0] != "systemctl --root "+root+" enable sagemaker-edge-agent.service" {
+		t.Fatalf("Only an offline enable should run under a service root, got %v", commands)
+	}
+}
@@ -1,40 +1,44 @@
 package common
 
 import (
-	"archive/tar"
-	"archive/zip"
 	"aws-sagemaker-edge-quick-device-setup/aws"
+	"aws-sagemaker-edge-quick-device-setup/cache"
 	"aws-sagemaker-edge-quick-device-setup/cli"
 	"aws-sagemaker-edge-quick-device-setup/constants"
-	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
-
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 type Release struct {
 	s3Location    string
+	size          int64
 	sha1_shasum   string
 	sha256_shasum string
 	sha512_shasum string
 	md5_shasum    string
 }
 
-func GetAgentRelease(client *s3.Client, bucketName *string, prefix *string) *Release {
-	output := aws.ListBucket(client, bucketName, prefix)
+func GetAgentRelease(ctx context.Context, client aws.S3Client, bucketName *string, prefix *string) (*Release, error) {
+	output, err := aws.ListBucket(ctx, client, bucketName, prefix)
+	if err != nil {
+		return nil, err
+	}
 	releases := make(map[int]*Release)
 	releaseDates := make([]int, 0)
 	for _, value := range output.Contents {
-		paths := strings.Split(*value.Key, "/")
-		version := strings.Split(paths[1], ".")
+		// keys are laid out as <prefix><version>/<file>
+		paths := strings.Split(strings.TrimPrefix(strings.TrimPrefix(*value.Key, *prefix), "/"), "/")
+		if len(paths) != 2 {
+			continue
+		}
+		version := strings.Split(paths[0], ".")
 		if len(version) != 3 {
 			continue
 		}
@@ -50,187 +54,148 @@ func GetAgentRelease(client *s3.Client, bucketName *string, prefix *string) *Rel
 			releaseDates = append(releaseDates, date)
 		}
 
-		if strings.HasSuffix(paths[2], "tgz") || strings.HasSuffix(paths[2], "zip") {
+		if isAgentArchive(paths[1]) {
 			release.s3Location = *value.Key
-		} else if strings.HasSuffix(paths[2], "shasum") {
-			if strings.HasPrefix(paths[2], "sha1") {
+			release.size = value.Size
+		} else if strings.HasSuffix(paths[1], "shasum") {
+			if strings.HasPrefix(paths[1], "sha1") {
 				release.sha1_shasum = *value.Key
-			} else if strings.HasPrefix(paths[2], "sha256") {
+			} else if strings.HasPrefix(paths[1], "sha256") {
 				release.sha256_shasum = *value.Key
-			} else if strings.HasPrefix(paths[2], "sha512") {
+			} else if strings.HasPrefix(paths[1], "sha512") {
 				release.sha512_shasum = *value.Key
-			} else if strings.HasPrefix(paths[2], "md5") {
+			} else if strings.HasPrefix(paths[1], "md5") {
 				release.md5_shasum = *value.Key
 			}
 		}
 	}
 
+	if len(releaseDates) == 0 {
+		return nil, fmt.Errorf("no agent release found in bucket %s under prefix %s", *bucketName, *prefix)
+	}
+
 	sort.Ints(releaseDates)
 	latestReleaseDate := releaseDates[len(releaseDates)-1]
-	return releases[latestReleaseDate]
+	return releases[latestReleaseDate], nil
 }
 
-func DownloadAgent(client *s3.Client, cliArgs *cli.CliArgs) *string {
-
-	arch := cliArgs.TargetPlatform.Arch
-
-	// map target arch to the s3 bucket
-	if cliArgs.TargetPlatform.Arch == constants.AMD64 || cliArgs.TargetPlatform.Arch == constants.X86_64 || cliArgs.TargetPlatform.Arch == constants.X64 {
-		arch = constants.X64
-	} else if cliArgs.TargetPlatform.Arch == constants.I386 || cliArgs.TargetPlatform.Arch == constants.X86 {
-		arch = constants.X86
-	} else if cliArgs.TargetPlatform.Arch == constants.ARM64 || cliArgs.TargetPlatform.Arch == constants.ARMV8 {
-		arch = constants.ARMV8
-	}
+var agentArchiveSuffixes = []string{".tgz", ".tar.gz", ".zip", ".tar", ".tar.xz", ".txz", ".tar.zst", ".tzst"}
 
-	agentBucket := fmt.Sprintf("sagemaker-edge-release-store-us-west-2-%s-%s", cliArgs.TargetPlatform.Os, arch)
-	s3Prefix := "Releases/"
-	release := GetAgentRelease(client, &agentBucket, &s3Prefix)
-	agentFile := aws.DownloadFileFromS3(client, &agentBucket, &release.s3Location)
-	if strings.HasSuffix(*agentFile, "gz") {
-		untar(agentFile, &cliArgs.AgentDirectory)
-	} else if strings.HasSuffix(*agentFile, "zip") {
-		unzip(agentFile, &cliArgs.AgentDirectory)
-	} else {
-		log.Fatal("Unsupported agent format!")
+func isAgentArchive(name string) bool {
+	for _, suffix := range agentArchiveSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
 	}
+	return false
+}
 
-	return agentFile
+// agentReleaseBucket returns the release store bucket of the target platform.
+func agentReleaseBucket(cliArgs *cli.CliArgs) string {
+	// accelerator builds live in their own bucket if the template has a {variant}
+	return cliArgs.ReleaseStore.BucketName(cliArgs.TargetPlatform.Os, cliArgs.TargetPlatform.ReleaseArch(), cliArgs.TargetPlatform.GetAccelerator().ReleaseVariant)
 }
 
-func unzip(src *string, dest *string) {
-	r, err := zip.OpenReader(*src)
+func latestAgentRelease(ctx context.Context, client aws.S3Client, cliArgs *cli.CliArgs) (string, *Release, error) {
+	agentBucket := agentReleaseBucket(cliArgs)
+	s3Prefix := cliArgs.ReleaseStore.Prefix
+	release, err := GetAgentRelease(ctx, client, &agentBucket, &s3Prefix)
 	if err != nil {
-		log.Fatal(err)
+		return agentBucket, nil, err
 	}
-	defer r.Close()
-
-	for _, f := range r.File {
-
-		// Store filename/path for returning and using later on
-		fpath := filepath.Join(*dest, f.Name)
-
-		// Check for ZipSlip. More Info: http://bit.ly/2MsjAWE
-		if !strings.HasPrefix(fpath, filepath.Clean(*dest)+string(os.PathSeparator)) {
-			log.Fatal(fmt.Sprintf("%s: illegal file path", fpath))
-		}
-
-		fmt.Println(fpath)
-
-		if f.FileInfo().IsDir() {
-			// Make Folder
-			os.MkdirAll(fpath, os.ModePerm)
-			continue
-		}
-
-		// Make File
-		if err = os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
-			log.Fatal(err)
-		}
-
-		outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		rc, err := f.Open()
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		_, err = io.Copy(outFile, rc)
-
-		// Close the file without defer to close before next iteration of loop
-		outFile.Close()
-		rc.Close()
-
-		if err != nil {
-			log.Fatal(err)
-		}
+	if release.s3Location == "" {
+		return agentBucket, nil, fmt.Errorf("no agent archive found for the latest release in bucket %s", agentBucket)
 	}
+	return agentBucket, release, nil
 }
 
-func untar(agentFile *string, dest *string) {
-	file, err := os.Open(*agentFile)
-
+// AgentArchiveSize returns the size of the latest agent archive in the release store.
+func AgentArchiveSize(ctx context.Context, client aws.S3Client, cliArgs *cli.CliArgs) (int64, error) {
+	_, release, err := latestAgentRelease(ctx, client, cliArgs)
 	if err != nil {
-		log.Fatal("Error ", err)
+		return 0, err
 	}
+	return release.size, nil
+}
 
-	defer file.Close()
-	var fileReader io.ReadCloser = file
-	if strings.HasSuffix(*agentFile, "gz") {
-		if fileReader, err = gzip.NewReader(file); err != nil {
-			log.Fatal("Error ", err)
-		}
-		defer fileReader.Close()
+// DownloadAgent downloads and extracts the latest agent release. verify checks the
+// extracted files before they replace the agent directory, nil skips it.
+func DownloadAgent(ctx context.Context, client aws.S3Client, objectCache *cache.Cache, cliArgs *cli.CliArgs, verify func(staging string) error) (*string, error) {
+	agentBucket, release, err := latestAgentRelease(ctx, client, cliArgs)
+	if err != nil {
+		return nil, err
+	}
+	agentFile, err := aws.DownloadFileFromS3(ctx, client, objectCache, &agentBucket, &release.s3Location)
+	if err != nil {
+		return nil, err
+	}
+	if err := ExtractArchive(*agentFile, cliArgs.AgentDirectory, ExtractOptions{Verify: verify, Logger: aws.Logger(ctx)}); err != nil {
+		return nil, fmt.Errorf("failed to extract agent archive %s: %w", release.s3Location, err)
 	}
-	tarBallReader := tar.NewReader(fileReader)
-	for {
-		header, err := tarBallReader.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			log.Fatal("Error ", err)
-		}
-
-		// get the individual filename and extract to the current directory
-		if !strings.Contains(header.Name, "..") {
-			filename := filepath.Join(*dest, header.Name)
-
-			switch header.Typeflag {
-			case tar.TypeDir:
-				// handle directory
-				fmt.Println("Creating directory :", filename)
-				err = os.MkdirAll(filename, os.FileMode(header.Mode)) // or use 0755 if you prefer
-
-				if err != nil {
-					log.Fatal("Error ", err)
-				}
-
-			case tar.TypeReg:
-				// handle normal file
-				fmt.Println("Untarring :", filename)
-				writer, err := os.Create(filename)
 
-				if err != nil {
-					log.Fatal("Error ", err)
-				}
+	return agentFile, nil
+}
 
-				io.Copy(writer, tarBallReader)
+func DownloadSigningRootCert(ctx context.Context, client aws.S3Client, objectCache *cache.Cache, cliArgs *cli.CliArgs) error {
+	region := cliArgs.ReleaseStore.Region
+	certBucket := cliArgs.ReleaseStore.BucketName("linux", constants.X64, "")
+	certKey := fmt.Sprintf("Certificates/%s/%s.pem", region, region)
+	certPath := filepath.Join(cliArgs.AgentDirectory, "certificates", fmt.Sprintf("%s.pem", region))
+	cachedCertPath, err := aws.DownloadFileFromS3(ctx, client, objectCache, &certBucket, &certKey)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(*cachedCertPath, certPath, 0400); err != nil {
+		return fmt.Errorf("failed to copy signing root certificate to %s: %w", certPath, err)
+	}
+	return nil
+}
 
-				err = os.Chmod(filename, os.FileMode(header.Mode))
+// copyFile replaces dest with a copy of src. The previous file is removed first since
+// it may be read-only from an earlier run.
+func copyFile(src string, dest string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
 
-				if err != nil {
-					log.Fatal("Error ", err)
-				}
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 
-				writer.Close()
-			default:
-				fmt.Printf("Unable to untar type : %c in file %s", header.Typeflag, filename)
-			}
-		}
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
 	}
-}
 
-func DownloadSigningRootCert(client *s3.Client, cliArgs *cli.CliArgs) {
-	certBucket := "sagemaker-edge-release-store-us-west-2-linux-x64"
-	certKey := "Certificates/us-west-2/us-west-2.pem"
-	certPath := filepath.Join(cliArgs.AgentDirectory, "certificates", "us-west-2.pem")
-	aws.DownloadFileFromS3ToPath(client, &certBucket, &certKey, &certPath)
-	os.Chmod(certPath, 0400)
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
 }
 
-func DownloadFile(filepath string, url string) error {
+func DownloadFile(ctx context.Context, client *http.Client, filepath string, url string) error {
 
 	// Get the data
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s, got status %s", url, resp.Status)
+	}
+
 	// Create the file
 	out, err := os.Create(filepath)
 	if err != nil {
@@ -1 +1,70 @@
 package common
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type mockS3Client struct{}
+
+var s3MockListObjects func(ctx context.Context, params *s3.ListObjectsInput, optFns ...func(*s3.Options)) (*s3.ListObjectsOutput, error)
+
+func (client mockS3Client) CreateBucket(ctx context.Context, params *s3.CreateBucketInput, optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error) {
+	return &s3.CreateBucketOutput{}, nil
+}
+
+func (client mockS3Client) ListObjects(ctx context.Context, params *s3.ListObjectsInput, optFns ...func(*s3.Options)) (*s3.ListObjectsOutput, error) {
+	return s3MockListObjects(ctx, params, optFns...)
+}
+
+func (client mockS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return &s3.GetObjectOutput{}, nil
+}
+
+func (client mockS3Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return &s3.HeadObjectOutput{}, nil
+}
+
+func s3Objects(keys ...string) []types.Object {
+	objects := make([]types.Object, 0, len(keys))
+	for i := range keys {
+		objects = append(objects, types.Object{Key: &keys[i]})
+	}
+	return objects
+}
+
+func TestGetAgentRelease(t *testing.T) {
+	client := mockS3Client{}
+	bucket := "mirror-release-store-linux-x64"
+	prefix := "mirror/Releases/"
+
+	s3MockListObjects = func(ctx context.Context, params *s3.ListObjectsInput, optFns ...func(*s3.Options)) (*s3.ListObjectsOutput, error) {
+		if *params.Prefix != prefix {
+			t.Fatalf("Unexpected prefix %s", *params.Prefix)
+		}
+		return &s3.ListObjectsOutput{
+			Contents: s3Objects(
+				"mirror/Releases/1.20210512.96da6cc/1.20210512.96da6cc.tgz",
+				"mirror/Releases/1.20210512.96da6cc/sha256_hex.shasum",
+				"mirror/Releases/1.20220113.a1b2c3d/1.20220113.a1b2c3d.tgz",
+				"mirror/Releases/1.20220113.a1b2c3d/sha256_hex.shasum",
+				"mirror/Releases/README.txt",
+			),
+		}, nil
+	}
+
+	release, err := GetAgentRelease(context.Background(), client, &bucket, &prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if release.s3Location != "mirror/Releases/1.20220113.a1b2c3d/1.20220113.a1b2c3d.tgz" {
+		t.Fatalf("Should pick the latest release, got %s", release.s3Location)
+	}
+	if release.sha256_shasum != "mirror/Releases/1.20220113.a1b2c3d/sha256_hex.shasum" {
+		t.Fatalf("Invalid sha256 shasum location %s", release.sha256_shasum)
+	}
+}
@@ -0,0 +1,160 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	agentBinaryName = "sagemaker_edge_agent_binary"
+	agentClientName = "sagemaker_edge_agent_client_example"
+)
+
+// AgentVerification tells how much of the agent VerifyAgent could check.
+type AgentVerification string
+
+const (
+	// AgentVerified means the models were listed through the agent's API
+	AgentVerified AgentVerification = "full"
+	// AgentPartiallyVerified means the agent accepted connections but its API was not
+	// called, the release lacks the client example to do so
+	AgentPartiallyVerified AgentVerification = "partial"
+)
+
+// lockedBuffer collects agent output while the verification reads it.
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+// VerifyAgent starts the installed agent with the generated config on a temporary UNIX
+// socket, waits until it accepts connections, lists the models through the bundled
+// client example and shuts the agent down again. Without the client example the
+// verification is partial. Errors include the agent output.
+func VerifyAgent(ctx context.Context, agentDirectory string, timeout time.Duration) (AgentVerification, error) {
+	socketDirectory, err := ioutil.TempDir("", "sagemaker-edge-verify-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(socketDirectory)
+	socket := filepath.Join(socketDirectory, "agent.sock")
+
+	environment, err := agentEnvironment(agentDirectory)
+	if err != nil {
+		return "", err
+	}
+
+	output := &lockedBuffer{}
+	agent := exec.Command(filepath.Join(agentDirectory, "bin", agentBinaryName), "-a", socket, "-c", filepath.Join(agentDirectory, "sagemaker_edge_config.json"))
+	agent.Dir = agentDirectory
+	agent.Env = environment
+	agent.Stdout = output
+	agent.Stderr = output
+	if err := agent.Start(); err != nil {
+		return "", fmt.Errorf("failed to start agent: %w", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- agent.Wait() }()
+	defer stopAgent(agent, exited)
+
+	if err := waitForSocket(ctx, socket, timeout, exited); err != nil {
+		return "", fmt.Errorf("%w\nagent output:\n%s", err, output.String())
+	}
+
+	client := filepath.Join(agentDirectory, "bin", agentClientName)
+	if _, err := os.Stat(client); err != nil {
+		return AgentPartiallyVerified, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	listModels := exec.CommandContext(ctx, client, "-a", socket, "ListModels")
+	listModels.Dir = agentDirectory
+	listModels.Env = environment
+	if clientOutput, err := listModels.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to list models through the agent: %w\nclient output:\n%s\nagent output:\n%s", err, clientOutput, output.String())
+	}
+	return AgentVerified, nil
+}
+
+func waitForSocket(ctx context.Context, socket string, timeout time.Duration, exited <-chan error) error {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return nil
+		}
+
+		select {
+		case err := <-exited:
+			return fmt.Errorf("agent exited before accepting connections: %v", err)
+		case <-deadline:
+			return fmt.Errorf("agent did not accept connections on %s within %s", socket, timeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// stopAgent asks the agent to terminate and kills it if it does not exit in time.
+func stopAgent(agent *exec.Cmd, exited <-chan error) {
+	if err := agent.Process.Signal(syscall.SIGTERM); err != nil {
+		// already exited
+		return
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		agent.Process.Kill()
+		<-exited
+	}
+}
+
+// agentEnvironment adds the variables of sagemaker_edge_agent.env to the current environment.
+func agentEnvironment(agentDirectory string) ([]string, error) {
+	environment := os.Environ()
+	file, err := os.Open(filepath.Join(agentDirectory, "sagemaker_edge_agent.env"))
+	if os.IsNotExist(err) {
+		return environment, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			environment = append(environment, line)
+		}
+	}
+	return environment, scanner.Err()
+}
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestHelperAgent is not a real test, it stands in for the agent binary and the client
+// example when the test binary is executed by the scripts of writeTestAgent.
+func TestHelperAgent(t *testing.T) {
+	mode := os.Getenv("VERIFY_HELPER")
+	if mode == "" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	socket := args[2]
+
+	switch mode {
+	case "agent":
+		fmt.Println("agent starting")
+		if os.Getenv("VERIFY_HELPER_CRASH") != "" {
+			fmt.Println("failed to load config")
+			os.Exit(1)
+		}
+		listener, err := net.Listen("unix", socket)
+		if err != nil {
+			os.Exit(2)
+		}
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM)
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}
+		}()
+		<-signals
+		listener.Close()
+		os.Exit(0)
+	case "client":
+		conn, err := net.Dial("unix", socket)
+		if err != nil || args[3] != "ListModels" {
+			os.Exit(3)
+		}
+		conn.Close()
+		os.Exit(0)
+	}
+}
+
+func writeTestAgent(t *testing.T, withClient bool) string {
+	agentDirectory := t.TempDir()
+	os.MkdirAll(filepath.Join(agentDirectory, "bin"), 0755)
+
+	script := func(name string, mode string) {
+		contents := fmt.Sprintf("#!/bin/sh\nVERIFY_HELPER=%s exec %s -test.run=TestHelperAgent -- \"$@\"\n", mode, os.Args[0])
+		ioutil.WriteFile(filepath.Join(agentDirectory, "bin", name), []byte(contents), 0755)
+	}
+	script(agentBinaryName, "agent")
+	if withClient {
+		script(agentClientName, "client")
+	}
+	return agentDirectory
+}
+
+func TestVerifyAgent(t *testing.T) {
+	expected := map[bool]AgentVerification{true: AgentVerified, false: AgentPartiallyVerified}
+	for withClient, verification := range expected {
+		result, err := VerifyAgent(context.Background(), writeTestAgent(t, withClient), 10*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != verification {
+			t.Fatalf("Expected %s verification with client %t, got %s", verification, withClient, result)
+		}
+	}
+}
+
+func TestVerifyAgentReportsStartupFailure(t *testing.T) {
+	agentDirectory := writeTestAgent(t, true)
+	ioutil.WriteFile(filepath.Join(agentDirectory, "sagemaker_edge_agent.env"), []byte("VERIFY_HELPER_CRASH=1\n"), 0600)
+
+	_, err := VerifyAgent(context.Background(), agentDirectory, 10*time.Second)
+	if err == nil {
+		t.Fatal("Verification should fail when the agent exits")
+	}
+	if !strings.Contains(err.Error(), "failed to load config") {
+		t.Fatalf("Error should include the agent output, got %s", err)
+	}
+}
@@ -7,3 +7,13 @@ const AMD64 = "amd64"
 const I386 = "386"
 const ARM64 = "arm64"
 const ARMV8 = "armv8"
+
+const CAPTURE_DESTINATION_CLOUD = "Cloud"
+const CAPTURE_DESTINATION_DISK = "Disk"
+
+const ARM = "arm"
+const ARMV7 = "armv7"
+const ARMV7L = "armv7l"
+const ARMHF = "armhf"
+const I686 = "i686"
+const AARCH64 = "aarch64"
@@ -4,121 +4,207 @@ import (
 	"aws-sagemaker-edge-quick-device-setup/aws"
 	"aws-sagemaker-edge-quick-device-setup/cli"
 	"aws-sagemaker-edge-quick-device-setup/common"
+	"aws-sagemaker-edge-quick-device-setup/provisioner"
 	"context"
-	awsStd "github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/aws/retry"
-	"github.com/aws/aws-sdk-go-v2/config"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/iot"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sagemaker"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"log"
 	"os"
-	"path/filepath"
-	"strings"
-	"time"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
 	cliArgs := cli.CliArgs{}
 	cli.ParseArgs(&cliArgs)
+
+	ctx, stop := newContext(&cliArgs)
+	defer stop()
+
+	switch cliArgs.Command {
+	case "":
+		if err := setup(ctx, &cliArgs); err != nil {
+			log.Fatal("Setup failed. Encountered Error ", err)
+		}
+	case cli.CacheCommand:
+		runCacheCommand(&cliArgs)
+	case cli.BundleCommand:
+		runBundleCommand(ctx, &cliArgs)
+	case cli.CheckNetworkCommand:
+		runCheckNetworkCommand(ctx, &cliArgs)
+	case cli.PrintEffectiveConfigCommand:
+		runPrintEffectiveConfigCommand(&cliArgs)
+	case cli.PrintRequiredPermissionsCommand:
+		runPrintRequiredPermissionsCommand(&cliArgs)
+	case cli.StatusCommand:
+		runStatusCommand(ctx, &cliArgs)
+	case cli.TeardownCommand:
+		runTeardownCommand(ctx, &cliArgs)
+	case cli.VerifyCredentialsCommand:
+		runVerifyCredentialsCommand(ctx, &cliArgs)
+	default:
+		log.Fatalf("Unknown command %s\n", cliArgs.Command)
+	}
+}
+
+// newContext returns the context of the command. It is cancelled on SIGINT or SIGTERM,
+// which aborts the running step, and after -timeout. A second signal exits immediately.
+func newContext(cliArgs *cli.CliArgs) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			// restore the default handling for the second signal
+			signal.Stop(signals)
+			log.Println("Interrupted, aborting the current step. Interrupt again to exit immediately.")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	stop := func() {
+		cancel()
+		signal.Stop(signals)
+	}
+	if cliArgs.Timeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancelTimeout := context.WithTimeout(ctx, cliArgs.Timeout)
+	return ctx, func() {
+		cancelTimeout()
+		stop()
+	}
+}
+
+// newClients loads the operator's credentials, resolves the account and builds the
+// provisioner clients.
+func newClients(ctx context.Context, cliArgs *cli.CliArgs) (provisioner.Clients, error) {
+	httpClient, err := common.NewHTTPClient(&cliArgs.Http)
+	if err != nil {
+		return provisioner.Clients{}, fmt.Errorf("failed to configure http client: %w", err)
+	}
+
+	cfgCustomRegion, err := aws.LoadConfig(ctx, cliArgs.Region, httpClient, &cliArgs.Credentials, &cliArgs.Retry)
+	if err != nil {
+		return provisioner.Clients{}, fmt.Errorf("failed to load default aws config: %w", err)
+	}
+
+	// the same credentials share one cache, MFA codes are asked for and used only once
+	cfgReleaseStore := cfgCustomRegion.Copy()
+	cfgReleaseStore.Region = cliArgs.ReleaseStore.Region
+	if cliArgs.ReleaseCredentials != cliArgs.Credentials {
+		cfgReleaseStore, err = aws.LoadConfig(ctx, cliArgs.ReleaseStore.Region, httpClient, &cliArgs.ReleaseCredentials, &cliArgs.Retry)
+		if err != nil {
+			return provisioner.Clients{}, fmt.Errorf("failed to load release store aws config: %w", err)
+		}
+	}
+
+	callerArn, err := aws.ResolveAccount(ctx, sts.NewFromConfig(cfgCustomRegion), &cliArgs.Account)
+	if err != nil {
+		return provisioner.Clients{}, fmt.Errorf("failed to resolve AWS account: %w", err)
+	}
+	cliArgs.CallerArn = *callerArn
+
+	return provisioner.Clients{
+		Iam:       iam.NewFromConfig(cfgCustomRegion),
+		Iot:       iot.NewFromConfig(cfgCustomRegion),
+		Sagemaker: sagemaker.NewFromConfig(cfgCustomRegion),
+		S3:        s3.NewFromConfig(cfgCustomRegion),
+		ReleaseStore: s3.NewFromConfig(cfgReleaseStore, func(o *s3.Options) {
+			if cliArgs.ReleaseStore.Endpoint != "" {
+				o.EndpointResolver = s3.EndpointResolverFromURL(cliArgs.ReleaseStore.Endpoint)
+			}
+			o.UsePathStyle = cliArgs.ReleaseStore.UsePathStyle
+		}),
+		HTTP: httpClient,
+	}, nil
+}
+
+// newProvisioner builds the provisioner for the parsed arguments.
+func newProvisioner(cliArgs *cli.CliArgs, clients provisioner.Clients, agentConfigOverrides map[string]interface{}) (*provisioner.Provisioner, error) {
+	opts := provisioner.OptionsFromCliArgs(cliArgs)
+	opts.AgentConfigOverrides = agentConfigOverrides
+	opts.Logger = log.Default()
+	p, err := provisioner.New(opts, clients)
+	if err != nil {
+		return nil, fmt.Errorf("invalid setup options: %w", err)
+	}
+	return p, nil
+}
+
+// setup provisions the device. Errors are returned rather than fatal, so that callers
+// can clean up what they staged.
+func setup(ctx context.Context, cliArgs *cli.CliArgs) error {
+	// validate overrides before any resource is created
+	// the file given with -agentConfigOverrides is merged over the inline overrides
+	agentConfigOverrides := cliArgs.AgentConfigOverrideValues
+	if cliArgs.AgentConfigOverrides != "" {
+		overrides, err := common.ReadAgentConfigOverrides(cliArgs.AgentConfigOverrides)
+		if err != nil {
+			return fmt.Errorf("failed to load agent config overrides: %w", err)
+		}
+		agentConfigOverrides = common.MergeAgentConfigOverrides(agentConfigOverrides, overrides)
+	}
+	if err := common.ValidateAgentConfigOverrides(agentConfigOverrides); err != nil {
+		return fmt.Errorf("invalid agent config overrides: %w", err)
+	}
+	for _, key := range common.UnknownAgentConfigKeys(agentConfigOverrides) {
+		log.Printf("Agent config override %s is not a known agent key, passing it through as is.\n", key)
+	}
+
+	if err := aws.ValidateNames(cliArgs); err != nil {
+		return fmt.Errorf("invalid resource names: %w", err)
+	}
+
+	clients, err := newClients(ctx, cliArgs)
+	if err != nil {
+		return err
+	}
 	cliArgs.Print()
+	p, err := newProvisioner(cliArgs, clients, agentConfigOverrides)
+	if err != nil {
+		return err
+	}
 
-	// return retry.AddWithErrorCodes(retry.NewStandard(), (*smTypes.Mal)(nil).ErrorCode())
-	cfgCustomRegion, errCustomRegion := config.LoadDefaultConfig(context.TODO(), config.WithRegion(cliArgs.Region), config.WithRetryer(func() awsStd.Retryer {
-		return retry.AddWithErrorCodes(retry.AddWithMaxBackoffDelay(retry.AddWithMaxAttempts(retry.NewStandard(), 5), 1*time.Second), "ValidationException", "ThrottlingException")
-	}))
-
-	// return retry.AddWithErrorCodes(retry.NewStandard(), (*smTypes.Mal)(nil).ErrorCode())
-	cfgUsWest2, errUsWest2 := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-west-2"), config.WithRetryer(func() awsStd.Retryer {
-		return retry.AddWithErrorCodes(retry.AddWithMaxBackoffDelay(retry.AddWithMaxAttempts(retry.NewStandard(), 5), 1*time.Second), "ValidationException", "ThrottlingException")
-	}))
-
-	if errCustomRegion != nil {
-		log.Fatal("Failed to load default aws config. Encountered Error ", errCustomRegion)
-	}
-
-	if errUsWest2 != nil {
-		log.Fatal("Failed to load default aws config. Encountered Error ", errUsWest2)
-	}
-
-	iamClient := iam.NewFromConfig(cfgCustomRegion)
-	smClient := sagemaker.NewFromConfig(cfgCustomRegion)
-	iotClient := iot.NewFromConfig(cfgCustomRegion)
-	s3Client := s3.NewFromConfig(cfgCustomRegion)
-	s3ClientUsWest2 := s3.NewFromConfig(cfgUsWest2)
-
-	log.Println("Step-1 Creating S3 bucket for storing device fleet data...")
-	s3OutputLocation := aws.CreateS3Bucket(s3Client, &cliArgs.DeviceFleetBucket, &cliArgs.Account, &cliArgs.Region)
-	if s3OutputLocation == nil {
-		return
-	}
-	log.Println("Step-1 Completed.")
-
-	cliArgs.DeviceFleetBucket = *s3OutputLocation
-
-	log.Println("Step-2 Creating device fleet policy...")
-	fleetPolicy := aws.CreateDeviceFleetPolicy(iamClient, &cliArgs)
-	log.Println("Step-2 Completed.")
-
-	log.Println("Step-3 Creating device fleet bucket policy...")
-	bucketPolicy := aws.CreateDeviceFleetBucketPolicy(iamClient, &cliArgs)
-	log.Println("Step-3 Completed.")
-
-	log.Println("Step-4 Creating device fleet role...")
-	role := aws.CreateDeviceFleetRoleIfNotExists(iamClient, &cliArgs.DeviceFleet, &cliArgs.DeviceFleetRole, fleetPolicy, bucketPolicy)
-	log.Println("Step-4 Completed.")
-
-	log.Println("Step-5 Creating iot thing type...")
-	aws.CreateIotThingType(iotClient, &cliArgs.IotThingType)
-	log.Println("Step-5 Completed.")
-
-	log.Println("Step-6 Creating iot thing...")
-	aws.CreateIotThing(iotClient, &cliArgs.IotThingType, &cliArgs.IotThingName)
-	log.Println("Step-6 Completed.")
-
-	log.Println("Step-7 Creating device fleet...")
-	// Sleep for 5 seconds before creating fleet.
-	time.Sleep(5 * time.Second)
-	aws.CreateDeviceFleet(smClient, &cliArgs.DeviceFleet, role, s3OutputLocation)
-	log.Println("Step-7 Completed.")
-
-	log.Println("Step-8 Registering device...")
-	aws.RegisterDevice(smClient, &cliArgs.DeviceFleet, &cliArgs.DeviceName, &cliArgs.IotThingName, &cliArgs.TargetPlatform)
-	log.Println("Step-8 Completed.")
-
-	log.Println("Step-9 Downloading Agent...")
-	common.DownloadAgent(s3ClientUsWest2, &cliArgs)
-	log.Println("Step-9 Completed.")
-
-	log.Println("Step-10 Downloading code signing root certificate...")
-	common.DownloadSigningRootCert(s3ClientUsWest2, &cliArgs)
-	log.Println("Step-10 Completed.")
-
-	log.Println("Step-11 Creating iot certificates...")
-	certs := aws.CreateIOTCertificates(iotClient)
-	log.Println("Step-11 Completed.")
-
-	log.Println("Step-12 Attaching certificate to thing...")
-	aws.AttachThingToCertificate(iotClient, certs.CertificateArn, &cliArgs.IotThingName)
-	log.Println("Step-12 Completed.")
-
-	log.Println("Step-13 Configuring Agent...")
-	certsDirectory := filepath.Join(cliArgs.AgentDirectory, "iot-credentials")
-	aws.WriteCertificatesToFile(certs, &cliArgs.DeviceFleet, &cliArgs.DeviceName, &certsDirectory)
-	rootCAPath := filepath.Join(certsDirectory, "AmazonRootCA1.pem")
-	common.DownloadFile(rootCAPath, "https://www.amazontrust.com/repository/AmazonRootCA1.pem")
-	config := common.AgentConfig{}
-	configPath := filepath.Join(cliArgs.AgentDirectory, "sagemaker_edge_config.json")
-	config.FromCliArgs(&cliArgs)
-	roleAliasArn := aws.GetRoleAliasArn(smClient, &cliArgs.DeviceFleet)
-	aws.CreateAndAttachRoleAliasPolicy(iotClient, roleAliasArn, certs.CertificateArn, &cliArgs.IotThingName)
-	roleAliasSplits := strings.Split(*roleAliasArn, "/")
-	config.ProviderAwsIotCredEndpoint = *aws.GetIotCredentialProviderEndpoint(iotClient, &roleAliasSplits[1])
-	config.WriteToJson(&configPath)
-	agentBinaryPath := filepath.Join(cliArgs.AgentDirectory, "bin", "sagemaker_edge_agent_binary")
-	agentClientPath := filepath.Join(cliArgs.AgentDirectory, "bin", "sagemaker_edge_agent_client_example")
-	os.Chmod(agentBinaryPath, 0700)
-	os.Chmod(agentClientPath, 0700)
-	log.Println("Step-13 Completed.")
+	if !cliArgs.SkipPermissionCheck {
+		if err := checkPermissions(ctx, p, cliArgs); err != nil {
+			return err
+		}
+	}
+
+	result, err := p.Setup(ctx)
+	for _, resource := range result.RolledBack {
+		log.Printf("Rolled back %s\n", resource)
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("interrupted after %d steps, state recorded in %s, run setup again to continue: %w", len(result.Steps), cliArgs.StateFile, err)
+	}
+	return err
+}
+
+// checkPermissions simulates the operator's policies for every planned step and fails
+// before any resource is created if an action is denied.
+func checkPermissions(ctx context.Context, p *provisioner.Provisioner, cliArgs *cli.CliArgs) error {
+	log.Println("Checking permissions of", cliArgs.CallerArn)
+	// the release store may be accessed with other credentials
+	releaseStore := cliArgs.ReleaseCredentials == cliArgs.Credentials && cliArgs.ReleaseStore.Endpoint == ""
+	denied, err := p.CheckPermissions(ctx, cliArgs.CallerArn, releaseStore)
+	if err != nil {
+		log.Println("Skipping permission check. Encountered Error ", err)
+		return nil
+	}
+	if len(denied) == 0 {
+		log.Println("All required permissions are granted.")
+		return nil
+	}
+	for _, permission := range denied {
+		log.Printf("%s: %s on %s is %s\n", permission.Step, permission.Action, permission.Resource, permission.Decision)
+	}
+	return fmt.Errorf("%d required permissions are denied for %s, see print-required-permissions for the policy to attach", len(denied), cliArgs.CallerArn)
 }
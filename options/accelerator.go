@@ -0,0 +1,86 @@
+package options
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Accelerator describes the agent build and DLR settings for a hardware accelerator.
+type Accelerator struct {
+	Name    string
+	Aliases []string
+	// ReleaseArches are the release architectures devices with the accelerator use
+	ReleaseArches []string
+	// ReleaseVariant fills the {variant} placeholder of -releaseBucketTemplate as
+	// "-<variant>", e.g. sagemaker-edge-release-store-us-west-2-linux-armv8-nvidia.
+	// The default template has no placeholder and uses the plain build.
+	ReleaseVariant string
+	// DLRBackendOptions is written to sagemaker_edge_dlr_backend_options
+	DLRBackendOptions string
+}
+
+// Accelerators is the registry of supported accelerators. The empty name is the CPU
+// only build used when no accelerator is given.
+var Accelerators = []Accelerator{
+	{
+		Name:          "",
+		Aliases:       []string{"none", "cpu"},
+		ReleaseArches: []string{constants.X64, constants.X86, constants.ARMV8, constants.ARMV7},
+	},
+	{
+		Name:              "nvidia",
+		Aliases:           []string{"jetson", "cuda", "tensorrt"},
+		ReleaseArches:     []string{constants.X64, constants.ARMV8},
+		ReleaseVariant:    "nvidia",
+		DLRBackendOptions: `{"device_type": "gpu", "use_tensorrt": true}`,
+	},
+	{
+		Name:              "intel",
+		Aliases:           []string{"openvino"},
+		ReleaseArches:     []string{constants.X64},
+		ReleaseVariant:    "intel",
+		DLRBackendOptions: `{"device_type": "cpu", "use_openvino": true}`,
+	},
+}
+
+// LookupAccelerator finds an accelerator by name or alias, case insensitive.
+func LookupAccelerator(name string) (*Accelerator, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i := range Accelerators {
+		accelerator := &Accelerators[i]
+		if accelerator.Name == name {
+			return accelerator, nil
+		}
+		for _, alias := range accelerator.Aliases {
+			if alias == name {
+				return accelerator, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown accelerator %s, supported accelerators are %s", name, strings.Join(AcceleratorNames(), ", "))
+}
+
+// AcceleratorNames returns the names and aliases of all registered accelerators.
+func AcceleratorNames() []string {
+	names := make([]string, 0)
+	for _, accelerator := range Accelerators {
+		if accelerator.Name != "" {
+			names = append(names, accelerator.Name)
+		}
+		names = append(names, accelerator.Aliases...)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SupportsReleaseArch reports whether the accelerator build exists for arch.
+func (accelerator *Accelerator) SupportsReleaseArch(arch string) bool {
+	for _, supported := range accelerator.ReleaseArches {
+		if supported == arch {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package options
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"testing"
+)
+
+func TestLookupAccelerator(t *testing.T) {
+	testCases := map[string]string{
+		"":         "",
+		"none":     "",
+		"Jetson":   "nvidia",
+		"tensorrt": "nvidia",
+		"nvidia":   "nvidia",
+		"OpenVINO": "intel",
+	}
+
+	for name, expected := range testCases {
+		accelerator, err := LookupAccelerator(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accelerator.Name != expected {
+			t.Fatalf("%s should resolve to %s, got %s", name, expected, accelerator.Name)
+		}
+	}
+
+	if _, err := LookupAccelerator("tpu"); err == nil {
+		t.Fatal("Unknown accelerators should be rejected")
+	}
+}
+
+func TestAcceleratorReleaseBucket(t *testing.T) {
+	tp := TargetPlatform{Os: "linux", Arch: constants.ARM64, Accelerator: "jetson"}
+	if err := tp.Check(); err != nil {
+		t.Fatal(err)
+	}
+	variant := tp.GetAccelerator().ReleaseVariant
+
+	rs := ReleaseStore{BucketTemplate: "sagemaker-edge-release-store-{region}-{os}-{arch}{variant}", Region: "us-west-2"}
+	if bucket := rs.BucketName(tp.Os, tp.ReleaseArch(), variant); bucket != "sagemaker-edge-release-store-us-west-2-linux-armv8-nvidia" {
+		t.Fatalf("Jetson devices should use the nvidia release variant, got %s", bucket)
+	}
+	if bucket := rs.BucketName(tp.Os, tp.ReleaseArch(), ""); bucket != "sagemaker-edge-release-store-us-west-2-linux-armv8" {
+		t.Fatalf("CPU builds should use the plain release arch, got %s", bucket)
+	}
+	rs.BucketTemplate = "sagemaker-edge-release-store-{region}-{os}-{arch}"
+	if bucket := rs.BucketName(tp.Os, tp.ReleaseArch(), variant); bucket != "sagemaker-edge-release-store-us-west-2-linux-armv8" {
+		t.Fatalf("Templates without {variant} should use the plain build, got %s", bucket)
+	}
+
+	intel, _ := LookupAccelerator("intel")
+	if intel.SupportsReleaseArch(constants.ARMV8) {
+		t.Fatal("Intel builds are only published for x64")
+	}
+}
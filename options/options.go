@@ -0,0 +1,155 @@
+// Package options holds the settings of a device setup that are shared by the command
+// line and the provisioner, together with the registries of supported platforms and
+// accelerators.
+package options
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+type TargetPlatform struct {
+	Os          string
+	Arch        string
+	Accelerator string
+}
+
+func (tp *TargetPlatform) Print() {
+	fmt.Println("Target Platform")
+	fmt.Printf("\tOs: %s\n", tp.Os)
+	fmt.Printf("\tArchitecture: %s\n", tp.Arch)
+	fmt.Printf("\tAccelerator: %s\n", tp.Accelerator)
+}
+
+// Check returns an error if the os, architecture and accelerator combination has no agent release.
+func (tp *TargetPlatform) Check() error {
+	platform, err := LookupPlatform(tp.Os, tp.Arch)
+	if err != nil {
+		return err
+	}
+
+	accelerator, err := LookupAccelerator(tp.Accelerator)
+	if err != nil {
+		return err
+	}
+	if !accelerator.SupportsReleaseArch(platform.ReleaseArch) {
+		return fmt.Errorf("accelerator %s is not available for architecture %s", accelerator.Name, tp.Arch)
+	}
+	return nil
+}
+
+// ReleaseArch maps the target architecture to the architecture of the agent release store.
+func (tp *TargetPlatform) ReleaseArch() string {
+	platform, err := LookupPlatform(tp.Os, tp.Arch)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return platform.ReleaseArch
+}
+
+// GetAccelerator returns the registry entry of the validated target accelerator.
+func (tp *TargetPlatform) GetAccelerator() *Accelerator {
+	accelerator, err := LookupAccelerator(tp.Accelerator)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return accelerator
+}
+
+type ReleaseStore struct {
+	BucketTemplate string
+	Region         string
+	Prefix         string
+	Endpoint       string
+	UsePathStyle   bool
+}
+
+func (rs *ReleaseStore) Print() {
+	fmt.Println("Release Store")
+	fmt.Printf("\tBucket Template: %s\n", rs.BucketTemplate)
+	fmt.Printf("\tRegion: %s\n", rs.Region)
+	fmt.Printf("\tPrefix: %s\n", rs.Prefix)
+	if rs.Endpoint != "" {
+		fmt.Printf("\tEndpoint: %s\n", rs.Endpoint)
+		fmt.Printf("\tPath Style: %t\n", rs.UsePathStyle)
+	}
+}
+
+// BucketName renders the bucket template for the given os, release architecture and
+// accelerator release variant. Supported placeholders are {region}, {os}, {arch} and
+// {variant}, which is empty without a variant and "-<variant>" otherwise.
+func (rs *ReleaseStore) BucketName(os string, arch string, variant string) string {
+	if variant != "" {
+		variant = "-" + variant
+	}
+	replacer := strings.NewReplacer("{region}", rs.Region, "{os}", os, "{arch}", arch, "{variant}", variant)
+	return replacer.Replace(rs.BucketTemplate)
+}
+
+type HttpOptions struct {
+	Proxy    string
+	NoProxy  string
+	CABundle string
+}
+
+func (opts *HttpOptions) Print() {
+	if opts.Proxy == "" && opts.CABundle == "" {
+		return
+	}
+	fmt.Println("Network")
+	if opts.Proxy != "" {
+		proxy := opts.Proxy
+		if proxyUrl, err := url.Parse(opts.Proxy); err == nil {
+			proxy = proxyUrl.Redacted()
+		}
+		fmt.Printf("\tProxy: %s\n", proxy)
+		fmt.Printf("\tNo Proxy: %s\n", opts.NoProxy)
+	}
+	if opts.CABundle != "" {
+		fmt.Printf("\tCA Bundle: %s\n", opts.CABundle)
+	}
+}
+
+type ServiceOptions struct {
+	Install bool
+	Enable  bool
+	Name    string
+	User    string
+	// Root is prepended to the unit path, "/" for the running system
+	Root   string
+	Socket string
+}
+
+func (opts *ServiceOptions) Print() {
+	if !opts.Install {
+		return
+	}
+	fmt.Println("Agent Service")
+	fmt.Printf("\tName: %s\n", opts.Name)
+	fmt.Printf("\tUser: %s\n", opts.User)
+	fmt.Printf("\tRoot: %s\n", opts.Root)
+	fmt.Printf("\tSocket: %s\n", opts.Socket)
+	fmt.Printf("\tEnable: %t\n", opts.Enable)
+}
+
+type CaptureOptions struct {
+	Destination       string
+	DiskPath          string
+	BatchSize         int
+	BufferSize        int
+	PushPeriodSeconds int
+}
+
+func (opts *CaptureOptions) Print() {
+	fmt.Println("Data Capture")
+	fmt.Printf("\tDestination: %s\n", opts.Destination)
+	if opts.Destination == constants.CAPTURE_DESTINATION_DISK {
+		fmt.Printf("\tDisk Path: %s\n", opts.DiskPath)
+	}
+	fmt.Printf("\tBatch Size: %d\n", opts.BatchSize)
+	fmt.Printf("\tBuffer Size: %d\n", opts.BufferSize)
+	fmt.Printf("\tPush Period Seconds: %d\n", opts.PushPeriodSeconds)
+}
@@ -0,0 +1,73 @@
+package options
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"fmt"
+	"sort"
+)
+
+// Platform maps the architecture names accepted for a target to the architecture
+// used in the agent release store.
+type Platform struct {
+	Os          string
+	ReleaseArch string
+	// Arches lists the accepted -arch values, including the GOARCH names build.sh
+	// stamps into distinfo.ARCH
+	Arches []string
+	// Runs lists other release architectures a host of this platform executes
+	Runs []string
+}
+
+// Platforms is the registry of supported target platforms.
+var Platforms = []Platform{
+	{Os: "linux", ReleaseArch: constants.X64, Arches: []string{constants.AMD64, constants.X86_64, constants.X64}, Runs: []string{constants.X86}},
+	{Os: "linux", ReleaseArch: constants.X86, Arches: []string{constants.I386, constants.I686, constants.X86}},
+	{Os: "linux", ReleaseArch: constants.ARMV8, Arches: []string{constants.ARM64, constants.AARCH64, constants.ARMV8}, Runs: []string{constants.ARMV7}},
+	{Os: "linux", ReleaseArch: constants.ARMV7, Arches: []string{constants.ARM, constants.ARMV7L, constants.ARMHF, constants.ARMV7}},
+}
+
+// LookupPlatform finds the platform for an os and architecture name.
+func LookupPlatform(os string, arch string) (*Platform, error) {
+	knownOs := false
+	for i := range Platforms {
+		platform := &Platforms[i]
+		if platform.Os != os {
+			continue
+		}
+		knownOs = true
+		for _, name := range platform.Arches {
+			if name == arch {
+				return platform, nil
+			}
+		}
+	}
+	if !knownOs {
+		return nil, fmt.Errorf("unsupported os %s", os)
+	}
+	return nil, fmt.Errorf("unsupported architecture %s for %s, supported architectures are %v", arch, os, PlatformArches(os))
+}
+
+// PlatformArches returns all accepted architecture names for os.
+func PlatformArches(os string) []string {
+	arches := make([]string, 0)
+	for _, platform := range Platforms {
+		if platform.Os == os {
+			arches = append(arches, platform.Arches...)
+		}
+	}
+	sort.Strings(arches)
+	return arches
+}
+
+// CanRun reports whether a host of the platform executes agents built for releaseArch.
+func (platform *Platform) CanRun(releaseArch string) bool {
+	if platform.ReleaseArch == releaseArch {
+		return true
+	}
+	for _, arch := range platform.Runs {
+		if arch == releaseArch {
+			return true
+		}
+	}
+	return false
+}
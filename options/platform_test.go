@@ -0,0 +1,72 @@
+package options
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"testing"
+)
+
+func TestLookupPlatform(t *testing.T) {
+	testCases := map[string]string{
+		"amd64":   constants.X64,
+		"x86_64":  constants.X64,
+		"386":     constants.X86,
+		"i686":    constants.X86,
+		"arm64":   constants.ARMV8,
+		"aarch64": constants.ARMV8,
+		"arm":     constants.ARMV7,
+		"armv7l":  constants.ARMV7,
+		"armhf":   constants.ARMV7,
+	}
+
+	for arch, expected := range testCases {
+		platform, err := LookupPlatform("linux", arch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if platform.ReleaseArch != expected {
+			t.Fatalf("%s should map to release arch %s, got %s", arch, expected, platform.ReleaseArch)
+		}
+	}
+
+	if _, err := LookupPlatform("linux", "mips"); err == nil {
+		t.Fatal("Unknown architectures should be rejected")
+	}
+	if _, err := LookupPlatform("windows", "amd64"); err == nil {
+		t.Fatal("Unknown os should be rejected")
+	}
+}
+
+func TestEveryPlatformHasCpuBuild(t *testing.T) {
+	cpu, _ := LookupAccelerator("")
+	for _, platform := range Platforms {
+		if !cpu.SupportsReleaseArch(platform.ReleaseArch) {
+			t.Fatalf("Release arch %s has no CPU agent build", platform.ReleaseArch)
+		}
+	}
+}
+
+func TestPlatformCanRun(t *testing.T) {
+	testCases := []struct {
+		hostArch    string
+		releaseArch string
+		expected    bool
+	}{
+		{"x86_64", constants.X64, true},
+		{"x86_64", constants.X86, true},
+		{"x86_64", constants.ARMV8, false},
+		{"aarch64", constants.ARMV8, true},
+		{"aarch64", constants.ARMV7, true},
+		{"armv7l", constants.ARMV8, false},
+		{"i686", constants.X64, false},
+	}
+
+	for _, testCase := range testCases {
+		platform, err := LookupPlatform("linux", testCase.hostArch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if platform.CanRun(testCase.releaseArch) != testCase.expected {
+			t.Errorf("%s host running %s should be %t", testCase.hostArch, testCase.releaseArch, testCase.expected)
+		}
+	}
+}
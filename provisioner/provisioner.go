@@ -0,0 +1,260 @@
+// Package provisioner sets up SageMaker Edge Manager devices. It creates the fleet
+// resources, registers the device, installs the agent and writes its configuration,
+// and can report the status of a device or remove it again.
+package provisioner
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/aws"
+	"aws-sagemaker-edge-quick-device-setup/cache"
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// Options describe the device to provision. Empty optional fields get the same defaults
+// as the command line.
+type Options struct {
+	DeviceFleet string
+	DeviceName  string
+	// Account is required by Setup, it names the fleet bucket and policy resources
+	Account string
+	Region  string
+	// IotThingType defaults to Sagemaker_<DeviceFleet>
+	IotThingType string
+	// IotThingName defaults to Sagemaker_<DeviceName>
+	IotThingName string
+	// DeviceFleetRole defaults to Sagemaker_<DeviceFleet>_role
+	DeviceFleetRole string
+	// DeviceFleetBucket defaults to sagemaker-edgemanager-<Account>
+	DeviceFleetBucket string
+	S3FolderPrefix    string
+	TargetPlatform    options.TargetPlatform
+	ReleaseStore      options.ReleaseStore
+	// AgentDirectory is where the agent and its configuration are written
+	AgentDirectory string
+	// DeviceInstallRoot is the agent directory on the device if it differs from AgentDirectory
+	DeviceInstallRoot string
+	CacheDirectory    string
+	EnableDB          bool
+	EnableDeployment  bool
+	RootCA            string
+	DownloadRootCA    bool
+	Http              options.HttpOptions
+	// AgentConfigOverrides are merged over the generated agent config
+	AgentConfigOverrides map[string]interface{}
+	Capture              options.CaptureOptions
+	Service              options.ServiceOptions
+	Verify               bool
+	VerifyTimeout        time.Duration
+	// StepTimeout bounds each setup step, zero leaves steps to the context of Setup
+	StepTimeout time.Duration
+	// StateFile records the outcome of Setup if set
+	StateFile string
+	// Bundle provisions a device other than this machine, the agent environment, service
+	// and verification are left to the device
+	Bundle bool
+	// DevicePreflight checks this machine before any resource is created
+	DevicePreflight bool
+	// Logger receives the progress of every step and the messages of the AWS calls,
+	// retries and downloads made for it, nil discards it
+	Logger *log.Logger
+}
+
+// Clients are the AWS clients used by the provisioner.
+type Clients struct {
+	Iam       aws.IamClient
+	Iot       aws.IotClient
+	Sagemaker aws.SagemakerClient
+	S3        aws.S3Client
+	// ReleaseStore downloads the agent, it defaults to S3
+	ReleaseStore aws.S3Client
+	// HTTP downloads root CAs and checks the clock, it defaults to http.DefaultClient
+	HTTP *http.Client
+}
+
+// Provisioner runs the setup steps for one device.
+type Provisioner struct {
+	opts        Options
+	clients     Clients
+	objectCache *cache.Cache
+	logger      *log.Logger
+}
+
+// StepError reports the setup step that failed.
+type StepError struct {
+	Step string
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// New validates opts, applies their defaults and returns a provisioner using clients.
+func New(opts Options, clients Clients) (*Provisioner, error) {
+	if opts.DeviceFleet == "" || opts.DeviceName == "" {
+		return nil, errors.New("device fleet and device name are required")
+	}
+	if opts.Region == "" {
+		return nil, errors.New("region is required")
+	}
+	if opts.AgentDirectory == "" {
+		return nil, errors.New("agent directory is required")
+	}
+	if clients.Iam == nil || clients.Iot == nil || clients.Sagemaker == nil || clients.S3 == nil {
+		return nil, errors.New("iam, iot, sagemaker and s3 clients are required")
+	}
+	if err := opts.TargetPlatform.Check(); err != nil {
+		return nil, err
+	}
+	if opts.EnableDeployment && !opts.EnableDB {
+		return nil, errors.New("deployment requires the DB module")
+	}
+	if opts.Service.Enable && !opts.Service.Install {
+		return nil, errors.New("enabling the agent service requires installing it")
+	}
+
+	if opts.IotThingType == "" {
+		opts.IotThingType = fmt.Sprintf("Sagemaker_%s", opts.DeviceFleet)
+	}
+	if opts.IotThingName == "" {
+		opts.IotThingName = fmt.Sprintf("Sagemaker_%s", opts.DeviceName)
+	}
+	if opts.DeviceFleetRole == "" {
+		opts.DeviceFleetRole = fmt.Sprintf("Sagemaker_%s_role", opts.DeviceFleet)
+	}
+	if opts.S3FolderPrefix == "" {
+		opts.S3FolderPrefix = "demo"
+	}
+	if opts.ReleaseStore.BucketTemplate == "" {
+		opts.ReleaseStore.BucketTemplate = "sagemaker-edge-release-store-{region}-{os}-{arch}"
+	}
+	if opts.ReleaseStore.Region == "" {
+		opts.ReleaseStore.Region = "us-west-2"
+	}
+	if opts.ReleaseStore.Prefix == "" {
+		opts.ReleaseStore.Prefix = "Releases/"
+	}
+	if opts.CacheDirectory == "" {
+		opts.CacheDirectory = cache.DefaultDir()
+	}
+	if opts.RootCA == "" {
+		opts.RootCA = "auto"
+	}
+	if opts.Capture.Destination == "" {
+		opts.Capture = options.CaptureOptions{Destination: constants.CAPTURE_DESTINATION_CLOUD, BatchSize: 1, BufferSize: 2, PushPeriodSeconds: 5}
+	}
+	if opts.Capture.Destination == constants.CAPTURE_DESTINATION_DISK && opts.Capture.DiskPath == "" {
+		opts.Capture.DiskPath = filepath.Join(opts.deviceAgentDirectory(), "capture_data")
+	}
+	if opts.VerifyTimeout == 0 {
+		opts.VerifyTimeout = 30 * time.Second
+	}
+
+	if err := aws.ValidateNames(opts.cliArgs()); err != nil {
+		return nil, err
+	}
+
+	if clients.ReleaseStore == nil {
+		clients.ReleaseStore = clients.S3
+	}
+	if clients.HTTP == nil {
+		clients.HTTP = http.DefaultClient
+	}
+
+	objectCache, err := cache.New(opts.CacheDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize download cache: %w", err)
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.New(ioutil.Discard, "", 0)
+	}
+	return &Provisioner{opts: opts, clients: clients, objectCache: objectCache, logger: logger}, nil
+}
+
+// Options returns the options of the provisioner with their defaults applied.
+func (p *Provisioner) Options() Options {
+	return p.opts
+}
+
+// OptionsFromCliArgs maps parsed command line arguments to provisioner options.
+func OptionsFromCliArgs(cliArgs *cli.CliArgs) Options {
+	return Options{
+		DeviceFleet:       cliArgs.DeviceFleet,
+		DeviceName:        cliArgs.DeviceName,
+		Account:           cliArgs.Account,
+		Region:            cliArgs.Region,
+		IotThingType:      cliArgs.IotThingType,
+		IotThingName:      cliArgs.IotThingName,
+		DeviceFleetRole:   cliArgs.DeviceFleetRole,
+		DeviceFleetBucket: cliArgs.DeviceFleetBucket,
+		S3FolderPrefix:    cliArgs.S3FolderPrefix,
+		TargetPlatform:    cliArgs.TargetPlatform,
+		ReleaseStore:      cliArgs.ReleaseStore,
+		AgentDirectory:    cliArgs.AgentDirectory,
+		DeviceInstallRoot: cliArgs.DeviceInstallRoot,
+		CacheDirectory:    cliArgs.CacheDirectory,
+		EnableDB:          cliArgs.EnableDB,
+		EnableDeployment:  cliArgs.EnableDeployment,
+		RootCA:            cliArgs.RootCA,
+		DownloadRootCA:    cliArgs.DownloadRootCA,
+		Http:              cliArgs.Http,
+		Capture:           cliArgs.Capture,
+		Service:           cliArgs.Service,
+		Verify:            cliArgs.Verify,
+		VerifyTimeout:     cliArgs.VerifyTimeout,
+		StepTimeout:       cliArgs.StepTimeout,
+		StateFile:         cliArgs.StateFile,
+		Bundle:            cliArgs.Command == cli.BundleCommand,
+		DevicePreflight:   cliArgs.Command != cli.BundleCommand && !cliArgs.SkipDevicePreflight,
+	}
+}
+
+func (opts *Options) deviceAgentDirectory() string {
+	if opts.DeviceInstallRoot != "" {
+		return opts.DeviceInstallRoot
+	}
+	return opts.AgentDirectory
+}
+
+// cliArgs maps the options to the arguments taken by the aws and common packages.
+func (opts *Options) cliArgs() *cli.CliArgs {
+	return &cli.CliArgs{
+		DeviceFleet:       opts.DeviceFleet,
+		DeviceName:        opts.DeviceName,
+		IotThingType:      opts.IotThingType,
+		IotThingName:      opts.IotThingName,
+		DeviceFleetRole:   opts.DeviceFleetRole,
+		DeviceFleetBucket: opts.DeviceFleetBucket,
+		Account:           opts.Account,
+		Region:            opts.Region,
+		AgentDirectory:    opts.AgentDirectory,
+		S3FolderPrefix:    opts.S3FolderPrefix,
+		TargetPlatform:    opts.TargetPlatform,
+		EnableDB:          opts.EnableDB,
+		EnableDeployment:  opts.EnableDeployment,
+		ReleaseStore:      opts.ReleaseStore,
+		CacheDirectory:    opts.CacheDirectory,
+		RootCA:            opts.RootCA,
+		DownloadRootCA:    opts.DownloadRootCA,
+		Http:              opts.Http,
+		Service:           opts.Service,
+		Capture:           opts.Capture,
+		Verify:            opts.Verify,
+		VerifyTimeout:     opts.VerifyTimeout,
+		DeviceInstallRoot: opts.DeviceInstallRoot,
+	}
+}
@@ -0,0 +1,544 @@
+package provisioner
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/aws"
+	"aws-sagemaker-edge-quick-device-setup/options"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/iot"
+	iotTypes "github.com/aws/aws-sdk-go-v2/service/iot/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sagemaker"
+	smTypes "github.com/aws/aws-sdk-go-v2/service/sagemaker/types"
+)
+
+type mockIam struct{}
+
+var iamMockCreateRole func(ctx context.Context, params *iam.CreateRoleInput, optFns ...func(*iam.Options)) (*iam.CreateRoleOutput, error)
+var iamMockGetRole func(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error)
+var iamMockListAttachedRolePolicies func(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error)
+var iamMockAttachRolePolicy func(ctx context.Context, params *iam.AttachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.AttachRolePolicyOutput, error)
+var iamMockGetPolicy func(ctx context.Context, params *iam.GetPolicyInput, optFns ...func(*iam.Options)) (*iam.GetPolicyOutput, error)
+var iamMockCreatePolicy func(ctx context.Context, params *iam.CreatePolicyInput, optFns ...func(*iam.Options)) (*iam.CreatePolicyOutput, error)
+
+func (client mockIam) CreateRole(ctx context.Context, params *iam.CreateRoleInput, optFns ...func(*iam.Options)) (*iam.CreateRoleOutput, error) {
+	return iamMockCreateRole(ctx, params, optFns...)
+}
+
+func (client mockIam) GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	return iamMockGetRole(ctx, params, optFns...)
+}
+
+func (client mockIam) ListAttachedRolePolicies(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error) {
+	return iamMockListAttachedRolePolicies(ctx, params, optFns...)
+}
+
+func (client mockIam) AttachRolePolicy(ctx context.Context, params *iam.AttachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.AttachRolePolicyOutput, error) {
+	return iamMockAttachRolePolicy(ctx, params, optFns...)
+}
+
+func (client mockIam) GetPolicy(ctx context.Context, params *iam.GetPolicyInput, optFns ...func(*iam.Options)) (*iam.GetPolicyOutput, error) {
+	return iamMockGetPolicy(ctx, params, optFns...)
+}
+
+func (client mockIam) CreatePolicy(ctx context.Context, params *iam.CreatePolicyInput, optFns ...func(*iam.Options)) (*iam.CreatePolicyOutput, error) {
+	return iamMockCreatePolicy(ctx, params, optFns...)
+}
+
+type mockIot struct{}
+
+var iotMockDescribeThingType func(ctx context.Context, params *iot.DescribeThingTypeInput, optFns ...func(*iot.Options)) (*iot.DescribeThingTypeOutput, error)
+var iotMockCreateThingType func(ctx context.Context, params *iot.CreateThingTypeInput, optFns ...func(*iot.Options)) (*iot.CreateThingTypeOutput, error)
+var iotMockDescribeThing func(ctx context.Context, params *iot.DescribeThingInput, optFns ...func(*iot.Options)) (*iot.DescribeThingOutput, error)
+var iotMockCreateThing func(ctx context.Context, params *iot.CreateThingInput, optFns ...func(*iot.Options)) (*iot.CreateThingOutput, error)
+var iotMockDeleteThing func(ctx context.Context, params *iot.DeleteThingInput, optFns ...func(*iot.Options)) (*iot.DeleteThingOutput, error)
+var iotMockCreateKeysAndCertificate func(ctx context.Context, params *iot.CreateKeysAndCertificateInput, optFns ...func(*iot.Options)) (*iot.CreateKeysAndCertificateOutput, error)
+var iotMockDescribeCertificate func(ctx context.Context, params *iot.DescribeCertificateInput, optFns ...func(*iot.Options)) (*iot.DescribeCertificateOutput, error)
+var iotMockUpdateCertificate func(ctx context.Context, params *iot.UpdateCertificateInput, optFns ...func(*iot.Options)) (*iot.UpdateCertificateOutput, error)
+var iotMockDeleteCertificate func(ctx context.Context, params *iot.DeleteCertificateInput, optFns ...func(*iot.Options)) (*iot.DeleteCertificateOutput, error)
+var iotMockDescribeEndpoint func(ctx context.Context, params *iot.DescribeEndpointInput, optFns ...func(*iot.Options)) (*iot.DescribeEndpointOutput, error)
+var iotMockAttachThingPrincipal func(ctx context.Context, params *iot.AttachThingPrincipalInput, optFns ...func(*iot.Options)) (*iot.AttachThingPrincipalOutput, error)
+var iotMockDetachThingPrincipal func(ctx context.Context, params *iot.DetachThingPrincipalInput, optFns ...func(*iot.Options)) (*iot.DetachThingPrincipalOutput, error)
+var iotMockListThingPrincipals func(ctx context.Context, params *iot.ListThingPrincipalsInput, optFns ...func(*iot.Options)) (*iot.ListThingPrincipalsOutput, error)
+var iotMockCreatePolicy func(ctx context.Context, params *iot.CreatePolicyInput, optFns ...func(*iot.Options)) (*iot.CreatePolicyOutput, error)
+var iotMockAttachPolicy func(ctx context.Context, params *iot.AttachPolicyInput, optFns ...func(*iot.Options)) (*iot.AttachPolicyOutput, error)
+var iotMockDetachPolicy func(ctx context.Context, params *iot.DetachPolicyInput, optFns ...func(*iot.Options)) (*iot.DetachPolicyOutput, error)
+var iotMockDeletePolicy func(ctx context.Context, params *iot.DeletePolicyInput, optFns ...func(*iot.Options)) (*iot.DeletePolicyOutput, error)
+var iotMockListAttachedPolicies func(ctx context.Context, params *iot.ListAttachedPoliciesInput, optFns ...func(*iot.Options)) (*iot.ListAttachedPoliciesOutput, error)
+
+func (client mockIot) DescribeThingType(ctx context.Context, params *iot.DescribeThingTypeInput, optFns ...func(*iot.Options)) (*iot.DescribeThingTypeOutput, error) {
+	return iotMockDescribeThingType(ctx, params, optFns...)
+}
+
+func (client mockIot) CreateThingType(ctx context.Context, params *iot.CreateThingTypeInput, optFns ...func(*iot.Options)) (*iot.CreateThingTypeOutput, error) {
+	return iotMockCreateThingType(ctx, params, optFns...)
+}
+
+func (client mockIot) DescribeThing(ctx context.Context, params *iot.DescribeThingInput, optFns ...func(*iot.Options)) (*iot.DescribeThingOutput, error) {
+	return iotMockDescribeThing(ctx, params, optFns...)
+}
+
+func (client mockIot) CreateThing(ctx context.Context, params *iot.CreateThingInput, optFns ...func(*iot.Options)) (*iot.CreateThingOutput, error) {
+	return iotMockCreateThing(ctx, params, optFns...)
+}
+
+func (client mockIot) DeleteThing(ctx context.Context, params *iot.DeleteThingInput, optFns ...func(*iot.Options)) (*iot.DeleteThingOutput, error) {
+	return iotMockDeleteThing(ctx, params, optFns...)
+}
+
+func (client mockIot) CreateKeysAndCertificate(ctx context.Context, params *iot.CreateKeysAndCertificateInput, optFns ...func(*iot.Options)) (*iot.CreateKeysAndCertificateOutput, error) {
+	return iotMockCreateKeysAndCertificate(ctx, params, optFns...)
+}
+
+func (client mockIot) DescribeCertificate(ctx context.Context, params *iot.DescribeCertificateInput, optFns ...func(*iot.Options)) (*iot.DescribeCertificateOutput, error) {
+	return iotMockDescribeCertificate(ctx, params, optFns...)
+}
+
+func (client mockIot) UpdateCertificate(ctx context.Context, params *iot.UpdateCertificateInput, optFns ...func(*iot.Options)) (*iot.UpdateCertificateOutput, error) {
+	return iotMockUpdateCertificate(ctx, params, optFns...)
+}
+
+func (client mockIot) DeleteCertificate(ctx context.Context, params *iot.DeleteCertificateInput, optFns ...func(*iot.Options)) (*iot.DeleteCertificateOutput, error) {
+	return iotMockDeleteCertificate(ctx, params, optFns...)
+}
+
+func (client mockIot) DescribeEndpoint(ctx context.Context, params *iot.DescribeEndpointInput, optFns ...func(*iot.Options)) (*iot.DescribeEndpointOutput, error) {
+	return iotMockDescribeEndpoint(ctx, params, optFns...)
+}
+
+func (client mockIot) AttachThingPrincipal(ctx context.Context, params *iot.AttachThingPrincipalInput, optFns ...func(*iot.Options)) (*iot.AttachThingPrincipalOutput, error) {
+	return iotMockAttachThingPrincipal(ctx, params, optFns...)
+}
+
+func (client mockIot) DetachThingPrincipal(ctx context.Context, params *iot.DetachThingPrincipalInput, optFns ...func(*iot.Options)) (*iot.DetachThingPrincipalOutput, error) {
+	return iotMockDetachThingPrincipal(ctx, params, optFns...)
+}
+
+func (client mockIot) ListThingPrincipals(ctx context.Context, params *iot.ListThingPrincipalsInput, optFns ...func(*iot.Options)) (*iot.ListThingPrincipalsOutput, error) {
+	return iotMockListThingPrincipals(ctx, params, optFns...)
+}
+
+func (client mockIot) CreatePolicy(ctx context.Context, params *iot.CreatePolicyInput, optFns ...func(*iot.Options)) (*iot.CreatePolicyOutput, error) {
+	return iotMockCreatePolicy(ctx, params, optFns...)
+}
+
+func (client mockIot) AttachPolicy(ctx context.Context, params *iot.AttachPolicyInput, optFns ...func(*iot.Options)) (*iot.AttachPolicyOutput, error) {
+	return iotMockAttachPolicy(ctx, params, optFns...)
+}
+
+func (client mockIot) DetachPolicy(ctx context.Context, params *iot.DetachPolicyInput, optFns ...func(*iot.Options)) (*iot.DetachPolicyOutput, error) {
+	return iotMockDetachPolicy(ctx, params, optFns...)
+}
+
+func (client mockIot) DeletePolicy(ctx context.Context, params *iot.DeletePolicyInput, optFns ...func(*iot.Options)) (*iot.DeletePolicyOutput, error) {
+	return iotMockDeletePolicy(ctx, params, optFns...)
+}
+
+func (client mockIot) ListAttachedPolicies(ctx context.Context, params *iot.ListAttachedPoliciesInput, optFns ...func(*iot.Options)) (*iot.ListAttachedPoliciesOutput, error) {
+	return iotMockListAttachedPolicies(ctx, params, optFns...)
+}
+
+type mockSagemaker struct{}
+
+var smMockDescribeDeviceFleet func(ctx context.Context, params *sagemaker.DescribeDeviceFleetInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DescribeDeviceFleetOutput, error)
+var smMockCreateDeviceFleet func(ctx context.Context, params *sagemaker.CreateDeviceFleetInput, optFns ...func(*sagemaker.Options)) (*sagemaker.CreateDeviceFleetOutput, error)
+var smMockDescribeDevice func(ctx context.Context, params *sagemaker.DescribeDeviceInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DescribeDeviceOutput, error)
+var smMockRegisterDevices func(ctx context.Context, params *sagemaker.RegisterDevicesInput, optFns ...func(*sagemaker.Options)) (*sagemaker.RegisterDevicesOutput, error)
+var smMockDeregisterDevices func(ctx context.Context, params *sagemaker.DeregisterDevicesInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DeregisterDevicesOutput, error)
+
+func (client mockSagemaker) DescribeDeviceFleet(ctx context.Context, params *sagemaker.DescribeDeviceFleetInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DescribeDeviceFleetOutput, error) {
+	return smMockDescribeDeviceFleet(ctx, params, optFns...)
+}
+
+func (client mockSagemaker) CreateDeviceFleet(ctx context.Context, params *sagemaker.CreateDeviceFleetInput, optFns ...func(*sagemaker.Options)) (*sagemaker.CreateDeviceFleetOutput, error) {
+	return smMockCreateDeviceFleet(ctx, params, optFns...)
+}
+
+func (client mockSagemaker) DescribeDevice(ctx context.Context, params *sagemaker.DescribeDeviceInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DescribeDeviceOutput, error) {
+	return smMockDescribeDevice(ctx, params, optFns...)
+}
+
+func (client mockSagemaker) RegisterDevices(ctx context.Context, params *sagemaker.RegisterDevicesInput, optFns ...func(*sagemaker.Options)) (*sagemaker.RegisterDevicesOutput, error) {
+	return smMockRegisterDevices(ctx, params, optFns...)
+}
+
+func (client mockSagemaker) DeregisterDevices(ctx context.Context, params *sagemaker.DeregisterDevicesInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DeregisterDevicesOutput, error) {
+	return smMockDeregisterDevices(ctx, params, optFns...)
+}
+
+type mockS3 struct{}
+
+var s3MockCreateBucket func(ctx context.Context, params *s3.CreateBucketInput, optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error)
+var s3MockListObjects func(ctx context.Context, params *s3.ListObjectsInput, optFns ...func(*s3.Options)) (*s3.ListObjectsOutput, error)
+var s3MockGetObject func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+var s3MockHeadObject func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+
+func (client mockS3) CreateBucket(ctx context.Context, params *s3.CreateBucketInput, optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error) {
+	return s3MockCreateBucket(ctx, params, optFns...)
+}
+
+func (client mockS3) ListObjects(ctx context.Context, params *s3.ListObjectsInput, optFns ...func(*s3.Options)) (*s3.ListObjectsOutput, error) {
+	return s3MockListObjects(ctx, params, optFns...)
+}
+
+func (client mockS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return s3MockGetObject(ctx, params, optFns...)
+}
+
+func (client mockS3) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return s3MockHeadObject(ctx, params, optFns...)
+}
+
+func testClients() Clients {
+	return Clients{Iam: mockIam{}, Iot: mockIot{}, Sagemaker: mockSagemaker{}, S3: mockS3{}}
+}
+
+func testOptions(t *testing.T) Options {
+	return Options{
+		DeviceFleet:    "dummyfleet",
+		DeviceName:     "dummydevice",
+		Account:        "012345678912",
+		Region:         "us-west-2",
+		TargetPlatform: options.TargetPlatform{Os: "linux", Arch: "x64"},
+		AgentDirectory: t.TempDir(),
+		CacheDirectory: t.TempDir(),
+	}
+}
+
+func TestNew(t *testing.T) {
+	p, err := New(testOptions(t), testClients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := p.Options()
+	if opts.IotThingType != "Sagemaker_dummyfleet" || opts.IotThingName != "Sagemaker_dummydevice" || opts.DeviceFleetRole != "Sagemaker_dummyfleet_role" {
+		t.Errorf("Unexpected generated names %s %s %s", opts.IotThingType, opts.IotThingName, opts.DeviceFleetRole)
+	}
+	if opts.ReleaseStore.BucketName("linux", "x64", "") != "sagemaker-edge-release-store-us-west-2-linux-x64" {
+		t.Errorf("Unexpected release store %+v", opts.ReleaseStore)
+	}
+
+	invalid := testOptions(t)
+	invalid.DeviceFleet = ""
+	if _, err := New(invalid, testClients()); err == nil {
+		t.Error("Missing fleet should be rejected")
+	}
+
+	invalid = testOptions(t)
+	invalid.TargetPlatform.Arch = "sparc"
+	if _, err := New(invalid, testClients()); err == nil {
+		t.Error("Unknown architecture should be rejected")
+	}
+
+	if _, err := New(testOptions(t), Clients{Iam: mockIam{}}); err == nil {
+		t.Error("Missing clients should be rejected")
+	}
+}
+
+// mockFleetResources lets steps 1 to 8 succeed with new resources.
+func mockFleetResources(calls *[]string) {
+	s3MockCreateBucket = func(ctx context.Context, params *s3.CreateBucketInput, optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error) {
+		*calls = append(*calls, "CreateBucket "+*params.Bucket)
+		return &s3.CreateBucketOutput{}, nil
+	}
+	iamMockGetPolicy = func(ctx context.Context, params *iam.GetPolicyInput, optFns ...func(*iam.Options)) (*iam.GetPolicyOutput, error) {
+		return nil, &iamTypes.NoSuchEntityException{}
+	}
+	iamMockCreatePolicy = func(ctx context.Context, params *iam.CreatePolicyInput, optFns ...func(*iam.Options)) (*iam.CreatePolicyOutput, error) {
+		*calls = append(*calls, "CreatePolicy "+*params.PolicyName)
+		arn := "arn:aws:iam::012345678912:policy/" + *params.PolicyName
+		return &iam.CreatePolicyOutput{Policy: &iamTypes.Policy{PolicyName: params.PolicyName, Arn: &arn}}, nil
+	}
+	iamMockGetRole = func(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+		arn := "arn:aws:iam::012345678912:role/" + *params.RoleName
+		return &iam.GetRoleOutput{Role: &iamTypes.Role{RoleName: params.RoleName, Arn: &arn}}, nil
+	}
+	iamMockListAttachedRolePolicies = func(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error) {
+		return &iam.ListAttachedRolePoliciesOutput{}, nil
+	}
+	iamMockAttachRolePolicy = func(ctx context.Context, params *iam.AttachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.AttachRolePolicyOutput, error) {
+		*calls = append(*calls, "AttachRolePolicy "+*params.PolicyArn)
+		return &iam.AttachRolePolicyOutput{}, nil
+	}
+	iotMockDescribeThingType = func(ctx context.Context, params *iot.DescribeThingTypeInput, optFns ...func(*iot.Options)) (*iot.DescribeThingTypeOutput, error) {
+		return &iot.DescribeThingTypeOutput{ThingTypeName: params.ThingTypeName}, nil
+	}
+	iotMockDescribeThing = func(ctx context.Context, params *iot.DescribeThingInput, optFns ...func(*iot.Options)) (*iot.DescribeThingOutput, error) {
+		return nil, &iotTypes.ResourceNotFoundException{}
+	}
+	iotMockCreateThing = func(ctx context.Context, params *iot.CreateThingInput, optFns ...func(*iot.Options)) (*iot.CreateThingOutput, error) {
+		*calls = append(*calls, "CreateThing "+*params.ThingName)
+		arn := "arn:aws:iot:us-west-2:012345678912:thing/" + *params.ThingName
+		return &iot.CreateThingOutput{ThingName: params.ThingName, ThingArn: &arn}, nil
+	}
+	smMockDescribeDeviceFleet = func(ctx context.Context, params *sagemaker.DescribeDeviceFleetInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DescribeDeviceFleetOutput, error) {
+		return nil, &smTypes.ResourceNotFound{}
+	}
+	smMockCreateDeviceFleet = func(ctx context.Context, params *sagemaker.CreateDeviceFleetInput, optFns ...func(*sagemaker.Options)) (*sagemaker.CreateDeviceFleetOutput, error) {
+		*calls = append(*calls, "CreateDeviceFleet "+*params.DeviceFleetName)
+		return &sagemaker.CreateDeviceFleetOutput{}, nil
+	}
+	smMockDescribeDevice = func(ctx context.Context, params *sagemaker.DescribeDeviceInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DescribeDeviceOutput, error) {
+		return nil, &smTypes.ResourceNotFound{}
+	}
+	smMockRegisterDevices = func(ctx context.Context, params *sagemaker.RegisterDevicesInput, optFns ...func(*sagemaker.Options)) (*sagemaker.RegisterDevicesOutput, error) {
+		*calls = append(*calls, "RegisterDevices "+*params.Devices[0].DeviceName)
+		return &sagemaker.RegisterDevicesOutput{}, nil
+	}
+}
+
+func TestSetupStopsAtFailedStep(t *testing.T) {
+	fleetCreationDelay = 0
+	calls := make([]string, 0)
+	mockFleetResources(&calls)
+	s3MockListObjects = func(ctx context.Context, params *s3.ListObjectsInput, optFns ...func(*s3.Options)) (*s3.ListObjectsOutput, error) {
+		return nil, errors.New("AccessDenied")
+	}
+
+	p, err := New(testOptions(t), testClients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := p.Setup(context.Background())
+
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) || stepErr.Step != "Step-9" {
+		t.Fatalf("Expected Step-9 to fail, got %v", err)
+	}
+	if fmt.Sprint(result.Steps) != "[Step-1 Step-2 Step-3 Step-4 Step-5 Step-6 Step-7 Step-8]" {
+		t.Errorf("Unexpected completed steps %v", result.Steps)
+	}
+	if result.DeviceFleetBucket != aws.DefaultDeviceFleetBucket("012345678912") || result.RoleArn != "arn:aws:iam::012345678912:role/Sagemaker_dummyfleet_role" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	expected := []string{
+		"CreateBucket sagemaker-edgemanager-012345678912",
+		"CreatePolicy dummyfleet-policy",
+		"CreatePolicy dummyfleet-sagemaker-edgemanager-012345678912-policy",
+		"AttachRolePolicy arn:aws:iam::012345678912:policy/dummyfleet-policy",
+		"AttachRolePolicy arn:aws:iam::012345678912:policy/dummyfleet-sagemaker-edgemanager-012345678912-policy",
+		"CreateThing Sagemaker_dummydevice",
+		"CreateDeviceFleet dummyfleet",
+		"RegisterDevices dummydevice",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Errorf("Expected calls %v, got %v", expected, calls)
+	}
+}
+
+func TestSetupLogger(t *testing.T) {
+	fleetCreationDelay = 0
+	mockFleetResources(&[]string{})
+	s3MockListObjects = func(ctx context.Context, params *s3.ListObjectsInput, optFns ...func(*s3.Options)) (*s3.ListObjectsOutput, error) {
+		return nil, errors.New("AccessDenied")
+	}
+
+	standard := &bytes.Buffer{}
+	log.SetOutput(standard)
+	defer log.SetOutput(os.Stderr)
+
+	p, _ := New(testOptions(t), testClients())
+	p.Setup(context.Background())
+	if standard.Len() != 0 {
+		t.Errorf("Without a logger nothing should be logged, got %s", standard)
+	}
+
+	custom := &bytes.Buffer{}
+	opts := testOptions(t)
+	opts.Logger = log.New(custom, "", 0)
+	p, _ = New(opts, testClients())
+	p.Setup(context.Background())
+	if !strings.Contains(custom.String(), "Attaching policy dummyfleet-policy") {
+		t.Errorf("Messages of the aws calls should go to the logger, got %s", custom)
+	}
+	if standard.Len() != 0 {
+		t.Errorf("Nothing should be logged to the standard logger, got %s", standard)
+	}
+}
+
+func TestSetupCancelled(t *testing.T) {
+	calls := make([]string, 0)
+	mockFleetResources(&calls)
+
+	p, err := New(testOptions(t), testClients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := p.Setup(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected cancellation, got %v", err)
+	}
+	if len(result.Steps) != 0 || len(calls) != 0 {
+		t.Errorf("No step should run after cancellation, got %v %v", result.Steps, calls)
+	}
+}
+
+func TestTeardown(t *testing.T) {
+	certificateArn := "arn:aws:iot:us-west-2:012345678912:cert/abcdef"
+	aliasPolicy := aws.RoleAliasPolicyPrefix + "1650000000"
+	calls := make([]string, 0)
+	record := func(call string) {
+		calls = append(calls, call)
+	}
+
+	smMockDescribeDevice = func(ctx context.Context, params *sagemaker.DescribeDeviceInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DescribeDeviceOutput, error) {
+		return &sagemaker.DescribeDeviceOutput{DeviceName: params.DeviceName}, nil
+	}
+	smMockDeregisterDevices = func(ctx context.Context, params *sagemaker.DeregisterDevicesInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DeregisterDevicesOutput, error) {
+		record("DeregisterDevices " + params.DeviceNames[0])
+		return &sagemaker.DeregisterDevicesOutput{}, nil
+	}
+	iotMockDescribeThing = func(ctx context.Context, params *iot.DescribeThingInput, optFns ...func(*iot.Options)) (*iot.DescribeThingOutput, error) {
+		return &iot.DescribeThingOutput{ThingName: params.ThingName}, nil
+	}
+	iotMockListThingPrincipals = func(ctx context.Context, params *iot.ListThingPrincipalsInput, optFns ...func(*iot.Options)) (*iot.ListThingPrincipalsOutput, error) {
+		return &iot.ListThingPrincipalsOutput{Principals: []string{certificateArn}}, nil
+	}
+	iotMockDetachThingPrincipal = func(ctx context.Context, params *iot.DetachThingPrincipalInput, optFns ...func(*iot.Options)) (*iot.DetachThingPrincipalOutput, error) {
+		record("DetachThingPrincipal")
+		return &iot.DetachThingPrincipalOutput{}, nil
+	}
+	iotMockListAttachedPolicies = func(ctx context.Context, params *iot.ListAttachedPoliciesInput, optFns ...func(*iot.Options)) (*iot.ListAttachedPoliciesOutput, error) {
+		return &iot.ListAttachedPoliciesOutput{Policies: []iotTypes.Policy{{PolicyName: &aliasPolicy}}}, nil
+	}
+	iotMockDetachPolicy = func(ctx context.Context, params *iot.DetachPolicyInput, optFns ...func(*iot.Options)) (*iot.DetachPolicyOutput, error) {
+		record("DetachPolicy")
+		return &iot.DetachPolicyOutput{}, nil
+	}
+	iotMockDeletePolicy = func(ctx context.Context, params *iot.DeletePolicyInput, optFns ...func(*iot.Options)) (*iot.DeletePolicyOutput, error) {
+		record("DeletePolicy")
+		return &iot.DeletePolicyOutput{}, nil
+	}
+	iotMockUpdateCertificate = func(ctx context.Context, params *iot.UpdateCertificateInput, optFns ...func(*iot.Options)) (*iot.UpdateCertificateOutput, error) {
+		record("UpdateCertificate")
+		return &iot.UpdateCertificateOutput{}, nil
+	}
+	iotMockDeleteCertificate = func(ctx context.Context, params *iot.DeleteCertificateInput, optFns ...func(*iot.Options)) (*iot.DeleteCertificateOutput, error) {
+		record("DeleteCertificate")
+		return &iot.DeleteCertificateOutput{}, nil
+	}
+	iotMockDeleteThing = func(ctx context.Context, params *iot.DeleteThingInput, optFns ...func(*iot.Options)) (*iot.DeleteThingOutput, error) {
+		record("DeleteThing " + *params.ThingName)
+		return &iot.DeleteThingOutput{}, nil
+	}
+
+	p, err := New(testOptions(t), testClients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := p.Teardown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.DeregisteredDevice || !result.DeletedThing || len(result.DeletedCertificates) != 1 || len(result.DeletedPolicies) != 1 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	expected := "[DeregisterDevices dummydevice DetachThingPrincipal DetachPolicy DeletePolicy UpdateCertificate DeleteCertificate DeleteThing Sagemaker_dummydevice]"
+	if fmt.Sprint(calls) != expected {
+		t.Errorf("Expected calls %s, got %v", expected, calls)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	roleAlias := "arn:aws:iot:us-west-2:012345678912:rolealias/SageMakerEdge-dummyfleet"
+	smMockDescribeDeviceFleet = func(ctx context.Context, params *sagemaker.DescribeDeviceFleetInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DescribeDeviceFleetOutput, error) {
+		return &sagemaker.DescribeDeviceFleetOutput{DeviceFleetName: params.DeviceFleetName, IotRoleAlias: &roleAlias}, nil
+	}
+	smMockDescribeDevice = func(ctx context.Context, params *sagemaker.DescribeDeviceInput, optFns ...func(*sagemaker.Options)) (*sagemaker.DescribeDeviceOutput, error) {
+		return nil, &smTypes.ResourceNotFound{}
+	}
+	iotMockDescribeThing = func(ctx context.Context, params *iot.DescribeThingInput, optFns ...func(*iot.Options)) (*iot.DescribeThingOutput, error) {
+		return nil, &iotTypes.ResourceNotFoundException{}
+	}
+
+	p, err := New(testOptions(t), testClients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := p.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.FleetExists || status.RoleAliasArn != roleAlias || status.DeviceRegistered || status.ThingExists || status.ConfigPath != "" {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
+
+func TestSetupStepTimeout(t *testing.T) {
+	s3MockCreateBucket = func(ctx context.Context, params *s3.CreateBucketInput, optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	opts := testOptions(t)
+	opts.StepTimeout = 10 * time.Millisecond
+	opts.StateFile = filepath.Join(t.TempDir(), "setup_state.json")
+	p, err := New(opts, testClients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Setup(context.Background())
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) || stepErr.Step != "Step-1" || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected Step-1 to time out, got %v", err)
+	}
+
+	state, err := LoadSetupState(opts.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Completed || !state.Interrupted || state.FailedStep != "Step-1" || state.DeviceName != "dummydevice" {
+		t.Errorf("Unexpected state %+v", state)
+	}
+}
+
+func TestRollbackCertificate(t *testing.T) {
+	certificateArn := "arn:aws:iot:us-west-2:012345678912:cert/abcdef"
+	aliasPolicy := aws.RoleAliasPolicyPrefix + "1650000000"
+	deleted := make([]string, 0)
+	iotMockDetachThingPrincipal = func(ctx context.Context, params *iot.DetachThingPrincipalInput, optFns ...func(*iot.Options)) (*iot.DetachThingPrincipalOutput, error) {
+		return &iot.DetachThingPrincipalOutput{}, nil
+	}
+	iotMockListAttachedPolicies = func(ctx context.Context, params *iot.ListAttachedPoliciesInput, optFns ...func(*iot.Options)) (*iot.ListAttachedPoliciesOutput, error) {
+		return &iot.ListAttachedPoliciesOutput{Policies: []iotTypes.Policy{{PolicyName: &aliasPolicy}}}, nil
+	}
+	iotMockDetachPolicy = func(ctx context.Context, params *iot.DetachPolicyInput, optFns ...func(*iot.Options)) (*iot.DetachPolicyOutput, error) {
+		return &iot.DetachPolicyOutput{}, nil
+	}
+	iotMockDeletePolicy = func(ctx context.Context, params *iot.DeletePolicyInput, optFns ...func(*iot.Options)) (*iot.DeletePolicyOutput, error) {
+		return &iot.DeletePolicyOutput{}, nil
+	}
+	iotMockUpdateCertificate = func(ctx context.Context, params *iot.UpdateCertificateInput, optFns ...func(*iot.Options)) (*iot.UpdateCertificateOutput, error) {
+		return &iot.UpdateCertificateOutput{}, nil
+	}
+	iotMockDeleteCertificate = func(ctx context.Context, params *iot.DeleteCertificateInput, optFns ...func(*iot.Options)) (*iot.DeleteCertificateOutput, error) {
+		deleted = append(deleted, *params.CertificateId)
+		return &iot.DeleteCertificateOutput{}, nil
+	}
+
+	p, err := New(testOptions(t), testClients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &SetupResult{CertificateArn: certificateArn, RoleAliasPolicy: aliasPolicy, RolledBack: make([]string, 0)}
+	p.rollbackCertificate(result, p.opts.cliArgs())
+	if fmt.Sprint(deleted) != "[abcdef]" {
+		t.Errorf("Expected certificate abcdef to be deleted, got %v", deleted)
+	}
+	if result.CertificateArn != "" || fmt.Sprint(result.RolledBack) != fmt.Sprint([]string{aliasPolicy, certificateArn}) {
+		t.Errorf("Unexpected result %+v", result)
+	}
+}
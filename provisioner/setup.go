@@ -0,0 +1,385 @@
+package provisioner
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/aws"
+	"aws-sagemaker-edge-quick-device-setup/cli"
+	"aws-sagemaker-edge-quick-device-setup/common"
+	"aws-sagemaker-edge-quick-device-setup/constants"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/iot"
+)
+
+// SetupResult describes the resources and files of a provisioned device.
+type SetupResult struct {
+	DeviceFleetBucket  string
+	RoleArn            string
+	ThingArn           string
+	CertificateArn     string
+	RoleAliasArn       string
+	RoleAliasPolicy    string
+	CredentialEndpoint string
+	// AgentArchive is the cached agent release the agent directory was extracted from
+	AgentArchive string
+	ConfigPath   string
+	// ServiceUnit is the path of the installed agent service, empty without one
+	ServiceUnit string
+	// Steps lists the completed steps in order
+	Steps []string
+	// RolledBack lists the resources deleted again after the setup failed
+	RolledBack []string
+	// Verification is how far Options.Verify checked the agent, empty if it did not run
+	Verification common.AgentVerification
+}
+
+// step runs one setup step unless ctx is done, logging its progress. The step gets
+// StepTimeout to complete.
+func (p *Provisioner) step(ctx context.Context, result *SetupResult, id string, description string, run func(ctx context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return &StepError{Step: id, Err: err}
+	}
+	p.logger.Printf("%s %s...\n", id, description)
+	if p.opts.StepTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.opts.StepTimeout)
+		defer cancel()
+	}
+	if err := run(ctx); err != nil {
+		return &StepError{Step: id, Err: err}
+	}
+	p.logger.Printf("%s Completed.\n", id)
+	result.Steps = append(result.Steps, id)
+	return nil
+}
+
+// CheckPermissions simulates the policies of callerArn for every setup step and returns
+// the denied actions. Release store permissions are skipped unless releaseStore is set,
+// e.g. when the release store is accessed with other credentials.
+func (p *Provisioner) CheckPermissions(ctx context.Context, callerArn string, releaseStore bool) ([]aws.DeniedPermission, error) {
+	ctx = aws.WithLogger(ctx, p.logger)
+	simulator, ok := p.clients.Iam.(aws.IamSimulationClient)
+	if !ok {
+		return nil, errors.New("the iam client cannot simulate policies")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	permissions := aws.RequiredPermissions(p.opts.cliArgs())
+	required := make([]aws.RequiredPermission, 0, len(permissions))
+	for _, permission := range permissions {
+		if releaseStore || !permission.ReleaseStore {
+			required = append(required, permission)
+		}
+	}
+	return aws.SimulatePermissions(ctx, simulator, callerArn, required)
+}
+
+// Preflight checks disk space, write access, architecture and clock of this machine
+// for the latest agent release.
+func (p *Provisioner) Preflight(ctx context.Context) error {
+	ctx = aws.WithLogger(ctx, p.logger)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cliArgs := p.opts.cliArgs()
+	archiveSize, err := common.AgentArchiveSize(ctx, p.clients.ReleaseStore, cliArgs)
+	if err != nil {
+		return err
+	}
+	return common.DevicePreflight(ctx, p.clients.HTTP, cliArgs, archiveSize)
+}
+
+// Setup creates the fleet resources if missing, registers the device, installs the agent
+// and writes its configuration. Existing resources are reused, so Setup can be repeated.
+// Steps completed before a failure are listed in the returned result.
+//
+// Cancelling ctx aborts the running step, including its AWS calls and downloads, and no
+// further step is started. A certificate created by an unfinished setup is deleted again, and the outcome is
+// recorded in StateFile if set.
+func (p *Provisioner) Setup(ctx context.Context) (*SetupResult, error) {
+	ctx = aws.WithLogger(ctx, p.logger)
+	started := time.Now()
+	result := &SetupResult{Steps: make([]string, 0), RolledBack: make([]string, 0)}
+	err := p.setup(ctx, result)
+	if p.opts.StateFile != "" {
+		if stateErr := writeSetupState(p.opts.StateFile, newSetupState(&p.opts, started, result, err)); stateErr != nil {
+			p.logger.Printf("Failed to record setup state in %s. Encountered error %s\n", p.opts.StateFile, stateErr)
+		}
+	}
+	return result, err
+}
+
+func (p *Provisioner) setup(ctx context.Context, result *SetupResult) error {
+	opts := &p.opts
+	if opts.Account == "" {
+		return errors.New("account is required for setup")
+	}
+	cliArgs := opts.cliArgs()
+
+	if opts.DevicePreflight {
+		p.logger.Println("Running device preflight checks...")
+		if err := p.Preflight(ctx); err != nil {
+			return &StepError{Step: "Device preflight", Err: err}
+		}
+		p.logger.Println("Device preflight checks passed.")
+	}
+
+	if !opts.Bundle {
+		// the bundle's install script creates the directories on the device
+		if opts.EnableDB {
+			if err := os.MkdirAll(filepath.Join(opts.AgentDirectory, "local_data"), os.ModePerm); err != nil {
+				return err
+			}
+		}
+		if opts.Capture.Destination == constants.CAPTURE_DESTINATION_DISK {
+			if err := os.MkdirAll(opts.Capture.DiskPath, os.ModePerm); err != nil {
+				return err
+			}
+		}
+	}
+
+	var s3OutputLocation *string
+	if err := p.step(ctx, result, "Step-1", "Creating S3 bucket for storing device fleet data", func(ctx context.Context) error {
+		var err error
+		s3OutputLocation, err = aws.CreateS3Bucket(ctx, p.clients.S3, &cliArgs.DeviceFleetBucket, &cliArgs.Account, &cliArgs.Region)
+		return err
+	}); err != nil {
+		return err
+	}
+	opts.DeviceFleetBucket = *s3OutputLocation
+	result.DeviceFleetBucket = *s3OutputLocation
+
+	var fleetPolicy, bucketPolicy *iamTypes.Policy
+	if err := p.step(ctx, result, "Step-2", "Creating device fleet policy", func(ctx context.Context) error {
+		var err error
+		fleetPolicy, err = aws.CreateDeviceFleetPolicy(ctx, p.clients.Iam, cliArgs)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step(ctx, result, "Step-3", "Creating device fleet bucket policy", func(ctx context.Context) error {
+		var err error
+		bucketPolicy, err = aws.CreateDeviceFleetBucketPolicy(ctx, p.clients.Iam, cliArgs)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	var role *iamTypes.Role
+	if err := p.step(ctx, result, "Step-4", "Creating device fleet role", func(ctx context.Context) error {
+		var err error
+		role, err = aws.CreateDeviceFleetRoleIfNotExists(ctx, p.clients.Iam, &cliArgs.DeviceFleet, &cliArgs.DeviceFleetRole, fleetPolicy, bucketPolicy)
+		return err
+	}); err != nil {
+		return err
+	}
+	result.RoleArn = *role.Arn
+
+	if err := p.step(ctx, result, "Step-5", "Creating iot thing type", func(ctx context.Context) error {
+		_, err := aws.CreateIotThingType(ctx, p.clients.Iot, &cliArgs.IotThingType)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step(ctx, result, "Step-6", "Creating iot thing", func(ctx context.Context) error {
+		thing, err := aws.CreateIotThing(ctx, p.clients.Iot, &cliArgs.IotThingType, &cliArgs.IotThingName)
+		if err == nil && thing.ThingArn != nil {
+			result.ThingArn = *thing.ThingArn
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step(ctx, result, "Step-7", "Creating device fleet", func(ctx context.Context) error {
+		// give IAM time to propagate the new role
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(fleetCreationDelay):
+		}
+		return aws.CreateDeviceFleet(ctx, p.clients.Sagemaker, &cliArgs.DeviceFleet, role, s3OutputLocation)
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step(ctx, result, "Step-8", "Registering device", func(ctx context.Context) error {
+		return aws.RegisterDevice(ctx, p.clients.Sagemaker, &cliArgs.DeviceFleet, &cliArgs.DeviceName, &cliArgs.IotThingName, &cliArgs.TargetPlatform)
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step(ctx, result, "Step-9", "Downloading Agent", func(ctx context.Context) error {
+		var verifyAgent func(string) error
+		if opts.DevicePreflight {
+			verifyAgent = common.CheckAgentBinary
+		}
+		agentArchive, err := common.DownloadAgent(ctx, p.clients.ReleaseStore, p.objectCache, cliArgs, verifyAgent)
+		if err == nil {
+			result.AgentArchive = *agentArchive
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if err := p.step(ctx, result, "Step-10", "Downloading code signing root certificate", func(ctx context.Context) error {
+		return common.DownloadSigningRootCert(ctx, p.clients.ReleaseStore, p.objectCache, cliArgs)
+	}); err != nil {
+		return err
+	}
+
+	var certs *iot.CreateKeysAndCertificateOutput
+	if err := p.step(ctx, result, "Step-11", "Creating iot certificates", func(ctx context.Context) error {
+		var err error
+		certs, err = aws.CreateIOTCertificates(ctx, p.clients.Iot)
+		return err
+	}); err != nil {
+		return err
+	}
+	result.CertificateArn = *certs.CertificateArn
+
+	if err := p.step(ctx, result, "Step-12", "Attaching certificate to thing", func(ctx context.Context) error {
+		return aws.AttachThingToCertificate(ctx, p.clients.Iot, certs.CertificateArn, &cliArgs.IotThingName)
+	}); err != nil {
+		p.rollbackCertificate(result, cliArgs)
+		return err
+	}
+
+	if err := p.step(ctx, result, "Step-13", "Configuring Agent", func(ctx context.Context) error {
+		return p.configureAgent(ctx, cliArgs, certs, result)
+	}); err != nil {
+		p.rollbackCertificate(result, cliArgs)
+		return err
+	}
+
+	if opts.Service.Install && !opts.Bundle {
+		if err := p.step(ctx, result, "Step-14", "Installing agent service", func(ctx context.Context) error {
+			unitPath, err := common.InstallService(cliArgs)
+			if err != nil {
+				return fmt.Errorf("failed to install agent service %s: %w", opts.Service.Name, err)
+			}
+			p.logger.Printf("Agent service unit written to %s\n", unitPath)
+			result.ServiceUnit = unitPath
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	if opts.Verify && !opts.Bundle {
+		if err := p.step(ctx, result, "Step-15", "Verifying agent", func(ctx context.Context) error {
+			verification, err := common.VerifyAgent(ctx, opts.AgentDirectory, opts.VerifyTimeout)
+			if err != nil {
+				return err
+			}
+			result.Verification = verification
+			if verification == common.AgentPartiallyVerified {
+				p.logger.Println("Partial verification only: the agent accepts connections, but the release has no client example to list its models.")
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackTimeout bounds the cleanup after a failed or interrupted setup, which runs
+// after ctx may already be done.
+var rollbackTimeout = 30 * time.Second
+
+// rollbackCertificate deletes the certificate created by a setup that did not get to
+// write the agent config. A new certificate is created on the next run, so it would
+// otherwise stay active without any device holding its key.
+func (p *Provisioner) rollbackCertificate(result *SetupResult, cliArgs *cli.CliArgs) {
+	ctx, cancel := context.WithTimeout(aws.WithLogger(context.Background(), p.logger), rollbackTimeout)
+	defer cancel()
+
+	certificateArn := result.CertificateArn
+	p.logger.Printf("Rolling back certificate %s...\n", certificateArn)
+	policies, _, err := aws.DeleteThingCertificate(ctx, p.clients.Iot, &certificateArn, &cliArgs.IotThingName)
+	result.RolledBack = append(result.RolledBack, policies...)
+	if err != nil {
+		p.logger.Printf("Failed to roll back certificate %s, delete it manually. Encountered error %s\n", certificateArn, err)
+		return
+	}
+	result.RolledBack = append(result.RolledBack, certificateArn)
+	result.CertificateArn = ""
+	result.RoleAliasPolicy = ""
+}
+
+// fleetCreationDelay is the wait before the fleet is created with a new role, shortened in tests.
+var fleetCreationDelay = 5 * time.Second
+
+// configureAgent writes the device certificates, root CA, agent config and environment.
+func (p *Provisioner) configureAgent(ctx context.Context, cliArgs *cli.CliArgs, certs *iot.CreateKeysAndCertificateOutput, result *SetupResult) error {
+	opts := &p.opts
+	certsDirectory := filepath.Join(opts.AgentDirectory, "iot-credentials")
+	if err := aws.WriteCertificatesToFile(certs, &cliArgs.DeviceFleet, &cliArgs.DeviceName, &certsDirectory); err != nil {
+		return fmt.Errorf("failed to write device certificates: %w", err)
+	}
+	rootCA, err := common.SelectRootCA(opts.RootCA, *certs.CertificatePem)
+	if err != nil {
+		return fmt.Errorf("failed to select root CA: %w", err)
+	}
+	rootCAPath, err := common.InstallRootCA(ctx, p.clients.HTTP, rootCA, certsDirectory, opts.DownloadRootCA)
+	if err != nil {
+		return fmt.Errorf("failed to install root CA %s: %w", rootCA.Name, err)
+	}
+
+	config := common.AgentConfig{}
+	configPath := filepath.Join(opts.AgentDirectory, "sagemaker_edge_config.json")
+	config.FromCliArgs(cliArgs)
+	config.AwsCaCertFile = filepath.Join(opts.deviceAgentDirectory(), "iot-credentials", filepath.Base(rootCAPath))
+
+	roleAliasArn, err := aws.GetRoleAliasArn(ctx, p.clients.Sagemaker, &cliArgs.DeviceFleet)
+	if err != nil {
+		return err
+	}
+	result.RoleAliasArn = *roleAliasArn
+	policyName, err := aws.CreateAndAttachRoleAliasPolicy(ctx, p.clients.Iot, roleAliasArn, certs.CertificateArn, &cliArgs.IotThingName)
+	if err != nil {
+		return err
+	}
+	result.RoleAliasPolicy = *policyName
+	roleAliasSplits := strings.Split(*roleAliasArn, "/")
+	credentialEndpoint, err := aws.GetIotCredentialProviderEndpoint(ctx, p.clients.Iot, &roleAliasSplits[1])
+	if err != nil {
+		return err
+	}
+	config.ProviderAwsIotCredEndpoint = *credentialEndpoint
+	result.CredentialEndpoint = *credentialEndpoint
+
+	if err := config.ApplyOverrides(opts.AgentConfigOverrides); err != nil {
+		return fmt.Errorf("failed to apply agent config overrides: %w", err)
+	}
+	if err := config.ValidateCapture(); err != nil {
+		return fmt.Errorf("invalid data capture configuration: %w", err)
+	}
+	if err := config.WriteToJson(&configPath, p.logger); err != nil {
+		return fmt.Errorf("failed to write agent config %s: %w", configPath, err)
+	}
+	result.ConfigPath = configPath
+
+	if !opts.Bundle {
+		// proxy settings of the workstation do not apply to bundled devices
+		environmentPath := filepath.Join(opts.AgentDirectory, "sagemaker_edge_agent.env")
+		if err := common.WriteAgentEnvironment(environmentPath, &opts.Http); err != nil {
+			return fmt.Errorf("failed to write agent environment file: %w", err)
+		}
+	}
+	os.Chmod(filepath.Join(opts.AgentDirectory, "bin", "sagemaker_edge_agent_binary"), 0700)
+	os.Chmod(filepath.Join(opts.AgentDirectory, "bin", "sagemaker_edge_agent_client_example"), 0700)
+	return nil
+}
@@ -0,0 +1,65 @@
+package provisioner
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/common"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"time"
+)
+
+// SetupState is the record of the last setup run written to Options.StateFile.
+type SetupState struct {
+	DeviceFleet string
+	DeviceName  string
+	Started     time.Time
+	Finished    time.Time
+	Completed   bool
+	// Interrupted is set if the setup was cancelled or timed out
+	Interrupted bool
+	FailedStep  string `json:",omitempty"`
+	Error       string `json:",omitempty"`
+	Result      *SetupResult
+}
+
+func newSetupState(opts *Options, started time.Time, result *SetupResult, err error) *SetupState {
+	state := &SetupState{
+		DeviceFleet: opts.DeviceFleet,
+		DeviceName:  opts.DeviceName,
+		Started:     started.UTC(),
+		Finished:    time.Now().UTC(),
+		Completed:   err == nil,
+		Interrupted: errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded),
+		Result:      result,
+	}
+	if err != nil {
+		state.Error = err.Error()
+		var stepErr *StepError
+		if errors.As(err, &stepErr) {
+			state.FailedStep = stepErr.Step
+		}
+	}
+	return state
+}
+
+func writeSetupState(path string, state *SetupState) error {
+	contents, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		return err
+	}
+	return common.WriteFileAtomic(path, append(contents, '\n'), 0644)
+}
+
+// LoadSetupState reads the state recorded by the last setup.
+func LoadSetupState(path string) (*SetupState, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state := &SetupState{}
+	if err := json.Unmarshal(contents, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
@@ -0,0 +1,106 @@
+package provisioner
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/aws"
+	"aws-sagemaker-edge-quick-device-setup/common"
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CertificateStatus is a certificate attached to the device's iot thing.
+type CertificateStatus struct {
+	Arn    string
+	Status string
+}
+
+// StatusResult describes the cloud resources and the local agent of a device.
+type StatusResult struct {
+	FleetExists  bool
+	RoleAliasArn string
+	// DeviceRegistered is set if the device is registered with the fleet
+	DeviceRegistered bool
+	AgentVersion     string
+	LatestHeartbeat  *time.Time
+	ThingExists      bool
+	Certificates     []CertificateStatus
+	// ConfigPath is the agent config in the agent directory, empty if missing
+	ConfigPath string
+	// LastSetup is the state recorded in StateFile, nil without one
+	LastSetup *SetupState
+}
+
+// Status looks up the fleet, device registration, iot thing and certificates of the
+// device and checks for an agent config in the agent directory.
+func (p *Provisioner) Status(ctx context.Context) (*StatusResult, error) {
+	ctx = aws.WithLogger(ctx, p.logger)
+	opts := &p.opts
+	result := &StatusResult{Certificates: make([]CertificateStatus, 0)}
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	fleet, err := aws.GetDeviceFleet(ctx, p.clients.Sagemaker, &opts.DeviceFleet)
+	if err != nil {
+		return result, err
+	}
+	if fleet != nil {
+		result.FleetExists = true
+		if fleet.IotRoleAlias != nil {
+			result.RoleAliasArn = *fleet.IotRoleAlias
+		}
+
+		device, err := aws.GetDevice(ctx, p.clients.Sagemaker, &opts.DeviceFleet, &opts.DeviceName)
+		if err != nil {
+			return result, err
+		}
+		if device != nil {
+			result.DeviceRegistered = true
+			if device.AgentVersion != nil {
+				result.AgentVersion = *device.AgentVersion
+			}
+			result.LatestHeartbeat = device.LatestHeartbeat
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	thing, err := aws.GetIotThing(ctx, p.clients.Iot, &opts.IotThingName)
+	if err != nil {
+		return result, err
+	}
+	if thing != nil {
+		result.ThingExists = true
+		principals, err := aws.ListThingCertificates(ctx, p.clients.Iot, &opts.IotThingName)
+		if err != nil {
+			return result, err
+		}
+		for _, principal := range principals {
+			principal := principal
+			status, err := aws.GetCertificateStatus(ctx, p.clients.Iot, &principal)
+			if err != nil {
+				return result, err
+			}
+			result.Certificates = append(result.Certificates, CertificateStatus{Arn: principal, Status: status})
+		}
+	}
+
+	configPath := filepath.Join(opts.AgentDirectory, "sagemaker_edge_config.json")
+	if _, err := common.LoadAgentConfig(configPath); err == nil {
+		result.ConfigPath = configPath
+	} else if !os.IsNotExist(err) {
+		return result, err
+	}
+
+	if opts.StateFile != "" {
+		state, err := LoadSetupState(opts.StateFile)
+		if err == nil {
+			result.LastSetup = state
+		} else if !os.IsNotExist(err) {
+			return result, err
+		}
+	}
+	return result, nil
+}
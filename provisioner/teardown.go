@@ -0,0 +1,79 @@
+package provisioner
+
+import (
+	"aws-sagemaker-edge-quick-device-setup/aws"
+	"context"
+)
+
+// TeardownResult lists what Teardown removed.
+type TeardownResult struct {
+	DeregisteredDevice bool
+	// DetachedCertificates are detached from the thing, DeletedCertificates also deleted
+	DetachedCertificates []string
+	DeletedCertificates  []string
+	DeletedPolicies      []string
+	DeletedThing         bool
+}
+
+// Teardown deregisters the device and deletes its iot thing, certificates and role alias
+// policies. Fleet resources shared with other devices, i.e. the fleet, role, policies,
+// thing type and bucket, are kept, and so are the local agent files. Resources that no
+// longer exist are skipped, so Teardown can be repeated after a failure.
+func (p *Provisioner) Teardown(ctx context.Context) (*TeardownResult, error) {
+	ctx = aws.WithLogger(ctx, p.logger)
+	opts := &p.opts
+	result := &TeardownResult{
+		DetachedCertificates: make([]string, 0),
+		DeletedCertificates:  make([]string, 0),
+		DeletedPolicies:      make([]string, 0),
+	}
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	p.logger.Printf("Deregistering device %s from fleet %s...\n", opts.DeviceName, opts.DeviceFleet)
+	deregistered, err := aws.DeregisterDevice(ctx, p.clients.Sagemaker, &opts.DeviceFleet, &opts.DeviceName)
+	if err != nil {
+		return result, &StepError{Step: "Deregister device", Err: err}
+	}
+	result.DeregisteredDevice = deregistered
+
+	thing, err := aws.GetIotThing(ctx, p.clients.Iot, &opts.IotThingName)
+	if err != nil {
+		return result, &StepError{Step: "Delete iot thing", Err: err}
+	}
+	if thing == nil {
+		return result, nil
+	}
+
+	principals, err := aws.ListThingCertificates(ctx, p.clients.Iot, &opts.IotThingName)
+	if err != nil {
+		return result, &StepError{Step: "Delete certificates", Err: err}
+	}
+	for _, principal := range principals {
+		principal := principal
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		p.logger.Printf("Removing certificate %s...\n", principal)
+		policies, deleted, err := aws.DeleteThingCertificate(ctx, p.clients.Iot, &principal, &opts.IotThingName)
+		result.DeletedPolicies = append(result.DeletedPolicies, policies...)
+		if err != nil {
+			return result, &StepError{Step: "Delete certificates", Err: err}
+		}
+		result.DetachedCertificates = append(result.DetachedCertificates, principal)
+		if deleted {
+			result.DeletedCertificates = append(result.DeletedCertificates, principal)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	p.logger.Printf("Deleting iot thing %s...\n", opts.IotThingName)
+	result.DeletedThing, err = aws.DeleteIotThing(ctx, p.clients.Iot, &opts.IotThingName)
+	if err != nil {
+		return result, &StepError{Step: "Delete iot thing", Err: err}
+	}
+	return result, nil
+}